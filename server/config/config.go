@@ -4,6 +4,7 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -15,18 +16,61 @@ var (
 	CtfdScenarioFolder              string
 	TopologyConfigFolder            string
 	PoolFolder                      string
+	JobFolder                       string
+	ScheduleFolder                  string
+	PoolTemplateFolder              string
+	RoleFile                        string
 	DatabaseLocation                string
+	AuthProvider                    string
+	PocketbaseUrl                   string
+	PocketbaseDataFile              string
+	PocketbaseAdminEmail            string
+	PocketbaseAdminPassword         string
+	PocketbaseUsersCollection       string
+	PocketbaseUserIdField           string
+	PocketbaseIsAdminField          string
+	PocketbaseApiKeyField           string
+	AuthCacheTTLSeconds             int
+	AuthCacheRevalidateSeconds      int
+	AuthCacheMaxEntries             int
+	SessionAccessTokenMinutes       int
+	SessionRefreshTokenHours        int
+	StoreDatabaseLocation           string
+	StoreBackend                    string
 	TimestampFormat                 string
 	LudusAdminUrl                   string
 	LudusUrl                        string
+	LudusApiVersion                 string
 	MaxConcurrentRequests           int
 	ProxmoxURL                      string
 	ProxmoxCertPath                 string
 	ProxmoxNodeName                 string
 	DeploySleepDuration             time.Duration
+	LudusServiceApiKey              string
+	DeployMaxAttempts               int
+	DeployRetryBackoffSeconds       int
+	DeployRetryableStates           []string
+	DeployRangeTimeoutMinutes       int
+	DeployBatchTimeoutMinutes       int
+	DeployTimeoutAction             string
+	ScheduleMissedRunGraceMinutes   int
+	UpstreamRetryMaxAttempts        int
+	UpstreamRetryBaseDelayMs        int
+	UpstreamRetryMaxDelayMs         int
+	CircuitBreakerFailureThreshold  int
+	CircuitBreakerOpenSeconds       int
+	LudusRequestTimeoutSeconds      int
+	ProxmoxRequestTimeoutSeconds    int
+	LudusCACert                     string
+	LudusPinnedCertSHA256           []string
+	ProxmoxCACert                   string
+	ProxmoxPinnedCertSHA256         []string
+	DefaultRole                     string
 )
 
-func init() {
+// Load reads .env and the environment into the configuration variables. It is
+// called once by main before anything else; tests set the variables they need.
+func Load() {
 	err := godotenv.Load(".env")
 	if err != nil {
 		log.Fatalf("Env file is not used")
@@ -42,6 +86,13 @@ func getEnv(key string) string {
 	return ""
 }
 
+func getEnvWithDefault(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
 func getEnvAsInt(key string) int {
 	valueStr := getEnv(key)
 	value, err := strconv.Atoi(valueStr)
@@ -51,23 +102,143 @@ func getEnvAsInt(key string) int {
 	return value
 }
 
+func getEnvAsIntWithDefault(key string, defaultValue int) int {
+	valueStr := os.Getenv(key)
+	if valueStr == "" {
+		return defaultValue
+	}
+	value, err := strconv.Atoi(valueStr)
+	if err != nil {
+		log.Fatalf("Environment variable %s must be an integer, but got: %s", key, valueStr)
+	}
+	return value
+}
+
+func getEnvAsListWithDefault(key, defaultValue string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnvWithDefault(key, defaultValue), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
 func loadVariables() {
 	MaxConcurrentRequests = getEnvAsInt("MAX_CONCURRENT_REQUESTS")
 	DataLocation := getEnv("DATA_LOCATION")
-	DatabaseLocation = getEnv("DATABASE_LOCATION")
+
+	// Where API keys are checked: the Ludus 1.x SQLite database (sqlite) or the
+	// Pocketbase users of Ludus 2.x (pocketbase), through its API or its data file
+	AuthProvider = strings.ToLower(getEnvWithDefault("AUTH_PROVIDER", "sqlite"))
+	switch AuthProvider {
+	case "sqlite":
+		DatabaseLocation = getEnv("DATABASE_LOCATION")
+	case "pocketbase":
+		PocketbaseUrl = strings.TrimSuffix(getEnvWithDefault("POCKETBASE_URL", ""), "/")
+		PocketbaseDataFile = getEnvWithDefault("POCKETBASE_DATA_FILE", "")
+		if (PocketbaseUrl == "") == (PocketbaseDataFile == "") {
+			log.Fatalf("Exactly one of the environment variables POCKETBASE_URL and POCKETBASE_DATA_FILE must be set")
+		}
+		if PocketbaseUrl != "" {
+			PocketbaseAdminEmail = getEnv("POCKETBASE_ADMIN_EMAIL")
+			PocketbaseAdminPassword = getEnv("POCKETBASE_ADMIN_PASSWORD")
+		}
+		PocketbaseUsersCollection = getEnvWithDefault("POCKETBASE_USERS_COLLECTION", "users")
+		PocketbaseUserIdField = getEnvWithDefault("POCKETBASE_USER_ID_FIELD", "userID")
+		PocketbaseIsAdminField = getEnvWithDefault("POCKETBASE_IS_ADMIN_FIELD", "isAdmin")
+		PocketbaseApiKeyField = getEnvWithDefault("POCKETBASE_API_KEY_FIELD", "hashedAPIKey")
+	default:
+		log.Fatalf("Environment variable AUTH_PROVIDER must be sqlite or pocketbase, but got: %s", AuthProvider)
+	}
 
 	LudusAdminUrl = getEnv("LUDUS_ADMIN_URL")
 	LudusUrl = getEnv("LUDUS_URL")
+
+	// Ludus API generation: auto (detect from the server version), 1 or 2
+	LudusApiVersion = strings.ToLower(getEnvWithDefault("LUDUS_API_VERSION", "auto"))
+	if LudusApiVersion != "auto" && LudusApiVersion != "1" && LudusApiVersion != "2" {
+		log.Fatalf("Environment variable LUDUS_API_VERSION must be auto, 1 or 2, but got: %s", LudusApiVersion)
+	}
+
 	ProxmoxURL = getEnv("PROXMOX_URL")
 
 	ProxmoxCertPath = getEnv("PROXMOX_CERT_PATH")
 	ProxmoxNodeName = getEnv("PROXMOX_NODE_NAME")
 	DeploySleepDuration = time.Duration(getEnvAsInt("DEPLOY_SLEEP_DURATION_SECONDS")) * time.Second
 
+	// Admin API key used for background work that outlives a request (job resume after restart)
+	LudusServiceApiKey = getEnvWithDefault("LUDUS_SERVICE_API_KEY", "")
+
+	// Retry policy for ranges that fail during deploy jobs (1 attempt = no retries)
+	DeployMaxAttempts = getEnvAsIntWithDefault("DEPLOY_MAX_ATTEMPTS", 1)
+	DeployRetryBackoffSeconds = getEnvAsIntWithDefault("DEPLOY_RETRY_BACKOFF_SECONDS", 60)
+	DeployRetryableStates = getEnvAsListWithDefault("DEPLOY_RETRYABLE_STATES", "ERROR,ABORTED")
+
+	// Deployment timeouts (0 disables) and what to do when they expire: ABORT, FAIL or PAUSE
+	DeployRangeTimeoutMinutes = getEnvAsIntWithDefault("DEPLOY_RANGE_TIMEOUT_MINUTES", 120)
+	DeployBatchTimeoutMinutes = getEnvAsIntWithDefault("DEPLOY_BATCH_TIMEOUT_MINUTES", 240)
+	DeployTimeoutAction = strings.ToUpper(getEnvWithDefault("DEPLOY_TIMEOUT_ACTION", "FAIL"))
+	if DeployTimeoutAction != "ABORT" && DeployTimeoutAction != "FAIL" && DeployTimeoutAction != "PAUSE" {
+		log.Fatalf("Environment variable DEPLOY_TIMEOUT_ACTION must be ABORT, FAIL or PAUSE, but got: %s", DeployTimeoutAction)
+	}
+
+	// Scheduled runs that are overdue by more than this (e.g. after downtime) are skipped
+	ScheduleMissedRunGraceMinutes = getEnvAsIntWithDefault("SCHEDULE_MISSED_RUN_GRACE_MINUTES", 15)
+
+	// Retries of idempotent Ludus and Proxmox calls (1 attempt = no retries) with jittered backoff
+	UpstreamRetryMaxAttempts = getEnvAsIntWithDefault("UPSTREAM_RETRY_MAX_ATTEMPTS", 3)
+	UpstreamRetryBaseDelayMs = getEnvAsIntWithDefault("UPSTREAM_RETRY_BASE_DELAY_MS", 500)
+	UpstreamRetryMaxDelayMs = getEnvAsIntWithDefault("UPSTREAM_RETRY_MAX_DELAY_MS", 5000)
+
+	// Consecutive failed calls after which an upstream is reported unavailable (0 disables)
+	CircuitBreakerFailureThreshold = getEnvAsIntWithDefault("CIRCUIT_BREAKER_FAILURE_THRESHOLD", 5)
+	CircuitBreakerOpenSeconds = getEnvAsIntWithDefault("CIRCUIT_BREAKER_OPEN_SECONDS", 30)
+
+	// Time limit of a single Ludus or Proxmox call including its retries (0 disables)
+	LudusRequestTimeoutSeconds = getEnvAsIntWithDefault("LUDUS_REQUEST_TIMEOUT_SECONDS", 60)
+	ProxmoxRequestTimeoutSeconds = getEnvAsIntWithDefault("PROXMOX_REQUEST_TIMEOUT_SECONDS", 30)
+
+	// Certificate trust for Ludus and Proxmox: a PEM CA bundle and/or SHA-256 fingerprints
+	// of the server certificate. With neither set certificates are not verified.
+	LudusCACert = getEnvWithDefault("LUDUS_CA_CERT", "")
+	LudusPinnedCertSHA256 = getEnvAsListWithDefault("LUDUS_PINNED_CERT_SHA256", "")
+	ProxmoxCACert = getEnvWithDefault("PROXMOX_CA_CERT", "")
+	ProxmoxPinnedCertSHA256 = getEnvAsListWithDefault("PROXMOX_PINNED_CERT_SHA256", "")
+
 	TemplateCtfdTopologyLocation = DataLocation + "/ctfd_topology.yml"
 	TemplateDevCtfdTopologyLocation = DataLocation + "/ctfd_dev_topology.yml"
 	CtfdScenarioFolder = DataLocation + "/ctfd_scenarios/"
 	TopologyConfigFolder = DataLocation + "/topologies/"
 	PoolFolder = DataLocation + "/pools/"
+	JobFolder = DataLocation + "/jobs/"
+	ScheduleFolder = DataLocation + "/schedules/"
+	PoolTemplateFolder = DataLocation + "/pool_templates/"
+	RoleFile = DataLocation + "/roles.json"
+
+	// Verified API keys are trusted for the TTL without bcrypt; their stored hash is
+	// looked up again after the revalidate interval (0 entries disables the cache)
+	AuthCacheTTLSeconds = getEnvAsIntWithDefault("AUTH_CACHE_TTL_SECONDS", 300)
+	AuthCacheRevalidateSeconds = getEnvAsIntWithDefault("AUTH_CACHE_REVALIDATE_SECONDS", 30)
+	AuthCacheMaxEntries = getEnvAsIntWithDefault("AUTH_CACHE_MAX_ENTRIES", 1000)
+
+	// How long the session tokens issued by POST /auth/login are valid
+	SessionAccessTokenMinutes = getEnvAsIntWithDefault("SESSION_ACCESS_TOKEN_MINUTES", 15)
+	SessionRefreshTokenHours = getEnvAsIntWithDefault("SESSION_REFRESH_TOKEN_HOURS", 12)
+
+	// Role of users without an assigned one; Ludus admins are always admin. The
+	// default keeps the access users had before roles existed.
+	DefaultRole = strings.ToLower(getEnvWithDefault("DEFAULT_ROLE", "instructor"))
+	if DefaultRole != "admin" && DefaultRole != "instructor" && DefaultRole != "observer" && DefaultRole != "student" {
+		log.Fatalf("Environment variable DEFAULT_ROLE must be admin, instructor, observer or student, but got: %s", DefaultRole)
+	}
+
+	// Where pools, topologies and scenarios are kept: our own database, separate from
+	// the Ludus database, or only the data folders
+	StoreDatabaseLocation = getEnvWithDefault("STORE_DATABASE_LOCATION", DataLocation+"/scenario-manager.db")
+	StoreBackend = strings.ToLower(getEnvWithDefault("STORE_BACKEND", "sqlite"))
+	if StoreBackend != "sqlite" && StoreBackend != "filesystem" {
+		log.Fatalf("Environment variable STORE_BACKEND must be sqlite or filesystem, but got: %s", StoreBackend)
+	}
 	TimestampFormat = "2006-01-02T15:04:05Z07:00"
 }
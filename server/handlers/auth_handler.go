@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"dulus/server/utils"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Login checks the Ludus API key in the X-API-Key header once and starts a
+// session for it. The key stays on the server; the client sends the access
+// token as "Authorization: Bearer <token>" instead.
+func Login(c *gin.Context) {
+	APIKey := c.Request.Header.Get("X-API-Key")
+	user, ok := utils.AuthenticateAPIKeyWithResponse(c, APIKey)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.CreateSession(user, APIKey))
+}
+
+// Refresh exchanges a refresh token for a new access and refresh token
+func Refresh(c *gin.Context) {
+	input, ok := utils.ValidateJSONSchema(c, "file://schemas/session_refresh_schema.json")
+	if !ok {
+		return
+	}
+
+	tokens, err := utils.RefreshSession(c.Request.Context(), input["refreshToken"].(string))
+	if errors.Is(err, utils.ErrInvalidSessionToken) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session token"})
+		return
+	}
+	if errors.Is(err, utils.ErrSessionRefreshInProgress) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Session refresh already in progress, retry later"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to refresh session: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// Revoke ends the session of the caller, or with a userId all sessions of that
+// user. Only admins may end the sessions of other users.
+func Revoke(c *gin.Context) {
+	if userId := c.Query("userId"); userId != "" {
+		if userId != c.GetString("userID") && c.GetString("role") != utils.RoleAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"revoked": utils.RevokeUserSessions(userId)})
+		return
+	}
+
+	// Requests made with an API key have no session to end
+	sessionId := c.GetString("sessionId")
+	if sessionId == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+	utils.RevokeSession(sessionId)
+	c.Status(http.StatusNoContent)
+}
+
+// GetAuthStatistics returns the hit and miss counters of the API key cache
+func GetAuthStatistics(c *gin.Context) {
+	c.JSON(http.StatusOK, utils.AuthCacheStatistics())
+}
@@ -1,7 +1,6 @@
 package handlers
 
 import (
-	"dulus/server/config"
 	"dulus/server/utils"
 	"fmt"
 	"net/http"
@@ -19,12 +18,11 @@ func GetCtfdLogins(c *gin.Context) {
 		return
 	}
 
-	poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
-	if !ok {
+	if !utils.ValidatePoolId(c, poolId) {
 		return
 	}
 
-	ctfdData, ok := utils.ReadCTFdJSON(c, poolPath)
+	ctfdData, ok := utils.ReadCTFdJSON(c, poolId)
 	if !ok {
 		return
 	}
@@ -58,12 +56,11 @@ func GetCtfdData(c *gin.Context) {
 		return
 	}
 
-	poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
-	if !ok {
+	if !utils.ValidatePoolId(c, poolId) {
 		return
 	}
 
-	ctfdData, ok := utils.ReadCTFdJSON(c, poolPath)
+	ctfdData, ok := utils.ReadCTFdJSON(c, poolId)
 	if !ok {
 		return
 	}
@@ -75,18 +72,13 @@ func GetCtfdData(c *gin.Context) {
 
 // Retrieve flags from deployed pools into ctfd_data.json
 func PutCtfdData(c *gin.Context) {
-	apiKey := c.Request.Header.Get("X-API-Key")
+	client := utils.LudusClientFromRequest(c)
 	poolId, ok := utils.GetRequiredQueryParam(c, "poolId")
 	if !ok {
 		return
 	}
 
-	poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
-	if !ok {
-		return
-	}
-
-	pool, ok := utils.ReadPoolWithResponse(c, poolPath)
+	pool, ok := utils.ReadPoolWithResponse(c, poolId)
 	if !ok {
 		return
 	}
@@ -100,11 +92,11 @@ func PutCtfdData(c *gin.Context) {
 		users = userIds
 	}
 
-	if !utils.AllRangesDeployed(users, apiKey, c) {
+	if !utils.AllRangesDeployed(client, users, c) {
 		return
 	}
 
-	flagsMap, ok := utils.GetFlagsForUsers(c, users, apiKey)
+	flagsMap, ok := utils.GetFlagsForUsers(c, client, users)
 	if !ok {
 		return
 	}
@@ -128,7 +120,7 @@ func PutCtfdData(c *gin.Context) {
 		}
 
 		ctfdUser := utils.CtfdUser{
-			User:     strings.ReplaceAll(userTeam.User, " ", ""),
+			User:     utils.MemberLoginName(userTeam),
 			Password: utils.RandomLowercaseString(5),
 			Team:     userTeam.Team,
 			Flags:    flags,
@@ -136,7 +128,7 @@ func PutCtfdData(c *gin.Context) {
 		ctfdUsers = append(ctfdUsers, ctfdUser)
 	}
 
-	if !utils.SaveCTFdData(c, poolPath, ctfdUsers) {
+	if !utils.SaveCTFdData(c, poolId, ctfdUsers) {
 		return
 	}
 
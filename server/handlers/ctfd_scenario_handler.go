@@ -1,11 +1,8 @@
 package handlers
 
 import (
-	"dulus/server/config"
 	"dulus/server/utils"
 	"net/http"
-	"os"
-	"path/filepath"
 
 	"github.com/gin-gonic/gin"
 )
@@ -25,28 +22,20 @@ func GetScenario(c *gin.Context) {
 func PutScenario(c *gin.Context) {
 	scenarioID := c.Query("scenarioId")
 
-	// First save the file using existing utility function
-	id, ok := utils.SaveUploadedFile(c, config.CtfdScenarioFolder, scenarioID, ".zip")
+	fileName, content, ok := utils.ReadUploadedFile(c, ".zip")
 	if !ok {
 		return
 	}
 
-	// Find the uploaded zip file path
-	scenarioPath := filepath.Join(config.CtfdScenarioFolder, id)
-	fileInfo, err := utils.ReadFirstFileInDir(scenarioPath)
+	// Validate the CTFd scenario zip file and get scenario mode
+	scenarioMode, err := utils.GetScenarioModeFromZip(content)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
 		return
 	}
 
-	zipPath := filepath.Join(scenarioPath, fileInfo.Name)
-
-	// Validate the CTFd scenario zip file and get scenario mode
-	scenarioMode, err := utils.GetScenarioModeFromZip(zipPath)
-	if err != nil {
-		// If validation fails, clean up the uploaded file
-		os.RemoveAll(scenarioPath)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+	id, ok := utils.SaveScenarioWithResponse(c, scenarioID, fileName, scenarioMode, content)
+	if !ok {
 		return
 	}
 
@@ -63,13 +52,7 @@ func DeleteScenario(c *gin.Context) {
 		return
 	}
 
-	scenarioPath, ok := utils.ValidateFolderId(c, config.CtfdScenarioFolder, scenarioID)
-	if !ok {
-		return
-	}
-
-	if err := os.RemoveAll(scenarioPath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+	if !utils.DeleteScenarioWithResponse(c, scenarioID) {
 		return
 	}
 
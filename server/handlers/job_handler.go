@@ -0,0 +1,88 @@
+package handlers
+
+import (
+	"dulus/server/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetJobs lists the deployment jobs of the pools the caller may view, optionally
+// filtered by poolId and status
+func GetJobs(c *gin.Context) {
+	poolId := utils.GetOptionalQueryParam(c, "poolId")
+	status := utils.GetOptionalQueryParam(c, "status")
+
+	jobs := utils.ListJobs(poolId, status)
+	canView := utils.PoolAccessFilter(c, utils.PoolAccessView)
+
+	var results []gin.H
+	for _, job := range jobs {
+		if !canView(job.PoolId) {
+			continue
+		}
+		results = append(results, gin.H{
+			"jobId":        job.JobId,
+			"poolId":       job.PoolId,
+			"type":         job.Type,
+			"status":       job.Status,
+			"createdBy":    job.CreatedBy,
+			"currentBatch": job.CurrentBatch,
+			"batchCount":   len(job.Batches),
+			"userCount":    len(job.Results),
+			"summary":      job.Summary(),
+			"createdAt":    job.CreatedAt,
+			"startedAt":    job.StartedAt,
+			"finishedAt":   job.FinishedAt,
+		})
+	}
+
+	c.JSON(http.StatusOK, results)
+}
+
+// GetJob returns a single job with per-batch progress and per-user results
+func GetJob(c *gin.Context) {
+	jobId := c.Param("jobId")
+
+	job, exists := utils.GetJob(jobId)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		return
+	}
+
+	if !utils.AuthorizePoolWithResponse(c, job.PoolId, utils.PoolAccessView) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"job":     job,
+		"summary": job.Summary(),
+	})
+}
+
+// ResumeJob continues a job that was paused after a deployment timeout
+func ResumeJob(c *gin.Context) {
+	jobId := c.Param("jobId")
+
+	job, exists := utils.GetJob(jobId)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		return
+	}
+
+	if !utils.AuthorizePoolWithResponse(c, job.PoolId, utils.PoolAccessManage) {
+		return
+	}
+
+	if job.Status != utils.JobStatusPaused {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job is not paused"})
+		return
+	}
+
+	if !utils.ResumePausedJob(jobId, utils.LudusClientFromRequest(c)) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job is not paused"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Job resumed", "jobId": jobId})
+}
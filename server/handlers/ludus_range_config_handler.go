@@ -14,12 +14,7 @@ func SetRangeConfig(c *gin.Context) {
 		return
 	}
 
-	poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
-	if !ok {
-		return
-	}
-
-	pool, ok := utils.ReadPoolWithResponse(c, poolPath)
+	pool, ok := utils.ReadPoolWithResponse(c, poolId)
 	if !ok {
 		return
 	}
@@ -29,20 +24,17 @@ func SetRangeConfig(c *gin.Context) {
 		return
 	}
 
-	topologyPath, ok := utils.ValidateFolderId(c, config.TopologyConfigFolder, pool.TopologyId)
+	topology, ok := utils.ReadTopologyWithResponse(c, pool.TopologyId)
 	if !ok {
 		return
 	}
 
-	// Read topology file
-	fileInfo, err := utils.ReadFirstFileInDir(topologyPath)
-	if utils.HandleFileReadError(c, err) {
-		return
-	}
+	client := utils.LudusClientFromRequest(c)
 
-	apiKey := c.Request.Header.Get("X-API-Key")
-
-	responses := utils.MakeConcurrentFileUploads(userIds, fileInfo.Content, true, apiKey, config.MaxConcurrentRequests)
+	tasks := utils.UserTasks(userIds, func(userId string) (interface{}, error) {
+		return client.PutConfig(userId, string(topology.Content), true)
+	})
+	responses := utils.RunConcurrentTasks(tasks, config.MaxConcurrentRequests)
 
 	results := utils.ConvertResponsesToResults(responses)
 
@@ -55,72 +47,35 @@ func GetRangeConfig(c *gin.Context) {
 		return
 	}
 
-	poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
-	if !ok {
-		return
-	}
-
-	pool, ok := utils.ReadPoolWithResponse(c, poolPath)
+	pool, ok := utils.ReadPoolWithResponse(c, poolId)
 	if !ok {
 		return
 	}
 
-	topologyPath, ok := utils.ValidateFolderId(c, config.TopologyConfigFolder, pool.TopologyId)
+	expectedTopology, ok := utils.ReadTopologyWithResponse(c, pool.TopologyId)
 	if !ok {
 		return
 	}
 
-	expectedTopologyFile, err := utils.ReadFirstFileInDir(topologyPath)
-	if utils.HandleFileReadError(c, err) {
-		return
-	}
-
 	userIds, ok := utils.GetUserIdsFromPool(c, poolId, utils.SharedMainUserOnly)
 	if !ok {
 		return
 	}
 
-	apiKey := c.Request.Header.Get("X-API-Key")
+	client := utils.LudusClientFromRequest(c)
 
 	// Check each user's config against the expected topology
 	matchPoolTopology := true
 
 	for _, userID := range userIds {
-		request := utils.LudusRequest{
-			Method:  "GET",
-			URL:     config.LudusUrl + "/range/config/?userID=" + userID,
-			Payload: nil,
-			UserID:  userID,
-		}
-
-		response := utils.MakeConcurrentLudusRequests([]utils.LudusRequest{request}, apiKey, 1)[0]
-
-		if response.Error != nil {
-			matchPoolTopology = false
-			break
-		}
-
-		// Extract the actual config content from the response
-		var userConfigContent string
-		if responseMap, ok := response.Response.(map[string]interface{}); ok {
-			if result, exists := responseMap["result"]; exists {
-				if resultStr, ok := result.(string); ok {
-					userConfigContent = resultStr
-				} else {
-					matchPoolTopology = false
-					break
-				}
-			} else {
-				matchPoolTopology = false
-				break
-			}
-		} else {
+		userConfigContent, err := client.GetConfig(userID)
+		if err != nil {
 			matchPoolTopology = false
 			break
 		}
 
 		// Compare the topology content with the user's config content
-		if !utils.CompareConfigs(expectedTopologyFile.Content, userConfigContent) {
+		if !utils.CompareConfigs(string(expectedTopology.Content), userConfigContent) {
 			matchPoolTopology = false
 			break
 		}
@@ -6,7 +6,6 @@ import (
 	"dulus/server/config"
 	"dulus/server/utils"
 	"encoding/base64"
-	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -23,19 +22,12 @@ func GetRangeAccess(c *gin.Context) {
 		return
 	}
 
-	apiKey := c.Request.Header.Get("X-API-Key")
+	client := utils.LudusClientFromRequest(c)
 
-	requests := make([]utils.LudusRequest, len(userIds))
-	for i, userID := range userIds {
-		requests[i] = utils.LudusRequest{
-			Method:  "GET",
-			URL:     config.LudusUrl + "/user/wireguard?userID=" + userID,
-			Payload: nil,
-			UserID:  userID,
-		}
-	}
-
-	responses := utils.MakeConcurrentLudusRequests(requests, apiKey, config.MaxConcurrentRequests)
+	tasks := utils.UserTasks(userIds, func(userId string) (interface{}, error) {
+		return client.GetWireguard(userId)
+	})
+	responses := utils.RunConcurrentTasks(tasks, config.MaxConcurrentRequests)
 
 	// Collect valid configs first
 	var validConfigs []struct {
@@ -48,21 +40,7 @@ func GetRangeAccess(c *gin.Context) {
 			continue // Skip failed requests
 		}
 
-		// Extract WireGuard config from nested response structure
-		var configContent string
-		if wireguardResp, ok := resp.Response.(map[string]interface{}); ok {
-			if result, exists := wireguardResp["result"]; exists {
-				if resultMap, ok := result.(map[string]interface{}); ok {
-					if config, exists := resultMap["wireGuardConfig"]; exists {
-						if configStr, ok := config.(string); ok {
-							configContent = configStr
-						} else {
-							configContent = fmt.Sprintf("%v", config)
-						}
-					}
-				}
-			}
-		}
+		configContent := resp.Response.(string)
 
 		if configContent != "" {
 			validConfigs = append(validConfigs, struct {
@@ -132,12 +110,7 @@ func ShareRange(c *gin.Context) {
 		return
 	}
 
-	poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
-	if !ok {
-		return
-	}
-
-	pool, ok := utils.ReadPoolWithResponse(c, poolPath)
+	pool, ok := utils.ReadPoolWithResponse(c, poolId)
 	if !ok {
 		return
 	}
@@ -147,28 +120,21 @@ func ShareRange(c *gin.Context) {
 		return
 	}
 
-	apiKey := c.Request.Header.Get("X-API-Key")
+	client := utils.LudusClientFromRequest(c)
 
 	// each user shares to their main user
-	var requests []utils.LudusRequest
+	var tasks []utils.LudusTask
 	for _, userTeam := range pool.UsersAndTeams {
 		if userTeam.MainUserId != "" {
-			payload := gin.H{
-				"action":       "grant",
-				"targetUserID": userTeam.MainUserId,
-				"sourceUserID": userTeam.UserId,
-				"force":        true,
-			}
-			requests = append(requests, utils.LudusRequest{
-				Method:  "POST",
-				URL:     config.LudusUrl + "/range/access",
-				Payload: payload,
-				UserID:  userTeam.UserId,
+			mainUserId, userId := userTeam.MainUserId, userTeam.UserId
+			tasks = append(tasks, utils.LudusTask{
+				UserID: userId,
+				Call:   func() (interface{}, error) { return client.GrantAccess(mainUserId, userId) },
 			})
 		}
 	}
 
-	responses := utils.MakeConcurrentLudusRequests(requests, apiKey, config.MaxConcurrentRequests)
+	responses := utils.RunConcurrentTasks(tasks, config.MaxConcurrentRequests)
 
 	results := utils.ConvertResponsesToResults(responses)
 
@@ -181,12 +147,7 @@ func UnshareRange(c *gin.Context) {
 		return
 	}
 
-	poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
-	if !ok {
-		return
-	}
-
-	pool, ok := utils.ReadPoolWithResponse(c, poolPath)
+	pool, ok := utils.ReadPoolWithResponse(c, poolId)
 	if !ok {
 		return
 	}
@@ -196,28 +157,21 @@ func UnshareRange(c *gin.Context) {
 		return
 	}
 
-	apiKey := c.Request.Header.Get("X-API-Key")
+	client := utils.LudusClientFromRequest(c)
 
 	// each user unshares from their main user
-	var requests []utils.LudusRequest
+	var tasks []utils.LudusTask
 	for _, userTeam := range pool.UsersAndTeams {
 		if userTeam.MainUserId != "" {
-			payload := gin.H{
-				"action":       "revoke",
-				"targetUserID": userTeam.MainUserId,
-				"sourceUserID": userTeam.UserId,
-				"force":        true,
-			}
-			requests = append(requests, utils.LudusRequest{
-				Method:  "POST",
-				URL:     config.LudusUrl + "/range/access",
-				Payload: payload,
-				UserID:  userTeam.UserId,
+			mainUserId, userId := userTeam.MainUserId, userTeam.UserId
+			tasks = append(tasks, utils.LudusTask{
+				UserID: userId,
+				Call:   func() (interface{}, error) { return client.RevokeAccess(mainUserId, userId) },
 			})
 		}
 	}
 
-	responses := utils.MakeConcurrentLudusRequests(requests, apiKey, config.MaxConcurrentRequests)
+	responses := utils.RunConcurrentTasks(tasks, config.MaxConcurrentRequests)
 
 	results := utils.ConvertResponsesToResults(responses)
 
@@ -230,12 +184,7 @@ func GetSharedRanges(c *gin.Context) {
 		return
 	}
 
-	poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
-	if !ok {
-		return
-	}
-
-	pool, ok := utils.ReadPoolWithResponse(c, poolPath)
+	pool, ok := utils.ReadPoolWithResponse(c, poolId)
 	if !ok {
 		return
 	}
@@ -246,20 +195,12 @@ func GetSharedRanges(c *gin.Context) {
 		return
 	}
 
-	apiKey := c.Request.Header.Get("X-API-Key")
-	response, err := utils.MakeLudusRequest("GET", config.LudusUrl+"/range/access", nil, apiKey)
+	rangeAccessList, err := utils.LudusClientFromRequest(c).GetRangeAccess()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
 		return
 	}
 
-	// Parse response as generic interface
-	rangeAccessList, ok := response.([]interface{})
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid response format"})
-		return
-	}
-
 	allShared := true
 	anyShared := false
 
@@ -272,20 +213,15 @@ func GetSharedRanges(c *gin.Context) {
 		isShared := false
 
 		// Check if this user is shared to their main user
-		for _, item := range rangeAccessList {
-			if itemMap, ok := item.(map[string]interface{}); ok {
-				if targetUserID, exists := itemMap["targetUserID"]; exists && targetUserID == userTeam.MainUserId {
-					if sourceUserIDs, exists := itemMap["sourceUserIDs"]; exists {
-						if sourceList, ok := sourceUserIDs.([]interface{}); ok {
-							for _, sourceID := range sourceList {
-								if sourceID == userTeam.UserId {
-									isShared = true
-									anyShared = true
-									break
-								}
-							}
-						}
-					}
+		for _, access := range rangeAccessList {
+			if access.TargetUserID != userTeam.MainUserId {
+				continue
+			}
+			for _, sourceID := range access.SourceUserIDs {
+				if sourceID == userTeam.UserId {
+					isShared = true
+					anyShared = true
+					break
 				}
 			}
 			if isShared {
@@ -319,17 +255,12 @@ func ShareRangeToUserId(c *gin.Context) {
 		return
 	}
 
-	poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
+	pool, ok := utils.ReadPoolWithResponse(c, poolId)
 	if !ok {
 		return
 	}
 
-	pool, ok := utils.ReadPoolWithResponse(c, poolPath)
-	if !ok {
-		return
-	}
-
-	apiKey := c.Request.Header.Get("X-API-Key")
+	client := utils.LudusClientFromRequest(c)
 
 	var users []string
 	if pool.Type == "SHARED" {
@@ -340,23 +271,15 @@ func ShareRangeToUserId(c *gin.Context) {
 		users = userIds
 	}
 
-	var requests []utils.LudusRequest
+	var tasks []utils.LudusTask
 	for _, user := range users {
-		payload := gin.H{
-			"action":       "grant",
-			"targetUserID": user,
-			"sourceUserID": targetUserId,
-			"force":        true,
-		}
-		requests = append(requests, utils.LudusRequest{
-			Method:  "POST",
-			URL:     config.LudusUrl + "/range/access",
-			Payload: payload,
-			UserID:  targetUserId,
+		tasks = append(tasks, utils.LudusTask{
+			UserID: targetUserId,
+			Call:   func() (interface{}, error) { return client.GrantAccess(user, targetUserId) },
 		})
 	}
 
-	responses := utils.MakeConcurrentLudusRequests(requests, apiKey, config.MaxConcurrentRequests)
+	responses := utils.RunConcurrentTasks(tasks, config.MaxConcurrentRequests)
 
 	results := utils.ConvertResponsesToResults(responses)
 
@@ -374,17 +297,12 @@ func UnshareRangeToUserId(c *gin.Context) {
 		return
 	}
 
-	poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
+	pool, ok := utils.ReadPoolWithResponse(c, poolId)
 	if !ok {
 		return
 	}
 
-	pool, ok := utils.ReadPoolWithResponse(c, poolPath)
-	if !ok {
-		return
-	}
-
-	apiKey := c.Request.Header.Get("X-API-Key")
+	client := utils.LudusClientFromRequest(c)
 
 	var users []string
 	if pool.Type == "SHARED" {
@@ -395,23 +313,15 @@ func UnshareRangeToUserId(c *gin.Context) {
 		users = userIds
 	}
 
-	var requests []utils.LudusRequest
+	var tasks []utils.LudusTask
 	for _, user := range users {
-		payload := gin.H{
-			"action":       "revoke",
-			"targetUserID": user,
-			"sourceUserID": targetUserId,
-			"force":        true,
-		}
-		requests = append(requests, utils.LudusRequest{
-			Method:  "POST",
-			URL:     config.LudusUrl + "/range/access",
-			Payload: payload,
-			UserID:  targetUserId,
+		tasks = append(tasks, utils.LudusTask{
+			UserID: targetUserId,
+			Call:   func() (interface{}, error) { return client.RevokeAccess(user, targetUserId) },
 		})
 	}
 
-	responses := utils.MakeConcurrentLudusRequests(requests, apiKey, config.MaxConcurrentRequests)
+	responses := utils.RunConcurrentTasks(tasks, config.MaxConcurrentRequests)
 
 	results := utils.ConvertResponsesToResults(responses)
 
@@ -429,17 +339,12 @@ func CheckRangeSharedToUserId(c *gin.Context) {
 		return
 	}
 
-	poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
-	if !ok {
-		return
-	}
-
-	pool, ok := utils.ReadPoolWithResponse(c, poolPath)
+	pool, ok := utils.ReadPoolWithResponse(c, poolId)
 	if !ok {
 		return
 	}
 
-	apiKey := c.Request.Header.Get("X-API-Key")
+	client := utils.LudusClientFromRequest(c)
 
 	var users []string
 	if pool.Type == "SHARED" {
@@ -450,19 +355,12 @@ func CheckRangeSharedToUserId(c *gin.Context) {
 		users = userIds
 	}
 
-	response, err := utils.MakeLudusRequest("GET", config.LudusUrl+"/range/access", nil, apiKey)
+	rangeAccessList, err := client.GetRangeAccess()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
 		return
 	}
 
-	// Parse response as generic interface
-	rangeAccessList, ok := response.([]interface{})
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid response format"})
-		return
-	}
-
 	// Initialize flags
 	anyShared := false
 	allShared := true
@@ -477,38 +375,25 @@ func CheckRangeSharedToUserId(c *gin.Context) {
 	foundUsers := make(map[string]bool)
 
 	// Iterate over rangeAccessList
-	for _, item := range rangeAccessList {
-		if itemMap, ok := item.(map[string]interface{}); ok {
-			if targetUserIDInterface, exists := itemMap["targetUserID"]; exists {
-				if targetUserID, ok := targetUserIDInterface.(string); ok {
-					// Check if this targetUserID is in our pool users
-					if userSet[targetUserID] {
-						foundUsers[targetUserID] = true
-						// Check if request.targetUserId is in sourceUserIDs
-						if sourceUserIDsInterface, exists := itemMap["sourceUserIDs"]; exists {
-							if sourceUserIDs, ok := sourceUserIDsInterface.([]interface{}); ok {
-								isShared := false
-								for _, sourceUserIDInterface := range sourceUserIDs {
-									if sourceUserID, ok := sourceUserIDInterface.(string); ok {
-										if sourceUserID == targetUserId {
-											isShared = true
-											anyShared = true
-											break
-										}
-									}
-								}
-								if !isShared {
-									allShared = false
-								}
-							}
-						} else {
-							// No sourceUserIDs found, so not shared
-							allShared = false
-						}
-					}
-				}
+	for _, access := range rangeAccessList {
+		// Check if this targetUserID is in our pool users
+		if !userSet[access.TargetUserID] {
+			continue
+		}
+		foundUsers[access.TargetUserID] = true
+
+		// Check if request.targetUserId is in sourceUserIDs
+		isShared := false
+		for _, sourceUserID := range access.SourceUserIDs {
+			if sourceUserID == targetUserId {
+				isShared = true
+				anyShared = true
+				break
 			}
 		}
+		if !isShared {
+			allShared = false
+		}
 	}
 
 	// Check if all pool users were found in rangeAccessList
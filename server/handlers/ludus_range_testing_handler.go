@@ -3,26 +3,25 @@ package handlers
 import (
 	"dulus/server/config"
 	"dulus/server/utils"
-	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
 )
 
 func PutTestingStart(c *gin.Context) {
-	utils.ExecuteTestingAction(c, "/testing/start/", nil)
+	utils.ExecuteTestingAction(c, utils.LudusClient.StartTesting)
 }
 
 func PutTestingStop(c *gin.Context) {
-	utils.ExecuteTestingAction(c, "/testing/stop/", gin.H{"force": true})
+	utils.ExecuteTestingAction(c, utils.LudusClient.StopTesting)
 }
 
 func PutPowerOn(c *gin.Context) {
-	utils.ExecuteTestingAction(c, "/range/poweron", gin.H{"machines": []string{"all"}})
+	utils.ExecuteTestingAction(c, utils.LudusClient.PowerOn)
 }
 
 func PutPowerOff(c *gin.Context) {
-	utils.ExecuteTestingAction(c, "/range/poweroff", gin.H{"machines": []string{"all"}})
+	utils.ExecuteTestingAction(c, utils.LudusClient.PowerOff)
 }
 
 func GetTestingStatus(c *gin.Context) {
@@ -31,12 +30,7 @@ func GetTestingStatus(c *gin.Context) {
 		return
 	}
 
-	poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
-	if !ok {
-		return
-	}
-
-	pool, ok := utils.ReadPoolWithResponse(c, poolPath)
+	pool, ok := utils.ReadPoolWithResponse(c, poolId)
 	if !ok {
 		return
 	}
@@ -50,65 +44,37 @@ func GetTestingStatus(c *gin.Context) {
 		users = userIds
 	}
 
-	apiKey := c.Request.Header.Get("X-API-Key")
-
-	requests := make([]utils.LudusRequest, len(users))
-	for i, userID := range users {
-		requests[i] = utils.LudusRequest{
-			Method:  "GET",
-			URL:     config.LudusUrl + "/range?userID=" + userID,
-			Payload: nil,
-			UserID:  userID,
-		}
-	}
+	client := utils.LudusClientFromRequest(c)
 
-	responses := utils.MakeConcurrentLudusRequests(requests, apiKey, config.MaxConcurrentRequests)
+	tasks := utils.UserTasks(users, func(userId string) (interface{}, error) {
+		return client.GetRange(userId)
+	})
+	responses := utils.RunConcurrentTasks(tasks, config.MaxConcurrentRequests)
 
 	// Check testing enabled status
 	var testingEnabledValues []bool
 	allPoweredOn := true
 	atLeastOneRangeHasNoVMs := false
+	failed := []gin.H{}
 
 	for _, resp := range responses {
-		if resp.Error != nil || resp.Response == nil {
+		// Skip users that don't exist or have no range, report other failures
+		if resp.Error != nil {
+			if !utils.IsLudusNotFound(resp.Error) {
+				failed = append(failed, utils.ResponseResult(resp))
+			}
 			continue
 		}
 
-		// Check if response is an empty array (user doesn't exist)
-		if respArray, ok := resp.Response.([]interface{}); ok && len(respArray) == 0 {
-			continue
+		rangeInfo := resp.Response.(utils.LudusRange)
+		if rangeInfo.NumberOfVMs == 0 {
+			atLeastOneRangeHasNoVMs = true
 		}
+		testingEnabledValues = append(testingEnabledValues, rangeInfo.TestingEnabled)
 
-		// Parse the response to extract testingEnabled
-		if respMap, ok := resp.Response.(map[string]interface{}); ok {
-			if numberOfVMs, ok := respMap["numberOfVMs"].(float64); ok {
-				if int(numberOfVMs) == 0 {
-					atLeastOneRangeHasNoVMs = true
-				}
-			}
-			fmt.Println(respMap)
-			if testingEnabled, exists := respMap["testingEnabled"]; exists {
-				if boolValue, ok := testingEnabled.(bool); ok {
-					testingEnabledValues = append(testingEnabledValues, boolValue)
-				}
-			}
-			// if VMs exist, check their power state if at least one is off than you dont have to check the rest
-			if VMs, exists := respMap["VMs"]; exists && allPoweredOn {
-				if vmsArray, ok := VMs.([]interface{}); ok && len(vmsArray) > 0 {
-					fmt.Println(vmsArray...)
-					for _, vm := range vmsArray {
-						if vmMap, ok := vm.(map[string]interface{}); ok {
-							if poweredOn, exists := vmMap["poweredOn"]; exists {
-								fmt.Println(vmMap["name"], poweredOn)
-								if poweredOnBool, ok := poweredOn.(bool); ok && !poweredOnBool {
-									allPoweredOn = false
-									break
-								}
-							}
-						}
-					}
-				}
-			}
+		// if at least one VM is off you dont have to check the rest
+		if allPoweredOn && !rangeInfo.AllPoweredOn() {
+			allPoweredOn = false
 		}
 	}
 
@@ -142,5 +108,6 @@ func GetTestingStatus(c *gin.Context) {
 		"allSame":        allSame,
 		"testingEnabled": testingEnabledValue,
 		"poweredOn":      powerState,
+		"failed":         failed,
 	})
 }
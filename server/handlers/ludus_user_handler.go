@@ -14,29 +14,28 @@ func ImportUsers(c *gin.Context) {
 		return
 	}
 
-	userIds, ok := utils.GetUserIdsFromPool(c, poolId, utils.SharedAllUsers)
+	pool, ok := utils.ReadPoolWithResponse(c, poolId)
 	if !ok {
 		return
 	}
+	userIds := utils.PoolUserIds(pool, utils.SharedAllUsers)
 
-	apiKey := c.Request.Header.Get("X-API-Key")
-
-	requests := make([]utils.LudusRequest, len(userIds))
-	for i, userID := range userIds {
-		payload := gin.H{
-			"name":    userID,
-			"userID":  userID,
-			"isAdmin": false,
-		}
-		requests[i] = utils.LudusRequest{
-			Method:  "POST",
-			URL:     config.LudusAdminUrl + "/user",
-			Payload: payload,
-			UserID:  userID,
-		}
+	// Members get their display name in Ludus, main users are named by userId
+	names := make(map[string]string)
+	for _, user := range pool.UsersAndTeams {
+		names[user.UserId] = user.User
 	}
 
-	responses := utils.MakeConcurrentLudusRequests(requests, apiKey, config.MaxConcurrentRequests)
+	client := utils.LudusClientFromRequest(c)
+
+	tasks := utils.UserTasks(userIds, func(userId string) (interface{}, error) {
+		name := names[userId]
+		if name == "" {
+			name = userId
+		}
+		return client.AddUser(userId, name, false)
+	})
+	responses := utils.RunConcurrentTasks(tasks, config.MaxConcurrentRequests)
 
 	results := utils.ConvertResponsesToResults(responses)
 
@@ -74,19 +73,12 @@ func DeleteUsers(c *gin.Context) {
 		return
 	}
 
-	apiKey := c.Request.Header.Get("X-API-Key")
+	client := utils.LudusClientFromRequest(c)
 
-	requests := make([]utils.LudusRequest, len(userIds))
-	for i, userID := range userIds {
-		requests[i] = utils.LudusRequest{
-			Method:  "DELETE",
-			URL:     config.LudusAdminUrl + "/user/" + userID,
-			Payload: nil,
-			UserID:  userID,
-		}
-	}
-
-	responses := utils.MakeConcurrentLudusRequests(requests, apiKey, config.MaxConcurrentRequests)
+	tasks := utils.UserTasks(userIds, func(userId string) (interface{}, error) {
+		return client.DeleteUser(userId)
+	})
+	responses := utils.RunConcurrentTasks(tasks, config.MaxConcurrentRequests)
 
 	results := utils.ConvertResponsesToResults(responses)
 
@@ -104,72 +96,48 @@ func CheckUsers(c *gin.Context) {
 		return
 	}
 
-	apiKey := c.Request.Header.Get("X-API-Key")
-
-	requests := make([]utils.LudusRequest, len(userIds))
-	for i, userID := range userIds {
-		requests[i] = utils.LudusRequest{
-			Method:  "GET",
-			URL:     config.LudusUrl + "/user?userID=" + userID,
-			Payload: nil,
-			UserID:  userID,
-		}
-	}
+	client := utils.LudusClientFromRequest(c)
 
-	responses := utils.MakeConcurrentLudusRequests(requests, apiKey, config.MaxConcurrentRequests)
+	tasks := utils.UserTasks(userIds, func(userId string) (interface{}, error) {
+		return client.GetUser(userId)
+	})
+	responses := utils.RunConcurrentTasks(tasks, config.MaxConcurrentRequests)
 
-	// Collect IDs of users that don't exist
+	// Collect IDs of users that don't exist, other failures say nothing about existence
 	var missingUserIds []string
+	failed := []gin.H{}
 	for _, resp := range responses {
-		exists := false
-
-		if resp.Error == nil && resp.Response != nil {
-			// Check if response is an empty array
-			if respArray, ok := resp.Response.([]interface{}); ok {
-				exists = len(respArray) > 0
-			} else {
-				// If it's not an array, assume user exists
-				exists = true
-			}
+		if resp.Error == nil {
+			continue
 		}
-
-		if !exists {
+		if utils.IsLudusNotFound(resp.Error) {
 			missingUserIds = append(missingUserIds, resp.UserID)
+		} else {
+			failed = append(failed, utils.ResponseResult(resp))
 		}
 	}
 
-	allExist := len(missingUserIds) == 0
+	allExist := len(missingUserIds) == 0 && len(failed) == 0
 
 	c.JSON(http.StatusOK, gin.H{
 		"missingUserIds": missingUserIds,
 		"allExist":       allExist,
+		"failed":         failed,
 	})
 }
 
 func GetAllMainUsers(c *gin.Context) {
 	current := utils.GetOptionalQueryParam(c, "current")
-	apiKey := c.Request.Header.Get("X-API-Key")
-
 	// Get all users from Ludus API
-	response, err := utils.MakeLudusRequest("GET", config.LudusUrl+"/user/all", nil, apiKey)
+	users, err := utils.LudusClientFromRequest(c).ListUsers()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users from Ludus"})
 		return
 	}
 
-	// Parse response to extract userIDs
 	var allUserIds []string
-	if userArray, ok := response.([]interface{}); ok {
-		for _, user := range userArray {
-			if userMap, ok := user.(map[string]interface{}); ok {
-				if userID, exists := userMap["userID"].(string); exists {
-					allUserIds = append(allUserIds, userID)
-				}
-			}
-		}
-	} else {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid response format from Ludus"})
-		return
+	for _, user := range users {
+		allUserIds = append(allUserIds, user.UserID)
 	}
 
 	// Get main users from pools
@@ -192,7 +160,7 @@ func GetAllMainUsers(c *gin.Context) {
 	}
 
 	// Get all users (both userIds and mainUserIds) from all pools
-	allPoolUsers, err := utils.GetAllUserIdsFromPools(config.PoolFolder)
+	allPoolUsers, err := utils.GetAllUserIdsFromPools()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve pool users"})
 		return
@@ -210,13 +178,8 @@ func GetAllMainUsers(c *gin.Context) {
 	poolId := utils.GetOptionalQueryParam(c, "poolId")
 	if poolId != "" {
 		// Validate poolId and get pool path
-		poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
-		if !ok {
-			return
-		}
-
 		// Get pool data to extract main users for this specific pool
-		pool, ok := utils.ReadPoolWithResponse(c, poolPath)
+		pool, ok := utils.ReadPoolWithResponse(c, poolId)
 		if !ok {
 			return
 		}
@@ -5,9 +5,9 @@ import (
 	"dulus/server/utils"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
@@ -19,74 +19,69 @@ func PostPool(c *gin.Context) {
 	}
 
 	// Check that we can pull the userID and apikey from what the user provided
-	APIKey := c.Request.Header.Get("X-API-Key")
+	APIKey := utils.RequestAPIKey(c)
 	userID, ok := utils.ExtractUserIDFromAPIKey(c, APIKey)
 	if !ok {
 		return
 	}
-	// Add createdBy to input
-	input["createdBy"] = userID
 	poolType, _ := input["type"].(string)
 
 	// Validate TopologyId
 	topologyId := input["topologyId"].(string)
-	if _, ok := utils.ValidateFolderId(c, config.TopologyConfigFolder, topologyId); !ok {
+	if _, ok := utils.ReadTopologyWithResponse(c, topologyId); !ok {
 		return
 	}
 
+	pool := utils.Pool{
+		CreatedBy:  userID,
+		Note:       input["note"].(string),
+		TopologyId: topologyId,
+		Type:       poolType,
+	}
+
 	// Validate and process UsersAndTeams
 	if usersAndTeams, ok := input["usersAndTeams"].([]interface{}); ok && len(usersAndTeams) > 0 {
-		processedUsers, err := utils.ValidateAndProcessUsersAndTeams(usersAndTeams, poolType, utils.OperationCreate)
+		ludusUserIds := utils.LudusUserIds(utils.LudusClientFromRequest(c))
+		processedUsers, err := utils.ValidateAndProcessUsersAndTeams(usersAndTeams, poolType, utils.OperationCreate, ludusUserIds)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+			return
+		}
+		pool.UsersAndTeams, err = utils.PoolUsersFromMaps(processedUsers)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
 			return
 		}
-		input["usersAndTeams"] = processedUsers
-	}
-
-	// Generate pool id and create folder
-	poolId, err := utils.GenerateUniqueID(config.PoolFolder)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-		return
-	}
-
-	poolPath := filepath.Join(config.PoolFolder, poolId)
-	if err := os.MkdirAll(poolPath, os.ModePerm); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-		return
 	}
 
 	// Save pool data
-	if !utils.WritePoolDataWithResponse(c, poolPath, input) {
+	poolId, ok := utils.CreatePoolWithResponse(c, pool)
+	if !ok {
 		return
 	}
 
+	// Members keep their names, the response maps them to the generated userIds
+	c.JSON(http.StatusOK, gin.H{"message": "Uploaded successfully", "id": poolId, "usersAndTeams": utils.PoolMembersResponse(pool.UsersAndTeams)})
 }
 
 func PostPoolDev(c *gin.Context) {
 	// Get API key from header
-	APIKey := c.Request.Header.Get("X-API-Key")
+	APIKey := utils.RequestAPIKey(c)
 	userID, ok := utils.ExtractUserIDFromAPIKey(c, APIKey)
 	if !ok {
 		return
 	}
 
 	// Get username from Ludus API
-	userResponse, err := utils.MakeLudusRequest("GET", config.LudusUrl+"/user", nil, APIKey)
-	if err != nil {
+	user, err := utils.NewLudusClient(APIKey).GetCurrentUser()
+	if err != nil && !utils.IsLudusNotFound(err) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
 		return
 	}
 
-	// Extract username from response
 	username := userID // default fallback
-	if userArray, ok := userResponse.([]interface{}); ok && len(userArray) > 0 {
-		if userMap, ok := userArray[0].(map[string]interface{}); ok {
-			if nameStr, ok := userMap["name"].(string); ok {
-				username = nameStr
-			}
-		}
+	if user.Name != "" {
+		username = user.Name
 	}
 
 	// Parse request body to get note
@@ -98,112 +93,139 @@ func PostPoolDev(c *gin.Context) {
 		return
 	}
 
-	// Generate pool id and create folder
-	poolId, err := utils.GenerateUniqueID(config.PoolFolder)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-		return
-	}
-
-	poolPath := filepath.Join(config.PoolFolder, poolId)
-	if err := os.MkdirAll(poolPath, os.ModePerm); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-		return
-	}
-
 	// Create the fixed pool structure
-	poolData := map[string]interface{}{
-		"createdBy":  userID,
-		"note":       requestBody.Note,
-		"topologyId": "ctfdev",
-		"type":       "INDIVIDUAL",
-		"usersAndTeams": []map[string]interface{}{
+	pool := utils.Pool{
+		CreatedBy:  userID,
+		Note:       requestBody.Note,
+		TopologyId: "ctfdev",
+		Type:       "INDIVIDUAL",
+		UsersAndTeams: []utils.PoolUser{
 			{
-				"user":   username,
-				"userId": userID,
+				User:   username,
+				UserId: userID,
 			},
 		},
 	}
 
 	// Save pool data
-	if !utils.WritePoolDataWithResponse(c, poolPath, poolData) {
+	poolId, ok := utils.CreatePoolWithResponse(c, pool)
+	if !ok {
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Uploaded successfully", "id": poolId})
 }
 
-func PatchPoolTopology(c *gin.Context) {
+// PostPoolClone creates a pool with the type, topology, note and teams of an
+// existing one. New members are spread over the source pool's teams unless they
+// name their own.
+func PostPoolClone(c *gin.Context) {
 	poolId, ok := utils.GetRequiredQueryParam(c, "poolId")
 	if !ok {
 		return
 	}
 
-	poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
+	source, ok := utils.ReadPoolWithResponse(c, poolId)
 	if !ok {
 		return
 	}
 
-	input, ok := utils.ValidateJSONSchema(c, "file://schemas/pool_topology_schema.json")
+	input, ok := utils.ValidateJSONSchema(c, "file://schemas/pool_clone_schema.json")
 	if !ok {
 		return
 	}
 
-	// Validate TopologyId exists
-	topologyId := input["topologyId"].(string)
-	if _, ok := utils.ValidateFolderId(c, config.TopologyConfigFolder, topologyId); !ok {
+	userID, ok := utils.ExtractUserIDFromAPIKey(c, utils.RequestAPIKey(c))
+	if !ok {
 		return
 	}
 
-	pool, ok := utils.ReadPoolWithResponse(c, poolPath)
-	if !ok {
-		return
+	pool := utils.Pool{
+		CreatedBy:  userID,
+		Note:       source.Note,
+		TopologyId: source.TopologyId,
+		Type:       source.Type,
+	}
+	if note, exists := input["note"].(string); exists {
+		pool.Note = note
 	}
 
-	pool.TopologyId = topologyId
+	if usersAndTeams, exists := input["usersAndTeams"].([]interface{}); exists {
+		users, err := utils.PoolUsersFromMaps(usersAndTeams)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+			return
+		}
+		utils.AssignTeams(users, utils.PoolTeams(source))
 
-	// Convert to map for existing write helper
-	poolBytes, _ := json.Marshal(pool)
-	var poolMap map[string]interface{}
-	json.Unmarshal(poolBytes, &poolMap)
+		pool.UsersAndTeams, ok = utils.PoolMembersWithResponse(c, users, pool.Type)
+		if !ok {
+			return
+		}
+	}
 
-	if !utils.WritePoolDataWithResponse(c, poolPath, poolMap) {
+	newPoolId, ok := utils.CreatePoolWithResponse(c, pool)
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Updated successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Cloned successfully", "id": newPoolId, "usersAndTeams": utils.PoolMembersResponse(pool.UsersAndTeams)})
 }
 
-func PatchPoolNote(c *gin.Context) {
+func PatchPoolTopology(c *gin.Context) {
 	poolId, ok := utils.GetRequiredQueryParam(c, "poolId")
 	if !ok {
 		return
 	}
 
-	poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
+	if !utils.ValidatePoolId(c, poolId) {
+		return
+	}
+
+	input, ok := utils.ValidateJSONSchema(c, "file://schemas/pool_topology_schema.json")
 	if !ok {
 		return
 	}
 
-	input, ok := utils.ValidateJSONSchema(c, "file://schemas/pool_note_schema.json")
+	// Validate TopologyId exists
+	topologyId := input["topologyId"].(string)
+	if _, ok := utils.ReadTopologyWithResponse(c, topologyId); !ok {
+		return
+	}
+
+	_, ok = utils.UpdatePoolWithResponse(c, poolId, utils.PoolActionTopology, func(pool *utils.Pool) error {
+		pool.TopologyId = topologyId
+		return nil
+	})
 	if !ok {
 		return
 	}
 
-	pool, ok := utils.ReadPoolWithResponse(c, poolPath)
+	c.JSON(http.StatusOK, gin.H{"message": "Updated successfully"})
+}
+
+func PatchPoolNote(c *gin.Context) {
+	poolId, ok := utils.GetRequiredQueryParam(c, "poolId")
 	if !ok {
 		return
 	}
 
-	if noteStr, ok := input["note"].(string); ok {
-		pool.Note = noteStr
+	if !utils.ValidatePoolId(c, poolId) {
+		return
 	}
 
-	poolBytes, _ := json.Marshal(pool)
-	var poolMap map[string]interface{}
-	json.Unmarshal(poolBytes, &poolMap)
+	input, ok := utils.ValidateJSONSchema(c, "file://schemas/pool_note_schema.json")
+	if !ok {
+		return
+	}
 
-	if !utils.WritePoolDataWithResponse(c, poolPath, poolMap) {
+	_, ok = utils.UpdatePoolWithResponse(c, poolId, utils.PoolActionNote, func(pool *utils.Pool) error {
+		if noteStr, ok := input["note"].(string); ok {
+			pool.Note = noteStr
+		}
+		return nil
+	})
+	if !ok {
 		return
 	}
 
@@ -216,8 +238,7 @@ func PatchPoolUsers(c *gin.Context) {
 		return
 	}
 
-	poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
-	if !ok {
+	if !utils.ValidatePoolId(c, poolId) {
 		return
 	}
 
@@ -226,46 +247,112 @@ func PatchPoolUsers(c *gin.Context) {
 		return
 	}
 
-	pool, ok := utils.ReadPoolWithResponse(c, poolPath)
+	// Get new users from request
+	newUsersAndTeams, ok := input["usersAndTeams"].([]interface{})
 	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
 		return
 	}
 
-	// Get new users from request
-	newUsersAndTeams, ok := input["usersAndTeams"].([]interface{})
+	// Ludus is asked before the update, which runs inside a store transaction
+	ludusUserIds := utils.LudusUserIds(utils.LudusClientFromRequest(c))
+
+	// Combine with the current members inside the update, so concurrent calls do not overwrite each other
+	var added []utils.PoolUser
+	_, ok = utils.UpdatePoolWithResponse(c, poolId, utils.PoolActionUsers, func(pool *utils.Pool) error {
+		// Convert existing pool.UsersAndTeams into []interface{}
+		existingBytes, _ := json.Marshal(pool.UsersAndTeams)
+		var existingUsersAndTeams []interface{}
+		json.Unmarshal(existingBytes, &existingUsersAndTeams)
+
+		// Combine existing and new users
+		combinedUsers := append(existingUsersAndTeams, newUsersAndTeams...)
+
+		// Validate and process the combined user list
+		processedUsers, err := utils.ValidateAndProcessUsersAndTeams(combinedUsers, pool.Type, utils.OperationAdd, ludusUserIds)
+		if err != nil {
+			return fmt.Errorf("%w: %v", utils.ErrInvalidPool, err)
+		}
+
+		pool.UsersAndTeams, err = utils.PoolUsersFromMaps(processedUsers)
+		if err != nil {
+			return fmt.Errorf("%w: %v", utils.ErrInvalidPool, err)
+		}
+		added = pool.UsersAndTeams[len(existingUsersAndTeams):]
+		return nil
+	})
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Users added successfully", "usersAndTeams": utils.PoolMembersResponse(added)})
+}
+
+// GetPoolMigrationReport lists the pools imported from pool folders on startup
+// and the ones that were skipped and are retried on the next start
+func GetPoolMigrationReport(c *gin.Context) {
+	c.JSON(http.StatusOK, utils.FolderMigration())
+}
+
+// ImportPoolUsers adds the members of an uploaded CSV or XLSX roster to a pool.
+// Every row is validated and reported; with dryRun=true, or when any row is
+// invalid, nothing is added.
+func ImportPoolUsers(c *gin.Context) {
+	poolId, ok := utils.GetRequiredQueryParam(c, "poolId")
+	if !ok {
+		return
+	}
+
+	if !utils.ValidatePoolIdFormat(c, poolId) {
+		return
+	}
+
+	fileName, content, ok := utils.ReadUploadedFile(c, ".csv", ".xlsx")
 	if !ok {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
 		return
 	}
 
-	// Convert existing pool.UsersAndTeams into []interface{}
-	existingBytes, _ := json.Marshal(pool.UsersAndTeams)
-	var existingUsersAndTeams []interface{}
-	json.Unmarshal(existingBytes, &existingUsersAndTeams)
+	columns := utils.RosterColumns{
+		Name:       c.PostForm("nameColumn"),
+		Team:       c.PostForm("teamColumn"),
+		MainUserId: c.PostForm("mainUserIdColumn"),
+	}
+	rows, err := utils.ReadRoster(fileName, content, columns)
+	if err != nil {
+		log.Printf("Rejected roster %s for pool %s: %v", fileName, poolId, err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	// Combine existing and new users
-	combinedUsers := append(existingUsersAndTeams, newUsersAndTeams...)
+	pool, ok := utils.ReadPoolWithResponse(c, poolId)
+	if !ok {
+		return
+	}
 
-	// Validate and process the combined user list
-	poolType := pool.Type
-	processedUsers, err := utils.ValidateAndProcessUsersAndTeams(combinedUsers, poolType, utils.OperationAdd)
+	report, err := utils.ValidateRoster(pool, rows, utils.LudusUserIds(utils.LudusClientFromRequest(c)))
 	if err != nil {
-		fmt.Println(err)
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
 		return
 	}
 
-	// Convert pool struct to map and set processed users
-	poolBytes, _ := json.Marshal(pool)
-	var poolMap map[string]interface{}
-	json.Unmarshal(poolBytes, &poolMap)
-	poolMap["usersAndTeams"] = processedUsers
+	if utils.GetOptionalQueryParam(c, "dryRun") == "true" {
+		c.JSON(http.StatusOK, report)
+		return
+	}
+	if !report.Valid {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request", "report": report})
+		return
+	}
 
-	if !utils.WritePoolDataWithResponse(c, poolPath, poolMap) {
+	// The members are checked again inside the update, the pool may have changed since
+	_, ok = utils.UpdatePoolWithResponse(c, poolId, utils.PoolActionUsers, func(pool *utils.Pool) error {
+		return utils.AddRosterMembers(pool, report.Members())
+	})
+	if !ok {
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Users added successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Users imported successfully", "report": report})
 }
 
 func GetPool(c *gin.Context) {
@@ -279,15 +366,12 @@ func GetPool(c *gin.Context) {
 	}
 
 	if poolId != "" {
-		poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
-		if !ok {
-			return
-		}
-
-		pool, ok := utils.ReadPoolWithResponse(c, poolPath)
+		pool, ok := utils.ReadPoolWithResponse(c, poolId)
 		if !ok {
 			return
 		}
+		// Clients send it back in If-Match when they change the pool
+		utils.SetPoolETag(c, pool)
 
 		// Convert pool struct to map for endpoints that expect a map
 		poolBytes, _ := json.Marshal(pool)
@@ -307,20 +391,18 @@ func GetPool(c *gin.Context) {
 		}
 
 		poolMap["poolId"] = poolId
-		poolMap["ctfdData"] = utils.HasCtfdData(poolPath)
-
-		// Get creation time from pool.json file (same logic as GetAllPools)
-		poolJsonPath := filepath.Join(poolPath, "pool.json")
-		if fileInfo, err := os.Stat(poolJsonPath); err == nil {
-			poolMap["createdAt"] = fileInfo.ModTime().Format(config.TimestampFormat)
+		for key, value := range utils.PoolAccessResponse(pool) {
+			poolMap[key] = value
 		}
+		poolMap["ctfdData"] = utils.HasCtfdData(poolId)
+		poolMap["createdAt"] = pool.CreatedAt.Format(config.TimestampFormat)
 
 		c.JSON(http.StatusOK, poolMap)
 		return
 	}
 
 	// Return all pools
-	pools, err := utils.GetAllPools(config.PoolFolder)
+	pools, err := utils.GetAllPools(c)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
 		return
@@ -329,23 +411,278 @@ func GetPool(c *gin.Context) {
 	c.JSON(http.StatusOK, pools)
 }
 
+// DeletePool deletes a pool. With cascade=true it first unshares, destroys and
+// deletes the pool's ranges and users in Ludus as a tracked job, which deletes
+// the pool once everything is cleaned up.
 func DeletePool(c *gin.Context) {
 	poolId, ok := utils.GetRequiredQueryParam(c, "poolId")
 	if !ok {
 		return
 	}
 
-	poolPath, ok := utils.ValidateFolderId(c, config.PoolFolder, poolId)
+	if utils.GetOptionalQueryParam(c, "cascade") == "true" {
+		pool, ok := utils.ReadPoolWithResponse(c, poolId)
+		if !ok {
+			return
+		}
+
+		// The job runs in background, use /jobs/:jobId to monitor progress
+		job, err := utils.StartPoolDeleteJob(utils.LudusClientFromRequest(c), pool, c.GetString("userID"))
+		if err == utils.ErrPoolJobActive {
+			c.JSON(http.StatusConflict, gin.H{"error": "Pool has an active job"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"message": "Pool deletion started",
+			"jobId":   job.JobId,
+		})
+		return
+	}
+
+	if !utils.DeletePoolWithResponse(c, poolId) {
+		return
+	}
+
+	// Schedules of a deleted pool would only fail from now on
+	utils.DeletePoolSchedules(poolId)
+
+	c.Status(http.StatusNoContent)
+}
+
+// RemovePoolUsers removes members from a pool by userId and optionally unshares,
+// destroys and deletes what they had in Ludus
+func RemovePoolUsers(c *gin.Context) {
+	poolId, ok := utils.GetRequiredQueryParam(c, "poolId")
+	if !ok {
+		return
+	}
+
+	if !utils.ValidatePoolId(c, poolId) {
+		return
+	}
+
+	input, ok := utils.ValidateJSONSchema(c, "file://schemas/pool_users_remove_schema.json")
+	if !ok {
+		return
+	}
+
+	inputBytes, _ := json.Marshal(input)
+	var request struct {
+		UserIds []string `json:"userIds"`
+		utils.MemberCleanup
+	}
+	if err := json.Unmarshal(inputBytes, &request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+
+	var removed []utils.PoolUser
+	pool, ok := utils.UpdatePoolWithResponse(c, poolId, utils.PoolActionUsersRemove, func(pool *utils.Pool) error {
+		var err error
+		removed, err = utils.RemovePoolMembers(pool, request.UserIds)
+		return err
+	})
+	if !ok {
+		return
+	}
+
+	client := utils.LudusClientFromRequest(c)
+	results := utils.CleanupRemovedMembers(c.Request.Context(), client, pool, removed, request.MemberCleanup)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Users removed successfully",
+		"results": stepResults(results),
+	})
+}
+
+// PatchPoolUser renames a member, moves it to another team or reassigns its
+// mainUserId. With updateSharing=true a reassigned member's range access is
+// moved to the new main user.
+func PatchPoolUser(c *gin.Context) {
+	poolId, ok := utils.GetRequiredQueryParam(c, "poolId")
+	if !ok {
+		return
+	}
+
+	userId, ok := utils.GetRequiredQueryParam(c, "userId")
+	if !ok {
+		return
+	}
+
+	if !utils.ValidatePoolId(c, poolId) {
+		return
+	}
+
+	input, ok := utils.ValidateJSONSchema(c, "file://schemas/pool_user_schema.json")
+	if !ok {
+		return
+	}
+
+	inputBytes, _ := json.Marshal(input)
+	var update utils.PoolMemberUpdate
+	if err := json.Unmarshal(inputBytes, &update); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+
+	var before utils.PoolUser
+	_, ok = utils.UpdatePoolWithResponse(c, poolId, utils.PoolActionUserUpdate, func(pool *utils.Pool) error {
+		var err error
+		before, err = utils.UpdatePoolMember(pool, userId, update)
+		return err
+	})
+	if !ok {
+		return
+	}
+
+	response := gin.H{"message": "Updated successfully"}
+	if c.Query("updateSharing") == "true" && update.MainUserId != nil && *update.MainUserId != before.MainUserId {
+		client := utils.LudusClientFromRequest(c)
+		response["results"] = stepResults(utils.MoveMemberSharing(client, userId, before.MainUserId, *update.MainUserId))
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// stepResults converts Ludus responses grouped by step to results
+func stepResults(responses map[string][]utils.LudusResponse) gin.H {
+	results := gin.H{}
+	for step, stepResponses := range responses {
+		results[step] = utils.ConvertResponsesToResults(stepResponses)
+	}
+	return results
+}
+
+// GetPoolHistory returns the recorded changes of a pool, also after it was deleted
+func GetPoolHistory(c *gin.Context) {
+	poolId, ok := utils.GetRequiredQueryParam(c, "poolId")
 	if !ok {
 		return
 	}
 
-	if err := os.RemoveAll(poolPath); err != nil {
+	if !utils.ValidatePoolIdFormat(c, poolId) {
+		return
+	}
+
+	history, err := utils.PoolHistory(poolId)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
 		return
 	}
 
-	c.Status(http.StatusNoContent)
+	if len(history) == 0 {
+		// Pools created before the history was recorded have none yet
+		if !utils.ValidatePoolId(c, poolId) {
+			return
+		}
+	}
+
+	entries := []gin.H{}
+	for _, entry := range history {
+		entries = append(entries, gin.H{
+			"revision":  entry.Revision,
+			"action":    entry.Action,
+			"userId":    entry.UserId,
+			"timestamp": entry.Timestamp.Format(config.TimestampFormat),
+			"changes":   entry.Changes,
+			"pool":      entry.Pool,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"poolId": poolId, "history": entries})
+}
+
+// PostPoolRestore sets topology, note and members of a pool back to a recorded revision.
+// The restore is a new revision; If-Match is honored like for the PATCH endpoints.
+func PostPoolRestore(c *gin.Context) {
+	poolId, ok := utils.GetRequiredQueryParam(c, "poolId")
+	if !ok {
+		return
+	}
+
+	revision, err := strconv.Atoi(c.Query("revision"))
+	if err != nil || revision < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+
+	if !utils.ValidatePoolId(c, poolId) {
+		return
+	}
+
+	restored, err := utils.PoolAtRevision(poolId, revision)
+	if err != nil {
+		utils.WriteStoreError(c, err)
+		return
+	}
+
+	// The topology of that revision may have been deleted since
+	if _, ok := utils.ReadTopologyWithResponse(c, restored.TopologyId); !ok {
+		return
+	}
+
+	pool, ok := utils.UpdatePoolWithResponse(c, poolId, utils.PoolActionRestore, func(pool *utils.Pool) error {
+		if pool.Type != restored.Type {
+			return fmt.Errorf("%w: pool type changed since revision %d", utils.ErrInvalidPool, revision)
+		}
+		pool.TopologyId = restored.TopologyId
+		pool.Note = restored.Note
+		pool.UsersAndTeams = restored.UsersAndTeams
+		return nil
+	})
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Restored successfully", "revision": pool.Revision})
+}
+
+// PutPoolAccess replaces the co-instructors or observers of a pool. Only its
+// owner and admins may change them; If-Match is honored like for the PATCH endpoints.
+func PutPoolAccess(c *gin.Context) {
+	poolId, ok := utils.GetRequiredQueryParam(c, "poolId")
+	if !ok {
+		return
+	}
+
+	if !utils.ValidatePoolId(c, poolId) {
+		return
+	}
+
+	input, ok := utils.ValidateJSONSchema(c, "file://schemas/pool_access_schema.json")
+	if !ok {
+		return
+	}
+
+	coInstructors := stringList(input["coInstructors"])
+	observers := stringList(input["observers"])
+
+	pool, ok := utils.UpdatePoolWithResponse(c, poolId, utils.PoolActionAccess, func(pool *utils.Pool) error {
+		return utils.SetPoolAccess(pool, coInstructors, observers)
+	})
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, utils.PoolAccessResponse(pool))
+}
+
+// stringList converts a validated JSON array of strings, nil stays nil
+func stringList(value interface{}) []string {
+	items, exists := value.([]interface{})
+	if !exists {
+		return nil
+	}
+	list := make([]string, 0, len(items))
+	for _, item := range items {
+		list = append(list, item.(string))
+	}
+	return list
 }
 
 func CheckUserIds(c *gin.Context) {
@@ -361,7 +698,7 @@ func CheckUserIds(c *gin.Context) {
 	}
 
 	// Get all existing userIds from all pools
-	existingUserIds, err := utils.GetAllUserIdsFromPools(config.PoolFolder)
+	existingUserIds, err := utils.GetAllUserIdsFromPools()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
 		return
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"dulus/server/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PostPoolTemplate stores the type, topology, default note and teams of a course as a template
+func PostPoolTemplate(c *gin.Context) {
+	input, ok := utils.ValidateJSONSchema(c, "file://schemas/pool_template_schema.json")
+	if !ok {
+		return
+	}
+
+	userID, ok := utils.ExtractUserIDFromAPIKey(c, utils.RequestAPIKey(c))
+	if !ok {
+		return
+	}
+
+	topologyId := input["topologyId"].(string)
+	if _, ok := utils.ReadTopologyWithResponse(c, topologyId); !ok {
+		return
+	}
+
+	template := utils.PoolTemplate{
+		Name:       input["name"].(string),
+		Type:       input["type"].(string),
+		TopologyId: topologyId,
+		CreatedBy:  userID,
+	}
+	template.Note, _ = input["note"].(string)
+	if teams, exists := input["teams"].([]interface{}); exists {
+		for _, team := range teams {
+			template.Teams = append(template.Teams, team.(string))
+		}
+	}
+
+	templateId, ok := utils.CreateTemplateWithResponse(c, template)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Created successfully", "id": templateId})
+}
+
+// GetPoolTemplate returns one template by templateId, or all templates
+func GetPoolTemplate(c *gin.Context) {
+	templateId := utils.GetOptionalQueryParam(c, "templateId")
+
+	if templateId != "" {
+		template, ok := utils.ReadTemplateWithResponse(c, templateId)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, utils.TemplateResponse(template))
+		return
+	}
+
+	templates, err := utils.GetAllTemplates()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, templates)
+}
+
+// DeletePoolTemplate deletes a template, pools created from it are kept
+func DeletePoolTemplate(c *gin.Context) {
+	templateId, ok := utils.GetRequiredQueryParam(c, "templateId")
+	if !ok {
+		return
+	}
+
+	if !utils.DeleteTemplateWithResponse(c, templateId) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// PostPoolFromTemplate creates a pool from a template and an uploaded CSV of names.
+// Members without a team in the CSV are spread over the template's teams. SHARED
+// templates need the mainUserId form field, the note form field replaces the
+// template's note.
+func PostPoolFromTemplate(c *gin.Context) {
+	templateId, ok := utils.GetRequiredQueryParam(c, "templateId")
+	if !ok {
+		return
+	}
+
+	template, ok := utils.ReadTemplateWithResponse(c, templateId)
+	if !ok {
+		return
+	}
+
+	_, content, ok := utils.ReadUploadedFile(c, ".csv")
+	if !ok {
+		return
+	}
+
+	note, hasNote := c.GetPostForm("note")
+	if !hasNote {
+		note = template.Note
+	}
+	mainUserId := c.PostForm("mainUserId")
+	if len(note) > 30 || (template.Type == "SHARED") != (mainUserId != "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+
+	userID, ok := utils.ExtractUserIDFromAPIKey(c, utils.RequestAPIKey(c))
+	if !ok {
+		return
+	}
+
+	if _, ok := utils.ReadTopologyWithResponse(c, template.TopologyId); !ok {
+		return
+	}
+
+	users, err := utils.ParseNamesCSV(content)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return
+	}
+	for i := range users {
+		users[i].MainUserId = mainUserId
+	}
+	utils.AssignTeams(users, template.Teams)
+
+	pool := utils.Pool{
+		CreatedBy:  userID,
+		Note:       note,
+		TopologyId: template.TopologyId,
+		Type:       template.Type,
+	}
+	pool.UsersAndTeams, ok = utils.PoolMembersWithResponse(c, users, pool.Type)
+	if !ok {
+		return
+	}
+
+	poolId, ok := utils.CreatePoolWithResponse(c, pool)
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Created successfully", "id": poolId, "usersAndTeams": utils.PoolMembersResponse(pool.UsersAndTeams)})
+}
@@ -13,19 +13,16 @@ func GetProxmoxStatistics(c *gin.Context) {
 	// Create Proxmox client
 	client := utils.NewProxmoxClient(config.ProxmoxURL)
 
-	apiKey := c.Request.Header.Get("X-API-Key")
+	ludus := utils.LudusClientFromRequest(c)
 
-	response, err := utils.MakeLudusRequest("GET", config.LudusUrl+"/user/credentials", nil, apiKey)
+	credentials, err := ludus.GetCredentials()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
 		return
 	}
 
-	// Extract credentials from the response (already parsed as map[string]interface{})
-	credResp := response.(map[string]interface{})
-	result := credResp["result"].(map[string]interface{})
-	proxmoxUsername := result["proxmoxUsername"].(string) + "@pam"
-	proxmoxPassword := result["proxmoxPassword"].(string)
+	proxmoxUsername := credentials.ProxmoxUsername + "@pam"
+	proxmoxPassword := credentials.ProxmoxPassword
 
 
 	// AuthenticateProxmox
@@ -47,7 +44,7 @@ func GetProxmoxStatistics(c *gin.Context) {
 	}
 
 	// Parse statistics
-	stats := utils.ParseStatistics(resources, apiKey)
+	stats := utils.ParseStatistics(resources, ludus)
 
 	// Return statistics
 	c.JSON(http.StatusOK, stats)
@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"dulus/server/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetOwnRole returns the role and permissions of the calling user
+func GetOwnRole(c *gin.Context) {
+	role := c.GetString("role")
+	c.JSON(http.StatusOK, gin.H{
+		"userId":      c.GetString("userID"),
+		"role":        role,
+		"permissions": utils.RolePermissions(role),
+	})
+}
+
+// GetRoles returns all assigned roles, users without one have the default role
+func GetRoles(c *gin.Context) {
+	assignments, err := utils.GetAllRoles()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, assignments)
+}
+
+// PutRole assigns a role to a user. Ludus admins stay admin whatever they are assigned.
+func PutRole(c *gin.Context) {
+	input, ok := utils.ValidateJSONSchema(c, "file://schemas/role_schema.json")
+	if !ok {
+		return
+	}
+
+	assignment, ok := utils.AssignRoleWithResponse(c, input["userId"].(string), input["role"].(string))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, assignment)
+}
+
+// DeleteRole removes the assigned role of a user, who gets the default role again
+func DeleteRole(c *gin.Context) {
+	userId, ok := utils.GetRequiredQueryParam(c, "userId")
+	if !ok {
+		return
+	}
+
+	if !utils.RemoveRoleWithResponse(c, userId) {
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
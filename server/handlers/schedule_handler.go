@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"dulus/server/config"
+	"dulus/server/utils"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetSchedules returns one schedule by scheduleId or lists the schedules of the
+// pools the caller may view, optionally filtered by poolId
+func GetSchedules(c *gin.Context) {
+	scheduleId := utils.GetOptionalQueryParam(c, "scheduleId")
+	if scheduleId != "" {
+		schedule, exists := utils.GetSchedule(scheduleId)
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+			return
+		}
+		if !utils.AuthorizePoolWithResponse(c, schedule.PoolId, utils.PoolAccessView) {
+			return
+		}
+		c.JSON(http.StatusOK, schedule)
+		return
+	}
+
+	poolId := utils.GetOptionalQueryParam(c, "poolId")
+	canView := utils.PoolAccessFilter(c, utils.PoolAccessView)
+	var schedules []utils.Schedule
+	for _, schedule := range utils.ListSchedules(poolId) {
+		if canView(schedule.PoolId) {
+			schedules = append(schedules, schedule)
+		}
+	}
+	c.JSON(http.StatusOK, schedules)
+}
+
+// PostSchedule creates a one-off (runAt) or recurring (cron) pool action
+func PostSchedule(c *gin.Context) {
+	input, ok := utils.ValidateJSONSchema(c, "file://schemas/schedule_schema.json")
+	if !ok {
+		return
+	}
+
+	// Scheduled actions run without a caller, so they need the service key
+	if config.LudusServiceApiKey == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Scheduler is not configured, LUDUS_SERVICE_API_KEY is not set"})
+		return
+	}
+
+	poolId := input["poolId"].(string)
+	if !utils.ValidatePoolId(c, poolId) || !utils.AuthorizePoolWithResponse(c, poolId, utils.PoolAccessManage) {
+		return
+	}
+
+	schedule := utils.Schedule{
+		PoolId:    poolId,
+		Action:    input["action"].(string),
+		Enabled:   true,
+		CreatedBy: c.GetString("userID"),
+	}
+	if !applyScheduleInput(c, &schedule, input) {
+		return
+	}
+
+	created, err := utils.CreateSchedule(schedule)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+// PatchSchedule changes the timing, concurrency, note or enabled flag of a schedule
+func PatchSchedule(c *gin.Context) {
+	scheduleId, ok := utils.GetRequiredQueryParam(c, "scheduleId")
+	if !ok {
+		return
+	}
+
+	input, ok := utils.ValidateJSONSchema(c, "file://schemas/schedule_update_schema.json")
+	if !ok {
+		return
+	}
+
+	schedule, exists := utils.GetSchedule(scheduleId)
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		return
+	}
+
+	if !utils.AuthorizePoolWithResponse(c, schedule.PoolId, utils.PoolAccessManage) {
+		return
+	}
+
+	if !applyScheduleInput(c, &schedule, input) {
+		return
+	}
+
+	updated, err := utils.UpdateSchedule(scheduleId, func(s *utils.Schedule) {
+		s.RunAt = schedule.RunAt
+		s.Cron = schedule.Cron
+		s.ConcurrentRequests = schedule.ConcurrentRequests
+		s.Enabled = schedule.Enabled
+		s.Note = schedule.Note
+	})
+	if os.IsNotExist(err) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+// DeleteSchedule removes a schedule, its past runs stay in the history
+func DeleteSchedule(c *gin.Context) {
+	scheduleId, ok := utils.GetRequiredQueryParam(c, "scheduleId")
+	if !ok {
+		return
+	}
+
+	if schedule, exists := utils.GetSchedule(scheduleId); exists && !utils.AuthorizePoolWithResponse(c, schedule.PoolId, utils.PoolAccessManage) {
+		return
+	}
+
+	if err := utils.DeleteSchedule(scheduleId); err != nil {
+		if os.IsNotExist(err) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		}
+		return
+	}
+
+	c.Status(http.StatusNoContent)
+}
+
+// GetScheduleHistory lists executed, failed and skipped schedule runs of the pools
+// the caller may view, newest first
+func GetScheduleHistory(c *gin.Context) {
+	poolId := utils.GetOptionalQueryParam(c, "poolId")
+	scheduleId := utils.GetOptionalQueryParam(c, "scheduleId")
+
+	canView := utils.PoolAccessFilter(c, utils.PoolAccessView)
+	var runs []utils.ScheduleRun
+	for _, run := range utils.GetScheduleHistory(poolId, scheduleId) {
+		if canView(run.PoolId) {
+			runs = append(runs, run)
+		}
+	}
+	c.JSON(http.StatusOK, runs)
+}
+
+// applyScheduleInput copies the optional schedule fields from a validated request body.
+// Setting runAt turns the schedule into a one-off, setting cron makes it recurring.
+func applyScheduleInput(c *gin.Context, schedule *utils.Schedule, input map[string]interface{}) bool {
+	if runAtStr, ok := input["runAt"].(string); ok {
+		runAt, err := time.Parse(time.RFC3339, runAtStr)
+		if err != nil || !runAt.After(time.Now()) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "runAt must be a future RFC 3339 timestamp"})
+			return false
+		}
+		schedule.RunAt = &runAt
+		schedule.Cron = ""
+	}
+	if cron, ok := input["cron"].(string); ok {
+		if _, err := utils.ParseCron(cron); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cron expression: " + err.Error()})
+			return false
+		}
+		schedule.Cron = cron
+		schedule.RunAt = nil
+	}
+	if concurrentRequests, ok := input["concurrentRequests"].(float64); ok {
+		schedule.ConcurrentRequests = int(concurrentRequests)
+	}
+	if enabled, ok := input["enabled"].(bool); ok {
+		schedule.Enabled = enabled
+	}
+	if note, ok := input["note"].(string); ok {
+		schedule.Note = note
+	}
+	return true
+}
@@ -6,7 +6,6 @@ import (
 	"encoding/json"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -16,9 +15,9 @@ func GetTopology(c *gin.Context) {
 	topologyId := utils.GetOptionalQueryParam(c, "topologyId")
 
 	if topologyId != "" {
-		utils.GetSingleItemWithFile(c, config.TopologyConfigFolder, topologyId, "topology")
+		utils.GetSingleTopology(c, topologyId)
 	} else {
-		utils.GetAllItemsWithFileNames(c, config.TopologyConfigFolder, "topology")
+		utils.GetAllTopologies(c)
 	}
 }
 
@@ -30,7 +29,12 @@ func PutTopology(c *gin.Context) {
 		return
 	}
 
-	id, ok := utils.SaveUploadedFile(c, config.TopologyConfigFolder, topologyId, ".yml")
+	fileName, content, ok := utils.ReadUploadedFile(c, ".yml")
+	if !ok {
+		return
+	}
+
+	id, ok := utils.SaveTopologyWithResponse(c, topologyId, fileName, content)
 	if !ok {
 		return
 	}
@@ -49,35 +53,8 @@ func DeleteTopology(c *gin.Context) {
 		return
 	}
 
-	topologyPath, ok := utils.ValidateFolderId(c, config.TopologyConfigFolder, topologyId)
-	if !ok {
-		return
-	}
-
-	// Check if topology is used in any pool
-	poolDirs, err := os.ReadDir(config.PoolFolder)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-		return
-	}
-
-	for _, poolDir := range poolDirs {
-		if poolDir.IsDir() {
-			poolPath := filepath.Join(config.PoolFolder, poolDir.Name())
-			pool, err := utils.ReadPoolInternal(poolPath)
-			if err != nil {
-				continue // Skip pools we can't read
-			}
-
-			if pool.TopologyId == topologyId {
-				c.JSON(http.StatusConflict, gin.H{"error": "Conflict"})
-				return
-			}
-		}
-	}
-
-	if err := os.RemoveAll(topologyPath); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+	// Refused with 409 while a pool uses the topology
+	if !utils.DeleteTopologyWithResponse(c, topologyId) {
 		return
 	}
 
@@ -111,13 +88,11 @@ func PostCtfdTopology(c *gin.Context) {
 	}
 
 	// Validate topology exists
-	_, ok = utils.ValidateFolderId(c, config.CtfdScenarioFolder, inputCtfdOptions.ScenarioID)
-	if !ok {
+	if !utils.ValidateScenarioId(c, inputCtfdOptions.ScenarioID) {
 		return
 	}
 
-	_, ok = utils.ValidateFolderId(c, config.PoolFolder, inputCtfdOptions.PoolID)
-	if !ok {
+	if !utils.ValidatePoolId(c, inputCtfdOptions.PoolID) {
 		return
 	}
 
@@ -148,27 +123,10 @@ func PostCtfdTopology(c *gin.Context) {
 		content = strings.ReplaceAll(content, placeholder, value)
 	}
 
-	// Generate unique topology ID
-	topologyId, err := utils.GenerateUniqueID(config.TopologyConfigFolder)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-		return
-	}
-
-	// Create topology directory
-	topologyPath := filepath.Join(config.TopologyConfigFolder, topologyId)
-	if err := os.MkdirAll(topologyPath, os.ModePerm); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-		return
-	}
-
-	// Save the generated topology file
+	// Save the generated topology file under a new id
 	filename := "ctfd_" + inputCtfdOptions.TopologyName + ".yml"
-	filePath := filepath.Join(topologyPath, filename)
-	if err := os.WriteFile(filePath, []byte(content), os.ModePerm); err != nil {
-		// Clean up directory on failure
-		os.RemoveAll(topologyPath)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+	topologyId, ok := utils.SaveTopologyWithResponse(c, "", filename, []byte(content))
+	if !ok {
 		return
 	}
 
@@ -1,26 +1,14 @@
 package main
 
 import (
-	"database/sql"
 	"dulus/server/config"
 	"dulus/server/utils"
 	"fmt"
 	"log"
 
 	"github.com/gin-gonic/gin"
-	_ "modernc.org/sqlite"
 )
 
-var db *sql.DB
-
-func initDB() {
-	var err error
-	db, err = sql.Open("sqlite", config.DatabaseLocation)
-	if err != nil {
-		log.Fatal(err)
-	}
-}
-
 func initSSL() (string, string) {
 	certPath := config.ProxmoxCertPath + "/pve-ssl.pem"
 	keyPath := config.ProxmoxCertPath + "/pve-ssl.key"
@@ -32,8 +20,13 @@ func initSSL() (string, string) {
 }
 
 func main() {
-	initDB()
-	defer db.Close()
+	config.Load()
+
+	// API keys are checked against the Ludus 1.x database or Ludus 2.x Pocketbase
+	if err := utils.InitAuthenticator(); err != nil {
+		log.Fatal(err)
+	}
+	defer utils.CloseAuthenticator()
 
 	r := gin.Default()
 	r.SetTrustedProxies(nil)
@@ -44,6 +37,34 @@ func main() {
 	utils.EnsureDirectoryExists(config.CtfdScenarioFolder)
 	utils.EnsureDirectoryExists(config.TopologyConfigFolder)
 	utils.EnsureDirectoryExists(config.PoolFolder)
+	utils.EnsureDirectoryExists(config.JobFolder)
+	utils.EnsureDirectoryExists(config.ScheduleFolder)
+	utils.EnsureDirectoryExists(config.PoolTemplateFolder)
+
+	// Pools, topologies and scenarios are kept in our own database, pool folders are imported once
+	if err := utils.InitStore(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Shared connections to Ludus and Proxmox, fails on an unreadable CA bundle
+	if err := utils.InitHTTPClients(); err != nil {
+		log.Fatal(err)
+	}
+
+	// Pick the Ludus 1.x or 2.x API before any background work talks to Ludus
+	utils.InitLudusApiVersion()
+
+	// Restore deployment jobs from previous runs and continue interrupted ones
+	if err := utils.LoadJobs(); err != nil {
+		log.Fatal(err)
+	}
+	utils.ResumeInterruptedJobs()
+
+	// Restore scheduled pool actions and start running them
+	if err := utils.LoadSchedules(); err != nil {
+		log.Fatal(err)
+	}
+	utils.StartScheduler()
 
 	// Initialize SSL certificates
 	certPath, keyPath := initSSL()
@@ -1,81 +1,44 @@
 package main
 
 import (
-	"database/sql"
 	"dulus/server/handlers"
 	"dulus/server/utils"
 	"net/http"
-	"time"
+	"strings"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	_ "modernc.org/sqlite"
 )
 
+// validateAPIKey authenticates a request by the session token in the
+// Authorization header or else the X-API-Key header, checked with the configured
+// authenticator (see utils.InitAuthenticator). It sets userID, isAdmin and the
+// Ludus API key for calls to Ludus in the context.
 func validateAPIKey(c *gin.Context) {
-	APIKey := c.Request.Header.Get("X-API-Key")
-	var isAdmin bool
-	var hashedAPIKey string
-
-	if len(APIKey) == 0 {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "No API Key provided"})
-		c.Abort()
-		return
-	}
-
-	userID, ok := utils.ExtractUserIDFromAPIKey(c, APIKey)
-	if !ok {
-		return
-	}
-
-	// Retry logic for SQLite busy errors
-	var err error
-	maxRetries := 5
-	baseDelay := 10 * time.Millisecond
-
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		err = db.QueryRow("SELECT is_admin, hashed_api_key FROM user_objects WHERE user_id = ?", userID).Scan(&isAdmin, &hashedAPIKey)
+	if token, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); found {
+		session, err := utils.SessionFromAccessToken(token)
 		if err != nil {
-			// Check if it's a busy error
-			if err.Error() == "database is locked" || err.Error() == "database is locked (5) (SQLITE_BUSY)" {
-				if attempt < maxRetries-1 {
-					// Exponential backoff: 10ms, 20ms, 40ms, 80ms
-					delay := time.Duration(1<<attempt) * baseDelay
-					time.Sleep(delay)
-					continue
-				}
-			}
-			// If it's not a busy error or we've exhausted retries, handle the error
-			break
-		} else {
-			// Success, break out of retry loop
-			break
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired session token"})
+			c.Abort()
+			return
 		}
-	}
-
-	if err != nil {
-		if err == sql.ErrNoRows {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Bad Request"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		}
-		c.Abort()
+		c.Set("isAdmin", session.IsAdmin)
+		c.Set("userID", session.UserId)
+		c.Set("apiKey", session.APIKey)
+		c.Set("sessionId", session.Id)
 		return
 	}
 
-	if utils.CheckPasswordHash(APIKey, hashedAPIKey) {
-		if isAdmin {
-			c.Set("isAdmin", true)
-		} else {
-			c.Set("isAdmin", false)
-		}
-		c.Set("userID", userID)
-		return
-	} else {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
+	APIKey := c.Request.Header.Get("X-API-Key")
+	user, ok := utils.AuthenticateAPIKeyWithResponse(c, APIKey)
+	if !ok {
 		c.Abort()
 		return
 	}
+
+	c.Set("isAdmin", user.IsAdmin)
+	c.Set("userID", user.UserId)
+	c.Set("apiKey", APIKey)
 }
 
 func RegisterRoutes(r *gin.Engine) {
@@ -83,77 +46,123 @@ func RegisterRoutes(r *gin.Engine) {
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS, PATCH"},
-		AllowHeaders:     []string{"*"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Authorization", "Content-Type", "If-Match", "X-API-Key"},
+		ExposeHeaders:    []string{"Content-Length", "ETag"},
 		AllowCredentials: true,
 	}))
 
+	// Every route needs a valid API key. The role of the key's user decides
+	// which of these groups it may use, see utils.RolePermissions. Requests with
+	// a poolId also need access to that pool, see utils.CanAccessPool.
+	authenticated := r.Group("", validateAPIKey, utils.RequirePermission(""))
+	view := r.Group("", validateAPIKey, utils.RequirePermission(utils.PermissionView), utils.RequirePoolAccess(utils.PoolAccessView))
+	manage := r.Group("", validateAPIKey, utils.RequirePermission(utils.PermissionManage), utils.RequirePoolAccess(utils.PoolAccessManage))
+	owner := r.Group("", validateAPIKey, utils.RequirePermission(utils.PermissionManage), utils.RequirePoolAccess(utils.PoolAccessOwner))
+	secrets := r.Group("", validateAPIKey, utils.RequirePermission(utils.PermissionSecrets), utils.RequirePoolAccess(utils.PoolAccessManage))
+	admin := r.Group("", validateAPIKey, utils.RequirePermission(utils.PermissionAdmin))
+
+	// Sessions; login and refresh authenticate themselves
+	r.POST("/auth/login", handlers.Login)
+	r.POST("/auth/refresh", handlers.Refresh)
+	authenticated.POST("/auth/revoke", handlers.Revoke)
+
 	// Index route
-	r.GET("/", validateAPIKey, func(c *gin.Context) {
+	authenticated.GET("/", func(c *gin.Context) {
 		c.JSON(http.StatusOK, gin.H{"result": "Ludus Extension API"})
 	})
 
 	// Scenario route
-	r.GET("/ctfd/scenario", validateAPIKey, handlers.GetScenario)
-	r.PUT("/ctfd/scenario", validateAPIKey, handlers.PutScenario)
-	r.DELETE("/ctfd/scenario", validateAPIKey, handlers.DeleteScenario)
+	secrets.GET("/ctfd/scenario", handlers.GetScenario)
+	manage.PUT("/ctfd/scenario", handlers.PutScenario)
+	manage.DELETE("/ctfd/scenario", handlers.DeleteScenario)
 
 	// Data route
-	r.GET("/ctfd/data", validateAPIKey, handlers.GetCtfdData)
-	r.PUT("/ctfd/data", validateAPIKey, handlers.PutCtfdData)
-	r.GET("/ctfd/data/logins", validateAPIKey, handlers.GetCtfdLogins)
+	secrets.GET("/ctfd/data", handlers.GetCtfdData)
+	manage.PUT("/ctfd/data", handlers.PutCtfdData)
+	secrets.GET("/ctfd/data/logins", handlers.GetCtfdLogins)
 
 	// Topology route
-	r.GET("/topology", validateAPIKey, handlers.GetTopology)
-	r.PUT("/topology", validateAPIKey, handlers.PutTopology)
-	r.DELETE("/topology", validateAPIKey, handlers.DeleteTopology)
-	r.POST("/topology/ctfd", validateAPIKey, handlers.PostCtfdTopology)
+	view.GET("/topology", handlers.GetTopology)
+	manage.PUT("/topology", handlers.PutTopology)
+	manage.DELETE("/topology", handlers.DeleteTopology)
+	manage.POST("/topology/ctfd", handlers.PostCtfdTopology)
 
 	// Pool route
-	r.POST("/pool", validateAPIKey, handlers.PostPool)
-	r.POST("/pool/dev", validateAPIKey, handlers.PostPoolDev)
-	r.PATCH("/pool/topology", validateAPIKey, handlers.PatchPoolTopology)
-	r.PATCH("/pool/note", validateAPIKey, handlers.PatchPoolNote)
-	r.PATCH("/pool/users", validateAPIKey, handlers.PatchPoolUsers)
-	r.POST("/pool/users", validateAPIKey, handlers.CheckUserIds)
-	r.GET("/pool", validateAPIKey, handlers.GetPool)
-	r.DELETE("/pool", validateAPIKey, handlers.DeletePool)
+	manage.POST("/pool", handlers.PostPool)
+	manage.POST("/pool/dev", handlers.PostPoolDev)
+	manage.PATCH("/pool/topology", handlers.PatchPoolTopology)
+	manage.PATCH("/pool/note", handlers.PatchPoolNote)
+	manage.PATCH("/pool/users", handlers.PatchPoolUsers)
+	view.POST("/pool/users", handlers.CheckUserIds)
+	manage.POST("/pool/users/remove", handlers.RemovePoolUsers)
+	manage.POST("/pool/users/import", handlers.ImportPoolUsers)
+	manage.PATCH("/pool/user", handlers.PatchPoolUser)
+	manage.POST("/pool/clone", handlers.PostPoolClone)
+	manage.POST("/pool/template", handlers.PostPoolTemplate)
+	view.GET("/pool/template", handlers.GetPoolTemplate)
+	manage.DELETE("/pool/template", handlers.DeletePoolTemplate)
+	manage.POST("/pool/template/pool", handlers.PostPoolFromTemplate)
+	view.GET("/pool", handlers.GetPool)
+	owner.DELETE("/pool", handlers.DeletePool)
+	owner.PUT("/pool/access", handlers.PutPoolAccess)
+	view.GET("/pool/history", handlers.GetPoolHistory)
+	manage.POST("/pool/restore", handlers.PostPoolRestore)
+	admin.GET("/pool/migration", handlers.GetPoolMigrationReport)
 
 	// User management endpoints
-	r.POST("/users/import", validateAPIKey, handlers.ImportUsers)
-	r.POST("/users/delete", validateAPIKey, handlers.DeleteUsers)
-	r.GET("/users/check", validateAPIKey, handlers.CheckUsers)
-	r.GET("/users/main", validateAPIKey, handlers.GetAllMainUsers)
+	manage.POST("/users/import", handlers.ImportUsers)
+	admin.POST("/users/delete", handlers.DeleteUsers)
+	view.GET("/users/check", handlers.CheckUsers)
+	view.GET("/users/main", handlers.GetAllMainUsers)
 
 	// Range config
-	r.POST("/range/config", validateAPIKey, handlers.SetRangeConfig)
-	r.GET("/range/config", validateAPIKey, handlers.GetRangeConfig)
+	manage.POST("/range/config", handlers.SetRangeConfig)
+	view.GET("/range/config", handlers.GetRangeConfig)
 
 	// Range deployment
-	r.POST("/range/deploy", validateAPIKey, handlers.DeployRange)
-	r.GET("/range/status", validateAPIKey, handlers.CheckRangeStatus)
-	r.POST("/range/redeploy", validateAPIKey, handlers.RedeployRange)
-	r.POST("/range/abort", validateAPIKey, handlers.AbortRange)
-	r.POST("/range/remove", validateAPIKey, handlers.RemoveRange)
+	manage.POST("/range/deploy", handlers.DeployRange)
+	view.GET("/range/status", handlers.CheckRangeStatus)
+	manage.POST("/range/redeploy", handlers.RedeployRange)
+	manage.POST("/range/abort", handlers.AbortRange)
+	manage.POST("/range/remove", handlers.RemoveRange)
+
+	// Deployment jobs
+	view.GET("/jobs", handlers.GetJobs)
+	view.GET("/jobs/:jobId", handlers.GetJob)
+	manage.POST("/jobs/:jobId/resume", handlers.ResumeJob)
+
+	// Scheduled pool actions
+	view.GET("/schedule", handlers.GetSchedules)
+	manage.POST("/schedule", handlers.PostSchedule)
+	manage.PATCH("/schedule", handlers.PatchSchedule)
+	manage.DELETE("/schedule", handlers.DeleteSchedule)
+	view.GET("/schedule/history", handlers.GetScheduleHistory)
 
 	// Range sharing
-	r.GET("/range/access", validateAPIKey, handlers.GetRangeAccess)
-	r.POST("/range/share", validateAPIKey, handlers.ShareRange)
-	r.POST("/range/unshare", validateAPIKey, handlers.UnshareRange)
-	r.GET("/range/shared", validateAPIKey, handlers.GetSharedRanges)
-	r.POST("/range/share/user", validateAPIKey, handlers.ShareRangeToUserId)
-	r.POST("/range/unshare/user", validateAPIKey, handlers.UnshareRangeToUserId)
-	r.GET("/range/shared/user", validateAPIKey, handlers.CheckRangeSharedToUserId)
+	secrets.GET("/range/access", handlers.GetRangeAccess)
+	manage.POST("/range/share", handlers.ShareRange)
+	manage.POST("/range/unshare", handlers.UnshareRange)
+	view.GET("/range/shared", handlers.GetSharedRanges)
+	manage.POST("/range/share/user", handlers.ShareRangeToUserId)
+	manage.POST("/range/unshare/user", handlers.UnshareRangeToUserId)
+	view.GET("/range/shared/user", handlers.CheckRangeSharedToUserId)
 
 	// Range testing
-	r.PUT("/range/testing/start", validateAPIKey, handlers.PutTestingStart)
-	r.PUT("/range/testing/stop", validateAPIKey, handlers.PutTestingStop)
-	r.GET("/range/testing/status", validateAPIKey, handlers.GetTestingStatus)
+	manage.PUT("/range/testing/start", handlers.PutTestingStart)
+	manage.PUT("/range/testing/stop", handlers.PutTestingStop)
+	view.GET("/range/testing/status", handlers.GetTestingStatus)
 
-	// Proxmox statistics
-	r.GET("/stats/proxmox", validateAPIKey, handlers.GetProxmoxStatistics)
+	// Proxmox and API key cache statistics
+	view.GET("/stats/proxmox", handlers.GetProxmoxStatistics)
+	admin.GET("/stats/auth", handlers.GetAuthStatistics)
 
 	// Power Management
-	r.PUT("/range/poweron", validateAPIKey, handlers.PutPowerOn)
-	r.PUT("/range/poweroff", validateAPIKey, handlers.PutPowerOff)
+	manage.PUT("/range/poweron", handlers.PutPowerOn)
+	manage.PUT("/range/poweroff", handlers.PutPowerOff)
+
+	// Roles
+	authenticated.GET("/roles/me", handlers.GetOwnRole)
+	admin.GET("/roles", handlers.GetRoles)
+	admin.PUT("/roles", handlers.PutRole)
+	admin.DELETE("/roles", handlers.DeleteRole)
 }
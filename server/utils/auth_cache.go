@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// AuthCache remembers API keys that passed the bcrypt check, keyed by the SHA-256
+// of the key, so that polling clients do not pay for bcrypt on every request.
+// An entry is trusted for TTL after the check. Once RevalidateAfter has passed
+// the stored hash of its user is looked up again; if it changed, the entry is
+// dropped and the key checked from scratch. At most MaxEntries keys are kept,
+// the oldest are dropped first. A cache with MaxEntries <= 0 keeps nothing.
+type AuthCache struct {
+	TTL             time.Duration
+	RevalidateAfter time.Duration
+	MaxEntries      int
+
+	mutex   sync.Mutex
+	entries map[[sha256.Size]byte]authCacheEntry
+	stats   AuthCacheStats
+}
+
+// authCacheEntry is a verified key with the user it belongs to
+type authCacheEntry struct {
+	user      AuthUser
+	checkedAt time.Time
+	expiresAt time.Time
+}
+
+// AuthCacheStats counts how API keys were checked since startup
+type AuthCacheStats struct {
+	// Keys accepted from the cache without bcrypt
+	Hits int64 `json:"hits"`
+	// Keys that were not cached and went through bcrypt
+	Misses int64 `json:"misses"`
+	// Hits for which the stored hash was looked up again and had not changed
+	Revalidations int64 `json:"revalidations"`
+	// Entries dropped because the stored hash changed or the user is gone
+	Invalidations int64 `json:"invalidations"`
+	// Entries dropped because they expired or to make room
+	Evictions  int64 `json:"evictions"`
+	Entries    int   `json:"entries"`
+	MaxEntries int   `json:"maxEntries"`
+}
+
+// authCacheKey is the SHA-256 of an API key; keys are not kept in plain text
+func authCacheKey(apiKey string) [sha256.Size]byte {
+	return sha256.Sum256([]byte(apiKey))
+}
+
+// get returns the entry of a key that has not expired, and whether its stored
+// hash is due to be looked up again
+func (a *AuthCache) get(key [sha256.Size]byte) (authCacheEntry, bool, bool) {
+	if a.MaxEntries <= 0 {
+		return authCacheEntry{}, false, false
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	entry, exists := a.entries[key]
+	if !exists {
+		return authCacheEntry{}, false, false
+	}
+	now := time.Now()
+	if !now.Before(entry.expiresAt) {
+		delete(a.entries, key)
+		a.stats.Evictions++
+		return authCacheEntry{}, false, false
+	}
+	return entry, true, now.Sub(entry.checkedAt) >= a.RevalidateAfter
+}
+
+// hit counts a key accepted from the cache. A revalidated entry takes the
+// looked-up user, whose admin flag may have changed, but keeps its expiry.
+func (a *AuthCache) hit(key [sha256.Size]byte, revalidated *AuthUser) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.stats.Hits++
+	if revalidated == nil {
+		return
+	}
+	a.stats.Revalidations++
+	if entry, exists := a.entries[key]; exists {
+		entry.user = *revalidated
+		entry.checkedAt = time.Now()
+		a.entries[key] = entry
+	}
+}
+
+// miss counts a key that had to be checked with bcrypt
+func (a *AuthCache) miss() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.stats.Misses++
+}
+
+// add caches a key that passed the bcrypt check
+func (a *AuthCache) add(key [sha256.Size]byte, user AuthUser) {
+	if a.MaxEntries <= 0 {
+		return
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.entries == nil {
+		a.entries = make(map[[sha256.Size]byte]authCacheEntry)
+	}
+	if _, exists := a.entries[key]; !exists && len(a.entries) >= a.MaxEntries {
+		a.evict()
+	}
+
+	now := time.Now()
+	a.entries[key] = authCacheEntry{user: user, checkedAt: now, expiresAt: now.Add(a.TTL)}
+}
+
+// evict makes room for one entry: expired ones go first, otherwise the one that
+// expires soonest. The caller holds the mutex.
+func (a *AuthCache) evict() {
+	now := time.Now()
+	var oldestKey [sha256.Size]byte
+	var oldest time.Time
+	for key, entry := range a.entries {
+		if !now.Before(entry.expiresAt) {
+			delete(a.entries, key)
+			a.stats.Evictions++
+			continue
+		}
+		if oldest.IsZero() || entry.expiresAt.Before(oldest) {
+			oldestKey, oldest = key, entry.expiresAt
+		}
+	}
+	if len(a.entries) >= a.MaxEntries && !oldest.IsZero() {
+		delete(a.entries, oldestKey)
+		a.stats.Evictions++
+	}
+}
+
+// invalidate drops a key whose stored hash changed or whose user is gone
+func (a *AuthCache) invalidate(key [sha256.Size]byte) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if _, exists := a.entries[key]; exists {
+		delete(a.entries, key)
+		a.stats.Invalidations++
+	}
+}
+
+// Clear drops all entries, e.g. when the authenticator is replaced
+func (a *AuthCache) Clear() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.entries = nil
+}
+
+// Stats returns the counters and the current number of entries
+func (a *AuthCache) Stats() AuthCacheStats {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	stats := a.stats
+	stats.Entries = len(a.entries)
+	stats.MaxEntries = a.MaxEntries
+	return stats
+}
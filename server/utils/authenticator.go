@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"dulus/server/config"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Errors of AuthenticateAPIKey
+var (
+	ErrMalformedAPIKey = errors.New("malformed API key")
+	ErrUnknownUser     = errors.New("unknown user")
+	ErrAPIKeyMismatch  = errors.New("API key does not match")
+)
+
+// AuthUser is a Ludus user with the bcrypt hash of its API key
+type AuthUser struct {
+	UserId       string
+	IsAdmin      bool
+	HashedAPIKey string
+}
+
+// Authenticator looks up the Ludus users API keys are checked against. Ludus 1.x
+// keeps them in its SQLite database, Ludus 2.x in Pocketbase.
+type Authenticator interface {
+	// LookupUser returns the user with userId, or ErrUnknownUser
+	LookupUser(ctx context.Context, userId string) (AuthUser, error)
+	Close() error
+}
+
+var authenticator Authenticator
+
+// authCache holds the verified API keys, it keeps nothing until InitAuthenticator
+var authCache = &AuthCache{}
+
+// validIdentifierRegex matches the table, collection and field names that may be
+// put into queries and filters
+var validIdentifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// InitAuthenticator opens the authenticator selected by AUTH_PROVIDER: "sqlite"
+// (default) reads the Ludus 1.x database, "pocketbase" the users collection of
+// Ludus 2.x through the Pocketbase API or its data file
+func InitAuthenticator() error {
+	authCache = &AuthCache{
+		TTL:             time.Duration(config.AuthCacheTTLSeconds) * time.Second,
+		RevalidateAfter: time.Duration(config.AuthCacheRevalidateSeconds) * time.Second,
+		MaxEntries:      config.AuthCacheMaxEntries,
+	}
+
+	switch config.AuthProvider {
+	case "pocketbase":
+		fields := PocketbaseUserFields{
+			Collection: config.PocketbaseUsersCollection,
+			UserId:     config.PocketbaseUserIdField,
+			IsAdmin:    config.PocketbaseIsAdminField,
+			APIKey:     config.PocketbaseApiKeyField,
+		}
+		if config.PocketbaseDataFile != "" {
+			fileAuthenticator, err := OpenPocketbaseFileAuthenticator(config.PocketbaseDataFile, fields)
+			if err != nil {
+				return err
+			}
+			authenticator = fileAuthenticator
+			return nil
+		}
+		httpAuthenticator, err := NewPocketbaseHTTPAuthenticator(PocketbaseHTTPClient(), config.PocketbaseUrl,
+			config.PocketbaseAdminEmail, config.PocketbaseAdminPassword, fields)
+		if err != nil {
+			return err
+		}
+		authenticator = httpAuthenticator
+		return nil
+	default:
+		sqliteAuthenticator, err := OpenLudusSQLiteAuthenticator(config.DatabaseLocation)
+		if err != nil {
+			return err
+		}
+		authenticator = sqliteAuthenticator
+		return nil
+	}
+}
+
+// SetAuthenticator replaces the authenticator, e.g. with one on a fixture database in tests
+func SetAuthenticator(a Authenticator) {
+	authenticator = a
+	authCache.Clear()
+}
+
+// AuthCacheStatistics returns the hit and miss counters of the API key cache
+func AuthCacheStatistics() AuthCacheStats {
+	return authCache.Stats()
+}
+
+// CloseAuthenticator closes the authenticator on shutdown
+func CloseAuthenticator() error {
+	if authenticator == nil {
+		return nil
+	}
+	return authenticator.Close()
+}
+
+// AuthenticateAPIKey checks an API key of the form "<userId>.<secret>" against
+// the bcrypt hash stored for its user and returns the user. Keys verified before
+// are taken from authCache; the stored hash of a cached key is only compared
+// once the entry is due for revalidation.
+func AuthenticateAPIKey(ctx context.Context, apiKey string) (AuthUser, error) {
+	apiKeySplit := strings.Split(apiKey, ".")
+	if len(apiKeySplit) != 2 {
+		return AuthUser{}, ErrMalformedAPIKey
+	}
+
+	cacheKey := authCacheKey(apiKey)
+	entry, cached, revalidate := authCache.get(cacheKey)
+	if cached && !revalidate {
+		authCache.hit(cacheKey, nil)
+		return entry.user, nil
+	}
+
+	user, err := authenticator.LookupUser(ctx, apiKeySplit[0])
+	if errors.Is(err, ErrUnknownUser) {
+		authCache.invalidate(cacheKey)
+		return AuthUser{}, err
+	}
+	if err != nil {
+		// A busy or unreachable user database does not reject keys checked before
+		if cached {
+			authCache.hit(cacheKey, nil)
+			return entry.user, nil
+		}
+		return AuthUser{}, err
+	}
+
+	if cached {
+		if user.HashedAPIKey == entry.user.HashedAPIKey {
+			authCache.hit(cacheKey, &user)
+			return user, nil
+		}
+		authCache.invalidate(cacheKey)
+	}
+
+	authCache.miss()
+	if !CheckPasswordHash(apiKey, user.HashedAPIKey) {
+		return AuthUser{}, ErrAPIKeyMismatch
+	}
+	authCache.add(cacheKey, user)
+	return user, nil
+}
+
+// AuthenticateAPIKeyWithResponse checks an API key like AuthenticateAPIKey and
+// responds with 401 if it is missing or invalid, or 500 if it cannot be checked
+func AuthenticateAPIKeyWithResponse(c *gin.Context, apiKey string) (AuthUser, bool) {
+	if len(apiKey) == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "No API Key provided"})
+		return AuthUser{}, false
+	}
+
+	user, err := AuthenticateAPIKey(c.Request.Context(), apiKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrMalformedAPIKey):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Malformed API Key provided"})
+		case errors.Is(err, ErrUnknownUser):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Bad Request"})
+		case errors.Is(err, ErrAPIKeyMismatch):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication failed"})
+		default:
+			log.Printf("Failed to check API key: %v", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		}
+		return AuthUser{}, false
+	}
+	return user, true
+}
+
+// openReadOnlySQLite opens a SQLite database of another program read-only.
+// Writers are waited for instead of failing with SQLITE_BUSY.
+func openReadOnlySQLite(path string) (*sql.DB, error) {
+	dsn := "file:" + path + "?" + url.Values{
+		"mode":    {"ro"},
+		"_pragma": {"busy_timeout(5000)"},
+	}.Encode()
+	return sql.Open("sqlite", dsn)
+}
+
+// sqliteUserLookup reads a user with a query selecting whether it is an admin
+// and the hash of its API key
+type sqliteUserLookup struct {
+	db    *sql.DB
+	query string
+}
+
+func (l sqliteUserLookup) LookupUser(ctx context.Context, userId string) (AuthUser, error) {
+	user := AuthUser{UserId: userId}
+	err := l.db.QueryRowContext(ctx, l.query, userId).Scan(&user.IsAdmin, &user.HashedAPIKey)
+	if err == sql.ErrNoRows {
+		return AuthUser{}, ErrUnknownUser
+	}
+	if err != nil {
+		return AuthUser{}, err
+	}
+	return user, nil
+}
+
+func (l sqliteUserLookup) Close() error {
+	return l.db.Close()
+}
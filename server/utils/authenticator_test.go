@@ -0,0 +1,228 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// fixtureUser is a user put into the fixture databases, with the API key that
+// belongs to it
+type fixtureUser struct {
+	userId  string
+	isAdmin bool
+	apiKey  string
+}
+
+var fixtureUsers = []fixtureUser{
+	{userId: "ADMIN", isAdmin: true, apiKey: "ADMIN.adminsecret"},
+	{userId: "JD", isAdmin: false, apiKey: "JD.usersecret"},
+}
+
+// hashFixtureKey hashes an API key at the lowest cost to keep the tests fast
+func hashFixtureKey(t *testing.T, apiKey string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(apiKey), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("hash API key: %v", err)
+	}
+	return string(hash)
+}
+
+// createSQLiteFixture creates a database at a temporary path with a users table
+// of the given columns and returns its path
+func createSQLiteFixture(t *testing.T, table, userIdColumn, isAdminColumn, apiKeyColumn string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "fixture.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open fixture: %v", err)
+	}
+	defer db.Close()
+
+	create := `CREATE TABLE "` + table + `" ("` + userIdColumn + `" TEXT PRIMARY KEY, "` +
+		isAdminColumn + `" BOOLEAN NOT NULL, "` + apiKeyColumn + `" TEXT NOT NULL)`
+	if _, err := db.Exec(create); err != nil {
+		t.Fatalf("create fixture table: %v", err)
+	}
+	insert := `INSERT INTO "` + table + `" VALUES (?, ?, ?)`
+	for _, user := range fixtureUsers {
+		if _, err := db.Exec(insert, user.userId, user.isAdmin, hashFixtureKey(t, user.apiKey)); err != nil {
+			t.Fatalf("insert fixture user: %v", err)
+		}
+	}
+	return path
+}
+
+// testAuthenticator runs the API key checks every authenticator has to pass
+func testAuthenticator(t *testing.T, a Authenticator) {
+	SetAuthenticator(a)
+	t.Cleanup(func() {
+		a.Close()
+		SetAuthenticator(nil)
+	})
+
+	tests := []struct {
+		name    string
+		apiKey  string
+		userId  string
+		isAdmin bool
+		err     error
+	}{
+		{name: "valid admin key", apiKey: "ADMIN.adminsecret", userId: "ADMIN", isAdmin: true},
+		{name: "valid user key", apiKey: "JD.usersecret", userId: "JD", isAdmin: false},
+		{name: "wrong secret", apiKey: "JD.adminsecret", err: ErrAPIKeyMismatch},
+		{name: "key of another user", apiKey: "ADMIN.usersecret", err: ErrAPIKeyMismatch},
+		{name: "unknown user", apiKey: "NOBODY.usersecret", err: ErrUnknownUser},
+		{name: "invalid userId", apiKey: "J'D.usersecret", err: ErrUnknownUser},
+		{name: "no separator", apiKey: "JDusersecret", err: ErrMalformedAPIKey},
+		{name: "too many separators", apiKey: "JD.user.secret", err: ErrMalformedAPIKey},
+		{name: "empty key", apiKey: "", err: ErrMalformedAPIKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user, err := AuthenticateAPIKey(context.Background(), tt.apiKey)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("expected error %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if user.UserId != tt.userId || user.IsAdmin != tt.isAdmin {
+				t.Fatalf("expected user %s (admin %v), got %s (admin %v)", tt.userId, tt.isAdmin, user.UserId, user.IsAdmin)
+			}
+		})
+	}
+}
+
+func TestLudusSQLiteAuthenticator(t *testing.T) {
+	path := createSQLiteFixture(t, "user_objects", "user_id", "is_admin", "hashed_api_key")
+	a, err := OpenLudusSQLiteAuthenticator(path)
+	if err != nil {
+		t.Fatalf("open authenticator: %v", err)
+	}
+	testAuthenticator(t, a)
+}
+
+func TestPocketbaseFileAuthenticator(t *testing.T) {
+	fields := PocketbaseUserFields{Collection: "users", UserId: "userID", IsAdmin: "isAdmin", APIKey: "hashedAPIKey"}
+	path := createSQLiteFixture(t, fields.Collection, fields.UserId, fields.IsAdmin, fields.APIKey)
+	a, err := OpenPocketbaseFileAuthenticator(path, fields)
+	if err != nil {
+		t.Fatalf("open authenticator: %v", err)
+	}
+	testAuthenticator(t, a)
+}
+
+func TestPocketbaseFileAuthenticatorRejectsInvalidFields(t *testing.T) {
+	fields := PocketbaseUserFields{Collection: "users; DROP TABLE users", UserId: "userID", IsAdmin: "isAdmin", APIKey: "hashedAPIKey"}
+	if _, err := OpenPocketbaseFileAuthenticator(filepath.Join(t.TempDir(), "data.db"), fields); err == nil {
+		t.Fatal("expected an error for an invalid collection name")
+	}
+}
+
+// pocketbaseFixture serves the superuser login and the users records of the
+// fixture users like the Pocketbase API does
+type pocketbaseFixture struct {
+	hashes map[string]string
+	token  string
+	logins int
+}
+
+func newPocketbaseFixture(t *testing.T) *pocketbaseFixture {
+	fixture := &pocketbaseFixture{hashes: make(map[string]string), token: "token-1"}
+	for _, user := range fixtureUsers {
+		fixture.hashes[user.userId] = hashFixtureKey(t, user.apiKey)
+	}
+	return fixture
+}
+
+func (p *pocketbaseFixture) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/api/collections/_superusers/auth-with-password":
+		var credentials map[string]string
+		json.NewDecoder(r.Body).Decode(&credentials)
+		if credentials["identity"] != "admin@example.com" || credentials["password"] != "secret" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		p.logins++
+		json.NewEncoder(w).Encode(map[string]string{"token": p.token})
+	case r.Method == http.MethodGet && r.URL.Path == "/api/collections/users/records":
+		if r.Header.Get("Authorization") != p.token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		userId := strings.TrimSuffix(strings.TrimPrefix(r.URL.Query().Get("filter"), "userID='"), "'")
+		items := []map[string]interface{}{}
+		for _, user := range fixtureUsers {
+			if user.userId == userId {
+				items = append(items, map[string]interface{}{
+					"userID":       user.userId,
+					"isAdmin":      user.isAdmin,
+					"hashedAPIKey": p.hashes[user.userId],
+				})
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": items})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newPocketbaseHTTPFixture(t *testing.T, fixture *pocketbaseFixture) *PocketbaseHTTPAuthenticator {
+	t.Helper()
+	server := httptest.NewServer(fixture)
+	t.Cleanup(server.Close)
+
+	fields := PocketbaseUserFields{Collection: "users", UserId: "userID", IsAdmin: "isAdmin", APIKey: "hashedAPIKey"}
+	a, err := NewPocketbaseHTTPAuthenticator(server.Client(), server.URL, "admin@example.com", "secret", fields)
+	if err != nil {
+		t.Fatalf("create authenticator: %v", err)
+	}
+	return a
+}
+
+func TestPocketbaseHTTPAuthenticator(t *testing.T) {
+	testAuthenticator(t, newPocketbaseHTTPFixture(t, newPocketbaseFixture(t)))
+}
+
+func TestPocketbaseHTTPAuthenticatorRenewsToken(t *testing.T) {
+	fixture := newPocketbaseFixture(t)
+	a := newPocketbaseHTTPFixture(t, fixture)
+
+	if _, err := a.LookupUser(context.Background(), "JD"); err != nil {
+		t.Fatalf("first lookup: %v", err)
+	}
+	fixture.token = "token-2"
+	user, err := a.LookupUser(context.Background(), "JD")
+	if err != nil {
+		t.Fatalf("lookup after token change: %v", err)
+	}
+	if user.UserId != "JD" || fixture.logins != 2 {
+		t.Fatalf("expected JD after a second login, got %s after %d logins", user.UserId, fixture.logins)
+	}
+}
+
+func TestPocketbaseHTTPAuthenticatorLoginFailure(t *testing.T) {
+	fixture := newPocketbaseFixture(t)
+	a := newPocketbaseHTTPFixture(t, fixture)
+	a.password = "wrong"
+
+	_, err := a.LookupUser(context.Background(), "JD")
+	if err == nil || errors.Is(err, ErrUnknownUser) {
+		t.Fatalf("expected a login error, got %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// UnavailableError is returned without contacting an upstream while its circuit is open
+type UnavailableError struct {
+	Upstream   string
+	RetryAfter time.Duration
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s unavailable, retrying in %s", e.Upstream, e.RetryAfter.Round(time.Second))
+}
+
+// IsUpstreamUnavailable reports whether err comes from an open circuit
+func IsUpstreamUnavailable(err error) bool {
+	var unavailable *UnavailableError
+	return errors.As(err, &unavailable)
+}
+
+// CircuitBreaker stops requests to an upstream after FailureThreshold consecutive
+// failures. Once OpenDuration has passed a single probe request is let through;
+// its success closes the circuit again, its failure keeps it open.
+type CircuitBreaker struct {
+	Upstream         string
+	FailureThreshold int
+	OpenDuration     time.Duration
+
+	mutex         sync.Mutex
+	failures      int
+	openUntil     time.Time
+	probeInFlight bool
+}
+
+// Allow reports whether a request may be sent now, or the error to return instead
+func (b *CircuitBreaker) Allow() error {
+	if b.FailureThreshold <= 0 {
+		return nil
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if b.failures < b.FailureThreshold {
+		return nil
+	}
+
+	now := time.Now()
+	if now.Before(b.openUntil) || b.probeInFlight {
+		retryAfter := b.openUntil.Sub(now)
+		if retryAfter < 0 {
+			retryAfter = 0
+		}
+		return &UnavailableError{Upstream: b.Upstream, RetryAfter: retryAfter}
+	}
+
+	b.probeInFlight = true
+	return nil
+}
+
+// Record reports the outcome of a request that Allow let through
+func (b *CircuitBreaker) Record(success bool) {
+	if b.FailureThreshold <= 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probeInFlight = false
+	if success {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.FailureThreshold {
+		b.openUntil = time.Now().Add(b.OpenDuration)
+	}
+}
+
+// Abandon reports a request that Allow let through but that was cancelled by
+// its caller, which says nothing about the upstream. A probe may be sent again.
+func (b *CircuitBreaker) Abandon() {
+	if b.FailureThreshold <= 0 {
+		return
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.probeInFlight = false
+}
@@ -0,0 +1,168 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CronExpression is a parsed 5-field cron expression: minute hour day-of-month month day-of-week
+type CronExpression struct {
+	minutes     map[int]bool
+	hours       map[int]bool
+	daysOfMonth map[int]bool
+	months      map[int]bool
+	daysOfWeek  map[int]bool
+	// Vixie cron semantics: a day field starting with * (e.g. "*" or "*/2") is
+	// unrestricted. When both day fields are restricted a day matches if either
+	// does, otherwise it has to match both.
+	anyDayOfMonth bool
+	anyDayOfWeek  bool
+}
+
+// How far ahead Next searches before giving up on an expression that never matches (e.g. 31 February)
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// ParseCron parses expressions like "0 8 * * 1-5" or "*/15 9-17 * * MON,WED".
+// Supports *, lists, ranges, steps and three-letter month and weekday names.
+func ParseCron(expression string) (CronExpression, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return CronExpression{}, fmt.Errorf("cron expression must have 5 fields, got %d", len(fields))
+	}
+
+	var cron CronExpression
+	var err error
+
+	if cron.minutes, err = parseCronField(fields[0], 0, 59, nil); err != nil {
+		return CronExpression{}, fmt.Errorf("minute: %w", err)
+	}
+	if cron.hours, err = parseCronField(fields[1], 0, 23, nil); err != nil {
+		return CronExpression{}, fmt.Errorf("hour: %w", err)
+	}
+	if cron.daysOfMonth, err = parseCronField(fields[2], 1, 31, nil); err != nil {
+		return CronExpression{}, fmt.Errorf("day of month: %w", err)
+	}
+	if cron.months, err = parseCronField(fields[3], 1, 12, cronMonthNames); err != nil {
+		return CronExpression{}, fmt.Errorf("month: %w", err)
+	}
+	if cron.daysOfWeek, err = parseCronField(fields[4], 0, 7, cronWeekdayNames); err != nil {
+		return CronExpression{}, fmt.Errorf("day of week: %w", err)
+	}
+
+	// 7 is an alias for Sunday
+	if cron.daysOfWeek[7] {
+		cron.daysOfWeek[0] = true
+	}
+
+	cron.anyDayOfMonth = strings.HasPrefix(fields[2], "*")
+	cron.anyDayOfWeek = strings.HasPrefix(fields[4], "*")
+	return cron, nil
+}
+
+var cronMonthNames = map[string]int{
+	"JAN": 1, "FEB": 2, "MAR": 3, "APR": 4, "MAY": 5, "JUN": 6,
+	"JUL": 7, "AUG": 8, "SEP": 9, "OCT": 10, "NOV": 11, "DEC": 12,
+}
+
+var cronWeekdayNames = map[string]int{
+	"SUN": 0, "MON": 1, "TUE": 2, "WED": 3, "THU": 4, "FRI": 5, "SAT": 6,
+}
+
+// parseCronField expands one comma separated field into the set of values it matches
+func parseCronField(field string, min, max int, names map[string]int) (map[int]bool, error) {
+	values := make(map[int]bool)
+
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if rangePart, stepPart, found := strings.Cut(part, "/"); found {
+			var err error
+			step, err = strconv.Atoi(stepPart)
+			if err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step %q", stepPart)
+			}
+			part = rangePart
+		}
+
+		start, end := min, max
+		if part != "*" {
+			startPart, endPart, isRange := strings.Cut(part, "-")
+
+			var err error
+			if start, err = parseCronValue(startPart, min, max, names); err != nil {
+				return nil, err
+			}
+			end = start
+			if isRange {
+				if end, err = parseCronValue(endPart, min, max, names); err != nil {
+					return nil, err
+				}
+			} else if step > 1 {
+				// "5/15" means every 15 starting at 5
+				end = max
+			}
+			if end < start {
+				return nil, fmt.Errorf("invalid range %q", part)
+			}
+		}
+
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+
+	return values, nil
+}
+
+func parseCronValue(value string, min, max int, names map[string]int) (int, error) {
+	if named, ok := names[strings.ToUpper(value)]; ok {
+		return named, nil
+	}
+
+	number, err := strconv.Atoi(value)
+	if err != nil || number < min || number > max {
+		return 0, fmt.Errorf("value %q out of range %d-%d", value, min, max)
+	}
+	return number, nil
+}
+
+// matchesDay applies the day-of-month / day-of-week rules to t
+func (c CronExpression) matchesDay(t time.Time) bool {
+	dayOfMonth := c.daysOfMonth[t.Day()]
+	dayOfWeek := c.daysOfWeek[int(t.Weekday())]
+
+	if c.anyDayOfMonth || c.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// Next returns the first time strictly after t that matches the expression, in t's
+// location. The zero time is returned if nothing matches within five years.
+func (c CronExpression) Next(t time.Time) time.Time {
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronSearchLimit)
+
+	for next.Before(limit) {
+		if !c.months[int(next.Month())] {
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if !c.matchesDay(next) {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if !c.hours[next.Hour()] {
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+			continue
+		}
+		if !c.minutes[next.Minute()] {
+			next = next.Add(time.Minute)
+			continue
+		}
+		return next
+	}
+
+	return time.Time{}
+}
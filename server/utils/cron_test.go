@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNextDayFields(t *testing.T) {
+	// Thursday, 1 January 2026
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		expression string
+		next       time.Time
+	}{
+		{name: "both day fields unrestricted", expression: "0 8 * * *", next: time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)},
+		{name: "weekday only", expression: "0 8 * * MON", next: time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC)},
+		{name: "day of month only", expression: "0 8 15 * *", next: time.Date(2026, 1, 15, 8, 0, 0, 0, time.UTC)},
+		// Both restricted: the 15th or any Monday, whichever comes first
+		{name: "both day fields restricted", expression: "0 8 15 * MON", next: time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC)},
+		// A stepped day of month starts with * and has to match together with the weekday
+		{name: "stepped day of month and weekday", expression: "0 8 */2 * MON", next: time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC)},
+		{name: "day of month and stepped weekday", expression: "0 8 10 * */2", next: time.Date(2026, 1, 10, 8, 0, 0, 0, time.UTC)},
+		{name: "stepped day of month", expression: "0 8 */10 * *", next: time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)},
+		{name: "sunday as 7", expression: "0 8 * * 7", next: time.Date(2026, 1, 4, 8, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cron, err := ParseCron(tt.expression)
+			if err != nil {
+				t.Fatalf("parse %q: %v", tt.expression, err)
+			}
+			if next := cron.Next(start); !next.Equal(tt.next) {
+				t.Fatalf("expected %s, got %s", tt.next, next)
+			}
+		})
+	}
+}
+
+func TestParseCronInvalid(t *testing.T) {
+	for _, expression := range []string{"0 8 * *", "60 8 * * *", "0 8 0 * *", "0 8 * 13 *", "0 8 * * FOO", "*/0 * * * *", "0 8 20-10 * *"} {
+		if _, err := ParseCron(expression); err == nil {
+			t.Errorf("expected an error for %q", expression)
+		}
+	}
+}
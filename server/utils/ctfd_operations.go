@@ -2,13 +2,12 @@ package utils
 
 import (
 	"archive/zip"
+	"bytes"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 
 	"dulus/server/config"
@@ -56,85 +55,57 @@ type CtfdData struct {
 	CtfdData []CtfdUser `json:"ctfd_data"`
 }
 
-// ReadCTFdJSON reads and parses CTFd JSON data
-func ReadCTFdJSON(c *gin.Context, dataPath string) (CtfdData, bool) {
-	filePath := filepath.Join(dataPath, "ctfd_data.json")
-	file, err := os.Open(filePath)
+// ReadCTFdJSON reads the CTFd data of a pool and handles HTTP responses
+func ReadCTFdJSON(c *gin.Context, poolId string) (CtfdData, bool) {
+	data, err := store.GetCtfdData(poolId)
 	if err != nil {
-		if os.IsNotExist(err) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-		}
-		return CtfdData{}, false
-	}
-	defer file.Close()
-
-	var data CtfdData
-	if err := json.NewDecoder(file).Decode(&data); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		WriteStoreError(c, err)
 		return CtfdData{}, false
 	}
 
 	return data, true
 }
 
-// SaveCTFdData saves CTFd data to the specified path and handles HTTP responses
-func SaveCTFdData(c *gin.Context, dataPath string, ctfdUsers []CtfdUser) bool {
-	filePath := filepath.Join(dataPath, "ctfd_data.json")
-
-	// Check if file already exists, if so, do nothing
-	if _, err := os.Stat(filePath); err == nil {
-		return true // File exists, silently return success
+// SaveCTFdData saves the CTFd data of a pool, records it in the pool history and handles HTTP responses
+func SaveCTFdData(c *gin.Context, poolId string, ctfdUsers []CtfdUser) bool {
+	// Check if data already exists, if so, do nothing
+	if store.HasCtfdData(poolId) {
+		return true // Data exists, silently return success
 	}
 
-	file, err := os.Create(filePath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-		return false
-	}
-	defer file.Close()
-
-	// Create the data structure that matches the expected format
-	data := map[string]interface{}{"ctfd_data": ctfdUsers}
-
-	encoder := json.NewEncoder(file)
-	encoder.SetIndent("", "  ")
-	if err := encoder.Encode(data); err != nil {
+	if err := store.SaveCtfdData(poolId, CtfdData{CtfdData: ctfdUsers}); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
 		return false
 	}
 
+	recordCtfdDataChange(poolId, PoolActionCtfdData, c.GetString("userID"), true)
 	return true
 }
 
-// DeleteCtfdData deletes the ctfd_data.json file from a pool directory
-func DeleteCtfdData(poolId string) error {
-	ctfdDataPath := filepath.Join(config.PoolFolder, poolId, "ctfd_data.json")
-
-	err := os.Remove(ctfdDataPath)
-	if err != nil && !os.IsNotExist(err) {
+// DeleteCtfdData deletes the CTFd data of a pool on behalf of userId
+func DeleteCtfdData(poolId, userId string) error {
+	if !store.HasCtfdData(poolId) {
+		return nil
+	}
+	if err := store.DeleteCtfdData(poolId); err != nil {
 		return err
 	}
-
+	recordCtfdDataChange(poolId, PoolActionCtfdDataDelete, userId, false)
 	return nil
 }
 
 // Check if pool has CTFd data
-func HasCtfdData(poolPath string) bool {
-	ctfdDataPath := filepath.Join(poolPath, "ctfd_data.json")
-	_, err := os.Stat(ctfdDataPath)
-	return err == nil
+func HasCtfdData(poolId string) bool {
+	return store.HasCtfdData(poolId)
 }
 
 // GetScenarioModeFromZip extracts and validates a CTFd scenario zip file and returns the user mode
-func GetScenarioModeFromZip(zipPath string) (string, error) {
+func GetScenarioModeFromZip(content []byte) (string, error) {
 	// Open the zip file
-	r, err := zip.OpenReader(zipPath)
+	r, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
 	if err != nil {
 		return "", fmt.Errorf("Bad Request")
 	}
-	defer r.Close()
 
 	// Look for db/config.json in the zip
 	var configFile *zip.File
@@ -198,37 +169,25 @@ func GetScenarioModeFromZip(zipPath string) (string, error) {
 
 // GetSingleScenarioWithMode gets a single scenario and includes its mode
 func GetSingleScenarioWithMode(c *gin.Context, scenarioID string) {
-	scenarioPath, ok := ValidateFolderId(c, config.CtfdScenarioFolder, scenarioID)
-	if !ok {
+	if !validStoreId(c, scenarioID) {
 		return
 	}
 
-	fileInfo, err := ReadFirstFileInDir(scenarioPath)
-	if HandleFileReadError(c, err) {
+	scenario, err := store.GetScenario(scenarioID)
+	if err != nil {
+		WriteStoreError(c, err)
 		return
 	}
 
-	// Try to get scenario mode if it's a zip file
-	var scenarioMode *string
-	if strings.HasSuffix(fileInfo.Name, ".zip") {
-		zipPath := filepath.Join(scenarioPath, fileInfo.Name)
-		mode, err := GetScenarioModeFromZip(zipPath)
-		if err == nil {
-			scenarioMode = &mode
-		}
-	}
-
-	encoded := base64.StdEncoding.EncodeToString([]byte(fileInfo.Content))
-
 	response := gin.H{
 		"scenarioId":   scenarioID,
-		"scenarioName": fileInfo.Name,
-		"scenarioFile": encoded,
-		"createdAt":    fileInfo.CreationTime.Format(config.TimestampFormat),
+		"scenarioName": scenario.Name,
+		"scenarioFile": base64.StdEncoding.EncodeToString(scenario.Content),
+		"createdAt":    scenario.CreatedAt.Format(config.TimestampFormat),
 	}
 
-	if scenarioMode != nil {
-		response["scenarioMode"] = *scenarioMode
+	if scenario.Mode != "" {
+		response["scenarioMode"] = scenario.Mode
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -236,56 +195,74 @@ func GetSingleScenarioWithMode(c *gin.Context, scenarioID string) {
 
 // GetAllScenariosWithMode gets all scenarios and includes their modes
 func GetAllScenariosWithMode(c *gin.Context) {
-	items, err := os.ReadDir(config.CtfdScenarioFolder)
+	scenarios, err := store.ListScenarios()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
 		return
 	}
 
 	var scenarioList []gin.H
-	for _, item := range items {
-		if item.IsDir() {
-			scenarioPath := filepath.Join(config.CtfdScenarioFolder, item.Name())
-
-			// Try to read first file for name and creation time
-			fileInfo, err := ReadFirstFileInDir(scenarioPath)
-			var fileName string
-			var createdAt string
-			var scenarioMode *string
-
-			if err == nil {
-				fileName = fileInfo.Name
-				createdAt = fileInfo.CreationTime.Format(config.TimestampFormat)
-
-				// Try to get scenario mode if it's a zip file
-				if strings.HasSuffix(fileName, ".zip") {
-					zipPath := filepath.Join(scenarioPath, fileName)
-					mode, err := GetScenarioModeFromZip(zipPath)
-					if err == nil {
-						scenarioMode = &mode
-					}
-				}
-			} else {
-				// Fallback to directory modification time
-				dirInfo, dirErr := os.Stat(scenarioPath)
-				if dirErr == nil {
-					createdAt = dirInfo.ModTime().Format(config.TimestampFormat)
-				}
-			}
-
-			scenarioItem := gin.H{
-				"scenarioId":   item.Name(),
-				"scenarioName": fileName,
-				"createdAt":    createdAt,
-			}
-
-			if scenarioMode != nil {
-				scenarioItem["scenarioMode"] = *scenarioMode
-			}
+	for _, scenario := range scenarios {
+		scenarioItem := gin.H{
+			"scenarioId":   scenario.Id,
+			"scenarioName": scenario.Name,
+			"createdAt":    scenario.CreatedAt.Format(config.TimestampFormat),
+		}
 
-			scenarioList = append(scenarioList, scenarioItem)
+		if scenario.Mode != "" {
+			scenarioItem["scenarioMode"] = scenario.Mode
 		}
+
+		scenarioList = append(scenarioList, scenarioItem)
 	}
 
 	c.JSON(http.StatusOK, scenarioList)
 }
+
+// SaveScenarioWithResponse stores an uploaded scenario archive with its user mode.
+// An empty scenarioId creates a new scenario.
+func SaveScenarioWithResponse(c *gin.Context, scenarioId, fileName, scenarioMode string, content []byte) (string, bool) {
+	if scenarioId != "" && !validStoreId(c, scenarioId) {
+		return "", false
+	}
+
+	id, err := store.SaveScenario(scenarioId, fileName, scenarioMode, content)
+	if err != nil {
+		WriteStoreError(c, err)
+		return "", false
+	}
+	return id, true
+}
+
+// ValidateScenarioId checks that the scenario exists
+func ValidateScenarioId(c *gin.Context, scenarioId string) bool {
+	if !validStoreId(c, scenarioId) {
+		return false
+	}
+	if _, err := store.GetScenario(scenarioId); err != nil {
+		WriteStoreError(c, err)
+		return false
+	}
+	return true
+}
+
+// DeleteScenarioWithResponse deletes a scenario and handles HTTP responses
+func DeleteScenarioWithResponse(c *gin.Context, scenarioId string) bool {
+	if !validStoreId(c, scenarioId) {
+		return false
+	}
+	if err := store.DeleteScenario(scenarioId); err != nil {
+		WriteStoreError(c, err)
+		return false
+	}
+	return true
+}
+
+// CountScenarios returns the number of scenarios
+func CountScenarios() int {
+	scenarios, err := store.ListScenarios()
+	if err != nil {
+		return 0
+	}
+	return len(scenarios)
+}
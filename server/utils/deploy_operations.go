@@ -0,0 +1,603 @@
+package utils
+
+import (
+	"context"
+	"dulus/server/config"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Interval between range state checks while waiting for a batch
+const deployCheckInterval = 30 * time.Second
+
+// batchProcessor runs the Ludus operations of one batch of a job. Ranges still
+// busy at deadline are timed out. It returns early once ctx is cancelled by an
+// abort or pause.
+type batchProcessor func(ctx context.Context, jobId string, userIds []string, deadline time.Time, client LudusClient)
+
+// StartDeployJob runs a deploy job in a background goroutine
+func StartDeployJob(jobId string, client LudusClient) {
+	startBatchedJob(jobId, client, deployBatch)
+}
+
+// StartRedeployJob runs a redeploy job in a background goroutine
+func StartRedeployJob(jobId string, client LudusClient) {
+	startBatchedJob(jobId, client, redeployBatch)
+}
+
+// startBatchedJob registers the job's worker before starting it, so an abort
+// that arrives right away already reaches it
+func startBatchedJob(jobId string, client LudusClient, processBatch batchProcessor) {
+	ctx, finish := startJobRunner(jobId)
+	go func() {
+		defer finish()
+		runBatchedJob(ctx, jobId, client.WithContext(ctx), processBatch)
+	}()
+}
+
+// StartPoolJob creates a deploy or redeploy job for the given users of a pool and
+// runs it in the background
+func StartPoolJob(client LudusClient, poolId, jobType, createdBy string, userIds []string, concurrentRequests int, policy DeployPolicy) (Job, error) {
+	job, err := CreateJob(poolId, jobType, createdBy, userIds, concurrentRequests, policy)
+	if err != nil {
+		return Job{}, err
+	}
+
+	if jobType == JobTypeRedeploy {
+		StartRedeployJob(job.JobId, client)
+	} else {
+		StartDeployJob(job.JobId, client)
+	}
+	return job, nil
+}
+
+// DestroyPoolRanges destroys the ranges of the given users of a pool as a tracked
+// destroy job and removes the pool's CTFd data afterwards
+func DestroyPoolRanges(client LudusClient, poolId, createdBy string, userIds []string) (Job, []LudusResponse, error) {
+	job, err := CreateJob(poolId, JobTypeDestroy, createdBy, userIds, len(userIds), DeployPolicy{})
+	if err != nil {
+		return Job{}, nil, err
+	}
+
+	responses := RunDestroyJob(job.JobId, client)
+
+	DeleteCtfdData(poolId, createdBy)
+	return job, responses, nil
+}
+
+// StartPoolDeleteJob creates a job that removes everything a pool has in Ludus and
+// then the pool itself, and runs it in the background. Every user of the pool gets
+// a result, main users of SHARED pools for their range and members for their account.
+func StartPoolDeleteJob(client LudusClient, pool Pool, createdBy string) (Job, error) {
+	job, err := CreateJob(pool.Id, JobTypeDeletePool, createdBy, PoolUserIds(pool, SharedAllUsers), 0, DefaultDeployPolicy())
+	if err != nil {
+		return Job{}, err
+	}
+
+	ctx, finish := startJobRunner(job.JobId)
+	go func() {
+		defer finish()
+		runPoolDeleteJob(ctx, job.JobId, client.WithContext(ctx), pool, createdBy)
+	}()
+	return job, nil
+}
+
+// runPoolDeleteJob revokes the members' access to shared ranges, destroys the
+// ranges, waits for them to be destroyed and deletes the BATCH users. The pool is
+// only deleted when every step succeeded for every user, otherwise the job fails
+// and the pool is kept so the deletion can be started again. Ranges and users
+// that are already gone count as done. An abort stops it before the next step,
+// calls already sent are completed so their results are recorded.
+func runPoolDeleteJob(ctx context.Context, jobId string, client LudusClient, pool Pool, createdBy string) {
+	MarkJobStarted(jobId)
+	MarkBatchStarted(jobId, 0)
+
+	inFlightClient := client.WithContext(context.WithoutCancel(ctx))
+	stopped := func() bool { return ctx.Err() != nil || !IsJobActive(jobId) }
+	failed := make(map[string]bool)
+	withoutFailed := func(userIds []string) []string {
+		var kept []string
+		for _, userId := range userIds {
+			if !failed[userId] {
+				kept = append(kept, userId)
+			}
+		}
+		return kept
+	}
+	recordFailures := func(responses []LudusResponse, step string) {
+		for _, resp := range responses {
+			if resp.Error != nil && !IsLudusNotFound(resp.Error) {
+				failed[resp.UserID] = true
+				SetUserResult(jobId, resp.UserID, UserStatusFailed, "", step+" failed: "+resp.Error.Error())
+			}
+		}
+	}
+
+	// Step 1: Revoke the members' access to their main user's range
+	if pool.Type == "SHARED" {
+		recordFailures(RunConcurrentTasks(unshareTasks(inFlightClient, pool.UsersAndTeams), config.MaxConcurrentRequests), "unshare")
+	}
+	if stopped() {
+		return
+	}
+
+	// Step 2: Destroy the ranges, the same ones RemoveRange destroys
+	owners := PoolUserIds(pool, SharedMainUserOnly)
+	recordFailures(destroyRanges(inFlightClient, owners), "destroy")
+	if stopped() {
+		return
+	}
+
+	// Step 3: Wait for the ranges to be destroyed, stuck ones are recorded as failed
+	destroying := withoutFailed(owners)
+	destroyed := awaitDestroyed(ctx, jobId, destroying, GetJobPolicy(jobId).BatchDeadline(time.Now()), client)
+	if stopped() {
+		return
+	}
+	for _, userId := range removeIds(destroying, destroyed) {
+		failed[userId] = true
+	}
+	if pool.Type == "SHARED" {
+		for _, userId := range destroyed {
+			SetUserResult(jobId, userId, UserStatusSuccess, "DESTROYED", "")
+		}
+	}
+
+	// Step 4: Delete the BATCH users whose range and access are gone
+	members := withoutFailed(PoolUserIds(pool, SharedUsersAndTeamsOnly))
+	responses := RunConcurrentTasks(UserTasks(members, func(userId string) (interface{}, error) {
+		return inFlightClient.DeleteUser(userId)
+	}), config.MaxConcurrentRequests)
+	recordFailures(responses, "delete user")
+	for _, userId := range withoutFailed(members) {
+		SetUserResult(jobId, userId, UserStatusSuccess, "", "")
+	}
+	if stopped() {
+		return
+	}
+
+	// Step 5: Delete the pool, unless a step failed or the pool changed meanwhile
+	if len(failed) > 0 {
+		MarkJobFinished(jobId, JobStatusFailed, fmt.Sprintf("%d user(s) could not be cleaned up, the pool was kept", len(failed)))
+		return
+	}
+	if current, err := store.GetPool(pool.Id); err != nil || current.Revision != pool.Revision {
+		MarkJobFinished(jobId, JobStatusFailed, "the pool was changed or deleted while it was being cleaned up, the pool was kept")
+		return
+	}
+	if err := deletePool(pool, createdBy); err != nil {
+		MarkJobFinished(jobId, JobStatusFailed, "failed to delete pool: "+err.Error())
+		return
+	}
+	DeletePoolSchedules(pool.Id)
+
+	MarkBatchFinished(jobId, 0)
+	MarkJobFinished(jobId, JobStatusCompleted, "")
+}
+
+// ResumeInterruptedJobs continues deploy and redeploy jobs that were cut off by a
+// service restart. Without a service API key they are marked as failed instead.
+func ResumeInterruptedJobs() {
+	for _, job := range GetInterruptedJobs() {
+		if config.LudusServiceApiKey == "" {
+			MarkJobFinished(job.JobId, JobStatusFailed, "interrupted by service restart, LUDUS_SERVICE_API_KEY is not set")
+			continue
+		}
+
+		log.Printf("Resuming %s job %s for pool %s", job.Type, job.JobId, job.PoolId)
+		MarkJobResumed(job.JobId)
+
+		client := NewLudusClient(config.LudusServiceApiKey)
+		switch job.Type {
+		case JobTypeDeploy:
+			StartDeployJob(job.JobId, client)
+		case JobTypeRedeploy:
+			StartRedeployJob(job.JobId, client)
+		default:
+			MarkJobFinished(job.JobId, JobStatusFailed, "interrupted by service restart")
+		}
+	}
+}
+
+// ResumePausedJob continues a paused deploy or redeploy job in the background
+func ResumePausedJob(jobId string, client LudusClient) bool {
+	job, exists := GetJob(jobId)
+	if !exists || job.Status != JobStatusPaused {
+		return false
+	}
+
+	MarkJobResumed(jobId)
+	switch job.Type {
+	case JobTypeDeploy:
+		StartDeployJob(jobId, client)
+	case JobTypeRedeploy:
+		StartRedeployJob(jobId, client)
+	default:
+		return false
+	}
+	return true
+}
+
+// GetStuckRanges reports ranges of the pool's active job that have been deploying
+// or destroying for longer than the job's range timeout. states holds the current
+// range state of each user as reported by Ludus.
+func GetStuckRanges(poolId string, states map[string]string) []gin.H {
+	stuck := []gin.H{}
+
+	job, exists := GetActivePoolJob(poolId)
+	if !exists || job.Policy.RangeTimeout() == 0 {
+		return stuck
+	}
+
+	for _, result := range job.Results {
+		state := states[result.UserId]
+		if state != "DEPLOYING" && state != "DESTROYING" {
+			continue
+		}
+		if result.SentAt == nil || time.Since(*result.SentAt) <= job.Policy.RangeTimeout() {
+			continue
+		}
+
+		stuck = append(stuck, gin.H{
+			"userId":  result.UserId,
+			"state":   state,
+			"jobId":   job.JobId,
+			"since":   result.SentAt.Format(config.TimestampFormat),
+			"minutes": int(time.Since(*result.SentAt).Minutes()),
+		})
+	}
+	return stuck
+}
+
+// runBatchedJob processes the batches of a job one after another until the job
+// is finished, paused or aborted. Completed batches are skipped and a batch that
+// was running when the service stopped or the job was paused is reconciled
+// against Ludus first.
+func runBatchedJob(ctx context.Context, jobId string, client LudusClient, processBatch batchProcessor) {
+	MarkJobStarted(jobId)
+
+	job, exists := GetJob(jobId)
+	if !exists {
+		return
+	}
+
+	for _, batch := range job.Batches {
+		if batch.Status == JobStatusCompleted {
+			continue
+		}
+		if !IsJobActive(jobId) {
+			return
+		}
+
+		// The batch timeout covers reconciling, retries and redeploys of the batch
+		deadline := GetJobPolicy(jobId).BatchDeadline(time.Now())
+
+		userIds := batch.UserIds
+		if batch.Status == JobStatusRunning {
+			userIds = reconcileBatch(ctx, jobId, batch.UserIds, deadline, client)
+		}
+
+		MarkBatchStarted(jobId, batch.Index)
+		if len(userIds) > 0 && ctx.Err() == nil {
+			processBatch(ctx, jobId, userIds, deadline, client)
+		}
+
+		// A paused or aborted job leaves its current batch unfinished
+		if ctx.Err() != nil || !IsJobActive(jobId) {
+			return
+		}
+		MarkBatchFinished(jobId, batch.Index)
+	}
+
+	MarkJobFinished(jobId, JobStatusCompleted, "")
+}
+
+// reconcileBatch compares the recorded results of an interrupted batch with the
+// range states reported by Ludus. Ranges that are still deploying are awaited,
+// ranges that were already sent get their final state recorded (and retried if
+// the policy allows), and the users that never got a deploy request are returned
+// so the batch can continue.
+func reconcileBatch(ctx context.Context, jobId string, userIds []string, deadline time.Time, client LudusClient) []string {
+	job, exists := GetJob(jobId)
+	if !exists {
+		return nil
+	}
+
+	var unfinished []string
+	for _, userId := range userIds {
+		result := job.Result(userId)
+		if result != nil && (result.Status == UserStatusPending || result.Status == UserStatusSent || result.Status == UserStatusRetrying) {
+			unfinished = append(unfinished, userId)
+		}
+	}
+
+	var awaiting []string
+	var pending []string
+
+	states := GetRangeStates(client, unfinished)
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	for userId, state := range states {
+		if state == "DEPLOYING" || job.Result(userId).Status != UserStatusPending {
+			awaiting = append(awaiting, userId)
+		} else {
+			pending = append(pending, userId)
+		}
+	}
+
+	if len(awaiting) > 0 {
+		awaitDeployment(ctx, jobId, awaiting, deadline, client)
+	}
+
+	return pending
+}
+
+// deployBatch sends deploy requests for a batch and waits for the ranges to finish deploying
+func deployBatch(ctx context.Context, jobId string, userIds []string, deadline time.Time, client LudusClient) {
+	sent := sendDeployRequests(ctx, jobId, userIds, client)
+	awaitDeployment(ctx, jobId, sent, deadline, client)
+}
+
+// redeployBatch destroys failed ranges of a batch and deploys them again
+func redeployBatch(ctx context.Context, jobId string, userIds []string, deadline time.Time, client LudusClient) {
+	var usersToDestroy []string
+	var usersDestroying []string
+	var usersToRedeploy []string
+
+	// Step 1: Check current states and determine actions
+	states := GetRangeStates(client, userIds)
+	if ctx.Err() != nil {
+		return
+	}
+	for userId, state := range states {
+		switch state {
+		case "ERROR", "ABORTED":
+			usersToDestroy = append(usersToDestroy, userId)
+		case "DESTROYING":
+			// Wait for these to finish destroying, then they'll be redeployed
+			usersDestroying = append(usersDestroying, userId)
+		case "DESTROYED":
+			usersToRedeploy = append(usersToRedeploy, userId)
+		default:
+			// Skip users in other states
+			SetUserResult(jobId, userId, UserStatusSkipped, state, "")
+		}
+	}
+
+	// Step 2: Destroy ranges that need destroying
+	destroyRanges(client, usersToDestroy)
+
+	// Step 3: Wait for all ranges in this batch to be destroyed
+	var allUsersInBatch []string
+	allUsersInBatch = append(allUsersInBatch, usersToDestroy...)
+	allUsersInBatch = append(allUsersInBatch, usersDestroying...)
+	allUsersInBatch = append(allUsersInBatch, usersToRedeploy...)
+	if len(allUsersInBatch) == 0 {
+		return
+	}
+	destroyed := awaitDestroyed(ctx, jobId, allUsersInBatch, deadline, client)
+
+	// Step 4: Redeploy all ranges that were destroyed and wait for them to finish
+	sent := sendDeployRequests(ctx, jobId, destroyed, client)
+	awaitDeployment(ctx, jobId, sent, deadline, client)
+}
+
+// awaitDeployment waits for the ranges of a batch to finish deploying. Ranges that
+// end in a retryable state are destroyed and deployed again until the job's
+// policy runs out of attempts, then the final state of every user is recorded.
+// Ranges that exceed the range timeout or are still busy at the batch deadline
+// are set aside and handled by the policy timeout action once the retries of
+// the other ranges are done.
+// When ctx is cancelled the users are left as they are, sent users stay SENT.
+func awaitDeployment(ctx context.Context, jobId string, userIds []string, deadline time.Time, client LudusClient) {
+	states, timedOut := WaitForBatchDeployment(ctx, client, userIds, deployWaitOptions(jobId, userIds, deadline))
+	if ctx.Err() != nil {
+		return
+	}
+	for _, userId := range timedOut {
+		delete(states, userId)
+	}
+
+	for round := 1; IsJobActive(jobId); round++ {
+		retry := rangesToRetry(jobId, states)
+		if len(retry) == 0 {
+			break
+		}
+
+		if !sleepContext(ctx, GetJobPolicy(jobId).Backoff(round)) {
+			return
+		}
+
+		destroyRanges(client, retry)
+		destroyed := awaitDestroyed(ctx, jobId, retry, deadline, client)
+
+		// Users whose deploy request fails are recorded by sendDeployRequests
+		for _, userId := range retry {
+			delete(states, userId)
+		}
+		sent := sendDeployRequests(ctx, jobId, destroyed, client)
+
+		retryStates, retryTimedOut := WaitForBatchDeployment(ctx, client, sent, deployWaitOptions(jobId, sent, deadline))
+		if ctx.Err() != nil {
+			return
+		}
+		for _, userId := range retryTimedOut {
+			delete(retryStates, userId)
+		}
+		for userId, state := range retryStates {
+			states[userId] = state
+		}
+		timedOut = append(timedOut, retryTimedOut...)
+	}
+
+	recordDeployStates(jobId, states)
+
+	if len(timedOut) > 0 {
+		handleTimedOutRanges(jobId, timedOut, client)
+	}
+}
+
+// awaitDestroyed waits for ranges to be destroyed and returns the users that made
+// it. Ranges stuck in DESTROYING past the policy timeouts are recorded as failed.
+func awaitDestroyed(ctx context.Context, jobId string, userIds []string, deadline time.Time, client LudusClient) []string {
+	timedOut := WaitForBatchDestroyed(ctx, client, userIds, GetJobPolicy(jobId).WaitOptions(nil, deadline))
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	stuck := make(map[string]bool)
+	for _, userId := range timedOut {
+		stuck[userId] = true
+		SetUserResult(jobId, userId, UserStatusFailed, "DESTROYING", "timed out waiting for range to be destroyed")
+	}
+
+	var destroyed []string
+	for _, userId := range userIds {
+		if !stuck[userId] {
+			destroyed = append(destroyed, userId)
+		}
+	}
+	return destroyed
+}
+
+// deployWaitOptions builds wait options where each range timeout starts at the
+// moment its latest deploy request was sent and the batch ends at deadline
+func deployWaitOptions(jobId string, userIds []string, deadline time.Time) WaitOptions {
+	job, _ := GetJob(jobId)
+
+	startedAt := make(map[string]time.Time)
+	for _, userId := range userIds {
+		if result := job.Result(userId); result != nil && result.SentAt != nil {
+			startedAt[userId] = *result.SentAt
+		}
+	}
+	return job.Policy.WaitOptions(startedAt, deadline)
+}
+
+// handleTimedOutRanges applies the policy timeout action to ranges that are stuck deploying
+func handleTimedOutRanges(jobId string, userIds []string, client LudusClient) {
+	switch GetJobPolicy(jobId).TimeoutAction {
+	case TimeoutActionAbort:
+		for _, resp := range abortRanges(client, userIds) {
+			message := "deployment timed out, range aborted"
+			if resp.Error != nil {
+				message = "deployment timed out, abort failed: " + resp.Error.Error()
+			}
+			SetUserResult(jobId, resp.UserID, UserStatusFailed, "DEPLOYING", message)
+		}
+	case TimeoutActionPause:
+		// Leave the users as sent so resuming the job waits for them again
+		PauseJob(jobId, fmt.Sprintf("paused after %d range(s) timed out while deploying", len(userIds)))
+	default:
+		for _, userId := range userIds {
+			SetUserResult(jobId, userId, UserStatusFailed, "DEPLOYING", "deployment timed out")
+		}
+	}
+}
+
+// rangesToRetry returns the users whose range failed in a retryable state and
+// who still have attempts left, marking them as retrying
+func rangesToRetry(jobId string, states map[string]string) []string {
+	job, exists := GetJob(jobId)
+	if !exists {
+		return nil
+	}
+
+	var retry []string
+	for userId, state := range states {
+		result := job.Result(userId)
+		if result == nil || !job.Policy.IsRetryable(state) || result.Attempts >= job.Policy.MaxAttempts {
+			continue
+		}
+		SetUserResult(jobId, userId, UserStatusRetrying, state, "range ended in state "+state)
+		retry = append(retry, userId)
+	}
+	return retry
+}
+
+// abortRanges sends abort requests for all given users concurrently
+func abortRanges(client LudusClient, userIds []string) []LudusResponse {
+	return RunConcurrentTasks(RangeActionTasks(client, userIds, LudusClient.AbortRange), config.MaxConcurrentRequests)
+}
+
+// destroyRanges sends destroy requests for all given users concurrently
+func destroyRanges(client LudusClient, userIds []string) []LudusResponse {
+	if len(userIds) == 0 {
+		return nil
+	}
+	return RunConcurrentTasks(RangeActionTasks(client, userIds, LudusClient.DestroyRange), config.MaxConcurrentRequests)
+}
+
+// sendDeployRequests sends deploy requests sequentially and returns the users that accepted them.
+// Each result is recorded as soon as its request returns so a restart knows what was already sent.
+// Cancelling ctx stops before the next request, a request already in flight is
+// completed so its outcome is known.
+func sendDeployRequests(ctx context.Context, jobId string, userIds []string, client LudusClient) []string {
+	inFlightClient := client.WithContext(context.WithoutCancel(ctx))
+	tasks := RangeActionTasks(inFlightClient, userIds, LudusClient.DeployRange)
+
+	var sent []string
+	RunSequentialTasksWithSleep(ctx, tasks, config.DeploySleepDuration, func(resp LudusResponse) {
+		if resp.Error != nil {
+			SetUserResult(jobId, resp.UserID, UserStatusFailed, "", resp.Error.Error())
+			return
+		}
+		SetUserResult(jobId, resp.UserID, UserStatusSent, "", "")
+		sent = append(sent, resp.UserID)
+	})
+	return sent
+}
+
+// recordDeployStates stores the final range state of each user in the job result
+func recordDeployStates(jobId string, states map[string]string) {
+	for userId, state := range states {
+		if state == "SUCCESS" || state == "DEPLOYED" {
+			SetUserResult(jobId, userId, UserStatusSuccess, state, "")
+		} else {
+			SetUserResult(jobId, userId, UserStatusFailed, state, "range ended in state "+state)
+		}
+	}
+}
+
+// RunDestroyJob destroys the ranges of all users of a job and records the per-user
+// results. An abort stops it before the next batch.
+func RunDestroyJob(jobId string, client LudusClient) []LudusResponse {
+	ctx, finish := startJobRunner(jobId)
+	defer finish()
+	client = client.WithContext(context.WithoutCancel(ctx))
+
+	MarkJobStarted(jobId)
+
+	job, exists := GetJob(jobId)
+	if !exists {
+		return nil
+	}
+
+	var responses []LudusResponse
+	for _, batch := range job.Batches {
+		if ctx.Err() != nil {
+			break
+		}
+		MarkBatchStarted(jobId, batch.Index)
+
+		batchResponses := destroyRanges(client, batch.UserIds)
+		for _, resp := range batchResponses {
+			if resp.Error != nil {
+				SetUserResult(jobId, resp.UserID, UserStatusFailed, "", resp.Error.Error())
+			} else {
+				SetUserResult(jobId, resp.UserID, UserStatusSuccess, "", "")
+			}
+		}
+		responses = append(responses, batchResponses...)
+
+		MarkBatchFinished(jobId, batch.Index)
+	}
+
+	MarkJobFinished(jobId, JobStatusCompleted, "")
+	return responses
+}
@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"context"
+	"dulus/server/config"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRangeClient keeps a range state per user. Deployed ranges end in the
+// state given in deployTo, DEPLOYED if there is none.
+type fakeRangeClient struct {
+	LudusClient
+
+	mutex    sync.Mutex
+	states   map[string]string
+	deployTo map[string]string
+	deploys  map[string]int
+}
+
+func newFakeRangeClient(states map[string]string) *fakeRangeClient {
+	return &fakeRangeClient{states: states, deployTo: make(map[string]string), deploys: make(map[string]int)}
+}
+
+func (f *fakeRangeClient) WithContext(ctx context.Context) LudusClient {
+	return f
+}
+
+func (f *fakeRangeClient) GetRange(userId string) (LudusRange, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return LudusRange{UserID: userId, RangeState: f.states[userId]}, nil
+}
+
+func (f *fakeRangeClient) DeployRange(userId string) (LudusResult, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.deploys[userId]++
+	f.states[userId] = "DEPLOYED"
+	if state, exists := f.deployTo[userId]; exists {
+		f.states[userId] = state
+	}
+	return LudusResult{}, nil
+}
+
+func (f *fakeRangeClient) DestroyRange(userId string) (LudusResult, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.states[userId] = "DESTROYED"
+	return LudusResult{}, nil
+}
+
+func (f *fakeRangeClient) AbortRange(userId string) (LudusResult, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.states[userId] = "ABORTED"
+	return LudusResult{}, nil
+}
+
+// startTestJob creates a running job whose users all got a deploy request
+func startTestJob(t *testing.T, userIds []string, policy DeployPolicy) string {
+	t.Helper()
+	config.JobFolder = t.TempDir()
+	job, err := CreateJob(t.Name(), "deploy", "ADMIN", userIds, 0, policy)
+	if err != nil {
+		t.Fatalf("create job: %v", err)
+	}
+	MarkJobStarted(job.JobId)
+	t.Cleanup(func() { MarkJobFinished(job.JobId, JobStatusCompleted, "") })
+	for _, userId := range userIds {
+		SetUserResult(job.JobId, userId, UserStatusSent, "", "")
+	}
+	return job.JobId
+}
+
+func TestAwaitDeploymentRetriesNextToTimedOutRange(t *testing.T) {
+	policy := DeployPolicy{MaxAttempts: 3, RetryableStates: []string{"ERROR"}, RangeTimeoutMinutes: 1, TimeoutAction: TimeoutActionFail}
+	jobId := startTestJob(t, []string{"STUCK", "FAILED"}, policy)
+
+	// STUCK has been deploying for longer than the range timeout
+	UpdateJob(jobId, func(job *Job) {
+		sentAt := time.Now().Add(-time.Hour)
+		job.Result("STUCK").SentAt = &sentAt
+	})
+
+	client := newFakeRangeClient(map[string]string{"STUCK": "DEPLOYING", "FAILED": "ERROR"})
+	client.deployTo["STUCK"] = "DEPLOYING"
+	awaitDeployment(context.Background(), jobId, []string{"STUCK", "FAILED"}, time.Time{}, client)
+
+	job, _ := GetJob(jobId)
+	stuck, failed := job.Result("STUCK"), job.Result("FAILED")
+	if stuck.Status != UserStatusFailed || stuck.Error != "deployment timed out" {
+		t.Fatalf("expected the stuck range to time out, got %+v", stuck)
+	}
+	if failed.Status != UserStatusSuccess || failed.Attempts != 2 || client.deploys["FAILED"] != 1 {
+		t.Fatalf("expected the failed range to be deployed again, got %+v after %d deploys", failed, client.deploys["FAILED"])
+	}
+	if client.deploys["STUCK"] != 0 {
+		t.Fatalf("the timed out range was deployed again")
+	}
+}
+
+func TestAwaitDeploymentStopsAfterMaxAttempts(t *testing.T) {
+	policy := DeployPolicy{MaxAttempts: 2, RetryableStates: []string{"ERROR"}, TimeoutAction: TimeoutActionFail}
+	jobId := startTestJob(t, []string{"FAILED"}, policy)
+
+	client := newFakeRangeClient(map[string]string{"FAILED": "ERROR"})
+	client.deployTo["FAILED"] = "ERROR"
+	awaitDeployment(context.Background(), jobId, []string{"FAILED"}, time.Time{}, client)
+
+	job, _ := GetJob(jobId)
+	if result := job.Result("FAILED"); result.Status != UserStatusFailed || result.Attempts != 2 || result.RangeState != "ERROR" {
+		t.Fatalf("expected the range to fail after 2 attempts, got %+v", result)
+	}
+}
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"dulus/server/config"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Actions taken when a range or batch exceeds its deployment timeout
+const (
+	TimeoutActionAbort = "ABORT" // abort the range in Ludus and mark it failed
+	TimeoutActionFail  = "FAIL"  // mark the range failed and continue with the next batch
+	TimeoutActionPause = "PAUSE" // pause the job until it is resumed or aborted
+)
+
+// DeployPolicy controls how a deploy or redeploy job reacts to failed and stuck ranges
+type DeployPolicy struct {
+	MaxAttempts         int      `json:"maxAttempts"`
+	BackoffSeconds      int      `json:"backoffSeconds"`
+	RetryableStates     []string `json:"retryableStates"`
+	RangeTimeoutMinutes int      `json:"rangeTimeoutMinutes"`
+	BatchTimeoutMinutes int      `json:"batchTimeoutMinutes"`
+	TimeoutAction       string   `json:"timeoutAction"`
+}
+
+// DefaultDeployPolicy returns the policy configured through the environment
+func DefaultDeployPolicy() DeployPolicy {
+	return DeployPolicy{
+		MaxAttempts:         config.DeployMaxAttempts,
+		BackoffSeconds:      config.DeployRetryBackoffSeconds,
+		RetryableStates:     append([]string{}, config.DeployRetryableStates...),
+		RangeTimeoutMinutes: config.DeployRangeTimeoutMinutes,
+		BatchTimeoutMinutes: config.DeployBatchTimeoutMinutes,
+		TimeoutAction:       config.DeployTimeoutAction,
+	}
+}
+
+// RangeTimeout returns the maximum time a single range may stay deploying, 0 if unlimited
+func (p DeployPolicy) RangeTimeout() time.Duration {
+	return time.Duration(p.RangeTimeoutMinutes) * time.Minute
+}
+
+// BatchDeadline returns when a batch started at start runs out of time, the zero
+// time if batches are unlimited. Every wait of the batch, including its retry
+// rounds, shares this deadline.
+func (p DeployPolicy) BatchDeadline(start time.Time) time.Time {
+	if p.BatchTimeoutMinutes <= 0 {
+		return time.Time{}
+	}
+	return start.Add(time.Duration(p.BatchTimeoutMinutes) * time.Minute)
+}
+
+// WaitOptions returns batch wait options that enforce the policy timeouts
+func (p DeployPolicy) WaitOptions(startedAt map[string]time.Time, batchDeadline time.Time) WaitOptions {
+	return WaitOptions{
+		CheckInterval: deployCheckInterval,
+		RangeTimeout:  p.RangeTimeout(),
+		BatchDeadline: batchDeadline,
+		StartedAt:     startedAt,
+	}
+}
+
+// IsRetryable reports whether a range ending in state should be destroyed and deployed again
+func (p DeployPolicy) IsRetryable(state string) bool {
+	for _, retryable := range p.RetryableStates {
+		if retryable == state {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the wait before the given retry round (1-based), doubling every round
+func (p DeployPolicy) Backoff(round int) time.Duration {
+	if p.BackoffSeconds <= 0 || round < 1 {
+		return 0
+	}
+	if round > 6 {
+		round = 6
+	}
+	return time.Duration(p.BackoffSeconds) * time.Second * time.Duration(1<<(round-1))
+}
+
+// ParseDeployPolicy reads optional maxAttempts, backoffSeconds and retryableStates
+// query parameters on top of the configured defaults and handles error responses
+func ParseDeployPolicy(c *gin.Context) (DeployPolicy, bool) {
+	policy := DefaultDeployPolicy()
+
+	if maxAttemptsStr := GetOptionalQueryParam(c, "maxAttempts"); maxAttemptsStr != "" {
+		maxAttempts, err := strconv.Atoi(maxAttemptsStr)
+		if err != nil || maxAttempts < 1 || maxAttempts > 10 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid maxAttempts value"})
+			return DeployPolicy{}, false
+		}
+		policy.MaxAttempts = maxAttempts
+	}
+
+	if backoffStr := GetOptionalQueryParam(c, "backoffSeconds"); backoffStr != "" {
+		backoff, err := strconv.Atoi(backoffStr)
+		if err != nil || backoff < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid backoffSeconds value"})
+			return DeployPolicy{}, false
+		}
+		policy.BackoffSeconds = backoff
+	}
+
+	if statesStr := GetOptionalQueryParam(c, "retryableStates"); statesStr != "" {
+		states, ok := parseRetryableStates(statesStr)
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid retryableStates value"})
+			return DeployPolicy{}, false
+		}
+		policy.RetryableStates = states
+	}
+
+	if rangeTimeoutStr := GetOptionalQueryParam(c, "rangeTimeoutMinutes"); rangeTimeoutStr != "" {
+		rangeTimeout, err := strconv.Atoi(rangeTimeoutStr)
+		if err != nil || rangeTimeout < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rangeTimeoutMinutes value"})
+			return DeployPolicy{}, false
+		}
+		policy.RangeTimeoutMinutes = rangeTimeout
+	}
+
+	if batchTimeoutStr := GetOptionalQueryParam(c, "batchTimeoutMinutes"); batchTimeoutStr != "" {
+		batchTimeout, err := strconv.Atoi(batchTimeoutStr)
+		if err != nil || batchTimeout < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid batchTimeoutMinutes value"})
+			return DeployPolicy{}, false
+		}
+		policy.BatchTimeoutMinutes = batchTimeout
+	}
+
+	if timeoutAction := GetOptionalQueryParam(c, "timeoutAction"); timeoutAction != "" {
+		policy.TimeoutAction = strings.ToUpper(timeoutAction)
+	}
+	if !IsValidTimeoutAction(policy.TimeoutAction) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid timeoutAction value"})
+		return DeployPolicy{}, false
+	}
+
+	return policy, true
+}
+
+// IsValidTimeoutAction checks the timeout action against the supported actions
+func IsValidTimeoutAction(action string) bool {
+	return action == TimeoutActionAbort || action == TimeoutActionFail || action == TimeoutActionPause
+}
+
+// parseRetryableStates parses a comma separated list of failed range states
+func parseRetryableStates(value string) ([]string, bool) {
+	var states []string
+	for _, state := range strings.Split(value, ",") {
+		state = strings.ToUpper(strings.TrimSpace(state))
+		switch state {
+		case "":
+			continue
+		case "ERROR", "ABORTED":
+			states = append(states, state)
+		case "UNKNOWN":
+			// Ludus range responses without a state are reported as "unknown"
+			states = append(states, "unknown")
+		default:
+			return nil, false
+		}
+	}
+	return states, true
+}
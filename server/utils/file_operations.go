@@ -3,9 +3,13 @@ package utils
 import (
 	"dulus/server/config"
 	"encoding/base64"
+	"errors"
+	"io"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -76,141 +80,145 @@ func FileExists(path string) bool {
 	return err == nil
 }
 
-// GetSingleItemWithFile gets a single item by ID with its first file encoded as base64
-func GetSingleItemWithFile(c *gin.Context, baseFolder, itemId, itemType string) {
-	itemPath, ok := ValidateFolderId(c, baseFolder, itemId)
+// GetSingleTopology gets a single topology by ID with its file encoded as base64
+func GetSingleTopology(c *gin.Context, topologyId string) {
+	topology, ok := ReadTopologyWithResponse(c, topologyId)
 	if !ok {
 		return
 	}
 
-	fileInfo, err := ReadFirstFileInDir(itemPath)
-	if HandleFileReadError(c, err) {
-		return
-	}
-
-	encoded := base64.StdEncoding.EncodeToString([]byte(fileInfo.Content))
-
 	c.JSON(http.StatusOK, gin.H{
-		itemType + "Id":   itemId,
-		itemType + "Name": fileInfo.Name,
-		itemType + "File": encoded,
-		"createdAt":       fileInfo.CreationTime.Format(config.TimestampFormat),
+		"topologyId":   topologyId,
+		"topologyName": topology.Name,
+		"topologyFile": base64.StdEncoding.EncodeToString(topology.Content),
+		"createdAt":    topology.CreatedAt.Format(config.TimestampFormat),
 	})
 }
 
-// GetAllItemsWithFileNames gets all items in a folder with their file names
-func GetAllItemsWithFileNames(c *gin.Context, baseFolder, itemType string) {
-	items, err := os.ReadDir(baseFolder)
+// GetAllTopologies lists all topologies with their file names
+func GetAllTopologies(c *gin.Context) {
+	topologies, err := store.ListTopologies()
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
 		return
 	}
 
 	var itemList []gin.H
-	for _, item := range items {
-		if item.IsDir() {
-			itemPath := filepath.Join(baseFolder, item.Name())
-
-			// Try to read first file for name and creation time
-			fileInfo, err := ReadFirstFileInDir(itemPath)
-			var fileName string
-			var createdAt string
-
-			if err == nil {
-				fileName = fileInfo.Name
-				createdAt = fileInfo.CreationTime.Format(config.TimestampFormat)
-			} else {
-				// Fallback to directory modification time
-				dirInfo, dirErr := os.Stat(itemPath)
-				if dirErr == nil {
-					createdAt = dirInfo.ModTime().Format(config.TimestampFormat)
-				}
-			}
-
-			itemList = append(itemList, gin.H{
-				itemType + "Id":   item.Name(),
-				itemType + "Name": fileName,
-				"createdAt":       createdAt,
-			})
-		}
+	for _, topology := range topologies {
+		itemList = append(itemList, gin.H{
+			"topologyId":   topology.Id,
+			"topologyName": topology.Name,
+			"createdAt":    topology.CreatedAt.Format(config.TimestampFormat),
+		})
 	}
 
 	c.JSON(http.StatusOK, itemList)
 }
 
-// SaveUploadedFile handles a single uploaded file for an item folder.
-// If providedId is empty a new ID is generated. It validates the file extension,
-// optionally removes existing content when updating, saves the uploaded file and
-// returns the item ID on success.
-func SaveUploadedFile(c *gin.Context, baseFolder, providedId, expectedExt string) (string, bool) {
-	file, err := c.FormFile("file")
+// ReadTopologyWithResponse reads a topology and its file and handles HTTP responses
+func ReadTopologyWithResponse(c *gin.Context, topologyId string) (StoredFile, bool) {
+	if !validStoreId(c, topologyId) {
+		return StoredFile{}, false
+	}
+
+	topology, err := store.GetTopology(topologyId)
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		WriteStoreError(c, err)
+		return StoredFile{}, false
+	}
+	return topology, true
+}
+
+// SaveTopologyWithResponse stores a topology file and handles HTTP responses.
+// An empty topologyId creates a new topology.
+func SaveTopologyWithResponse(c *gin.Context, topologyId, fileName string, content []byte) (string, bool) {
+	if topologyId != "" && !validStoreId(c, topologyId) {
 		return "", false
 	}
 
-	if filepath.Ext(file.Filename) != expectedExt {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+	id, err := store.SaveTopology(topologyId, fileName, content)
+	if err != nil {
+		WriteStoreError(c, err)
 		return "", false
 	}
+	return id, true
+}
 
-	var itemId string
-	var itemPath string
+// DeleteTopologyWithResponse deletes a topology unless a pool uses it (409) and handles HTTP responses
+func DeleteTopologyWithResponse(c *gin.Context, topologyId string) bool {
+	if !validStoreId(c, topologyId) {
+		return false
+	}
+	if err := store.DeleteTopology(topologyId); err != nil {
+		WriteStoreError(c, err)
+		return false
+	}
+	return true
+}
 
-	if providedId != "" {
-		// Validate existing folder
-		validatedPath, ok := ValidateFolderId(c, baseFolder, providedId)
-		if !ok {
-			return "", false
-		}
-		itemId = providedId
-		itemPath = validatedPath
-	} else {
-		// Create new folder with generated id
-		newId, err := GenerateUniqueID(baseFolder)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-			return "", false
-		}
-		itemId = newId
-		itemPath = filepath.Join(baseFolder, itemId)
-		if err := os.MkdirAll(itemPath, os.ModePerm); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-			return "", false
-		}
+// CountTopologies returns the number of topologies
+func CountTopologies() int {
+	topologies, err := store.ListTopologies()
+	if err != nil {
+		return 0
 	}
+	return len(topologies)
+}
 
-	// If updating, clean the folder
-	if providedId != "" {
-		if err := os.RemoveAll(itemPath); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-			return "", false
-		}
-		if err := os.MkdirAll(itemPath, os.ModePerm); err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-			return "", false
-		}
+// ReadUploadedFile reads the single uploaded "file" form field and checks its extension
+// against expectedExts. It returns the file name and content on success.
+func ReadUploadedFile(c *gin.Context, expectedExts ...string) (string, []byte, bool) {
+	file, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return "", nil, false
 	}
 
-	// Save uploaded file
-	destPath := filepath.Join(itemPath, file.Filename)
-	if err := c.SaveUploadedFile(file, destPath); err != nil {
+	if !slices.Contains(expectedExts, filepath.Ext(file.Filename)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return "", nil, false
+	}
+
+	uploaded, err := file.Open()
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-		return "", false
+		return "", nil, false
 	}
+	defer uploaded.Close()
 
-	return itemId, true
+	content, err := io.ReadAll(uploaded)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		return "", nil, false
+	}
+
+	return file.Filename, content, true
 }
 
-// HandleFileReadError handles common file reading errors with HTTP responses
-func HandleFileReadError(c *gin.Context, err error) bool {
-	if err == os.ErrNotExist {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
-		return true
+// validStoreId rejects ids that are not alphanumeric with 400
+func validStoreId(c *gin.Context, id string) bool {
+	if !validFolderIDRegex.MatchString(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return false
 	}
-	if err != nil {
+	return true
+}
+
+// WriteStoreError answers a failed store call: 404 for unknown items, 400 for
+// pool data breaking the pool rules, 409 for a topology in use, 412 for an
+// outdated pool revision, 500 otherwise
+func WriteStoreError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Not Found"})
+	case errors.Is(err, ErrInvalidPool):
+		log.Printf("Rejected pool data: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+	case errors.Is(err, ErrTopologyInUse):
+		c.JSON(http.StatusConflict, gin.H{"error": "Conflict"})
+	case errors.Is(err, ErrRevisionMismatch):
+		c.JSON(http.StatusPreconditionFailed, gin.H{"error": "Precondition Failed"})
+	default:
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-		return true
 	}
-	return false
 }
@@ -0,0 +1,731 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// itemFolder keeps items as <base>/<id>/<file>, the layout of topologies and scenarios
+type itemFolder struct {
+	base string
+}
+
+func (f itemFolder) path(id string) (string, error) {
+	if !validFolderIDRegex.MatchString(id) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(f.base, id), nil
+}
+
+// save writes the file of an item, replacing the previous one. An empty id
+// creates a new item.
+func (f itemFolder) save(id, fileName string, content []byte) (string, error) {
+	if id == "" {
+		newId, err := GenerateUniqueID(f.base)
+		if err != nil {
+			return "", err
+		}
+		id = newId
+	} else if _, err := f.read(id); err != nil {
+		return "", err
+	}
+
+	itemPath := filepath.Join(f.base, id)
+	if err := os.RemoveAll(itemPath); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(itemPath, os.ModePerm); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(itemPath, filepath.Base(fileName)), content, os.ModePerm); err != nil {
+		os.RemoveAll(itemPath)
+		return "", err
+	}
+	return id, nil
+}
+
+func (f itemFolder) read(id string) (StoredFile, error) {
+	itemPath, err := f.path(id)
+	if err != nil {
+		return StoredFile{}, err
+	}
+
+	fileInfo, err := ReadFirstFileInDir(itemPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return StoredFile{}, ErrNotFound
+		}
+		return StoredFile{}, err
+	}
+
+	return StoredFile{
+		StoredItem: StoredItem{Id: id, Name: fileInfo.Name, CreatedAt: fileInfo.CreationTime},
+		Content:    []byte(fileInfo.Content),
+	}, nil
+}
+
+func (f itemFolder) list() ([]StoredItem, error) {
+	dirs, err := os.ReadDir(f.base)
+	if err != nil {
+		return nil, err
+	}
+
+	var items []StoredItem
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		item, err := statItemFolder(filepath.Join(f.base, dir.Name()))
+		if err != nil {
+			// Fallback to directory modification time
+			if info, infoErr := dir.Info(); infoErr == nil {
+				item.CreatedAt = info.ModTime()
+			}
+		}
+		item.Id = dir.Name()
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (f itemFolder) remove(id string) error {
+	itemPath, err := f.path(id)
+	if err != nil {
+		return err
+	}
+	if !FileExists(itemPath) {
+		return ErrNotFound
+	}
+	return os.RemoveAll(itemPath)
+}
+
+// statItemFolder returns the name and modification time of the first file in an item folder
+func statItemFolder(itemPath string) (StoredItem, error) {
+	files, err := os.ReadDir(itemPath)
+	if err != nil {
+		return StoredItem{}, err
+	}
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		info, err := file.Info()
+		if err != nil {
+			return StoredItem{}, err
+		}
+		return StoredItem{Name: file.Name(), CreatedAt: info.ModTime()}, nil
+	}
+	return StoredItem{}, os.ErrNotExist
+}
+
+// ctfdDataFolder keeps the CTFd data of a pool in pools/<id>/ctfd_data.json
+type ctfdDataFolder struct {
+	poolFolder string
+}
+
+func (f ctfdDataFolder) ctfdDataPath(poolId string) string {
+	return filepath.Join(f.poolFolder, poolId, "ctfd_data.json")
+}
+
+func (f ctfdDataFolder) GetCtfdData(poolId string) (CtfdData, error) {
+	if !validFolderIDRegex.MatchString(poolId) {
+		return CtfdData{}, ErrNotFound
+	}
+
+	content, err := os.ReadFile(f.ctfdDataPath(poolId))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return CtfdData{}, ErrNotFound
+		}
+		return CtfdData{}, err
+	}
+
+	var data CtfdData
+	if err := json.Unmarshal(content, &data); err != nil {
+		return CtfdData{}, err
+	}
+	return data, nil
+}
+
+func (f ctfdDataFolder) SaveCtfdData(poolId string, data CtfdData) error {
+	if !validFolderIDRegex.MatchString(poolId) {
+		return ErrNotFound
+	}
+
+	if err := os.MkdirAll(filepath.Join(f.poolFolder, poolId), os.ModePerm); err != nil {
+		return err
+	}
+
+	content, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(f.ctfdDataPath(poolId), content)
+}
+
+func (f ctfdDataFolder) DeleteCtfdData(poolId string) error {
+	if !validFolderIDRegex.MatchString(poolId) {
+		return nil
+	}
+
+	err := os.Remove(f.ctfdDataPath(poolId))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (f ctfdDataFolder) HasCtfdData(poolId string) bool {
+	return validFolderIDRegex.MatchString(poolId) && FileExists(f.ctfdDataPath(poolId))
+}
+
+// writeFileAtomic replaces path through a temporary file, so readers never see a partial file
+func writeFileAtomic(path string, content []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, content, os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// FileStore keeps everything in the data folders: pools/<id>/pool.json with the
+// pool's CTFd data next to it, topologies/<id>/<file>, ctfd_scenarios/<id>/<file>
+// and pool_templates/<id>/template.json, and the role assignments in roles.json.
+// Cross-pool checks read every pool.json. Writes are serialized in this process.
+type FileStore struct {
+	ctfdDataFolder
+	poolFolder string
+	topologies itemFolder
+	scenarios  itemFolder
+	templates  itemFolder
+	roleFile   string
+
+	// writeMutex serializes writes only, so an update callback may still read the store
+	writeMutex sync.Mutex
+}
+
+// NewFileStore creates a store on the given data folders and role file
+func NewFileStore(poolFolder, topologyFolder, scenarioFolder, templateFolder, roleFile string) *FileStore {
+	return &FileStore{
+		ctfdDataFolder: ctfdDataFolder{poolFolder: poolFolder},
+		poolFolder:     poolFolder,
+		topologies:     itemFolder{base: topologyFolder},
+		scenarios:      itemFolder{base: scenarioFolder},
+		templates:      itemFolder{base: templateFolder},
+		roleFile:       roleFile,
+	}
+}
+
+func (s *FileStore) Close() error {
+	return nil
+}
+
+func (s *FileStore) poolJsonPath(poolId string) string {
+	return filepath.Join(s.poolFolder, poolId, "pool.json")
+}
+
+func (s *FileStore) readPoolFile(poolId string) (Pool, error) {
+	if !validFolderIDRegex.MatchString(poolId) {
+		return Pool{}, ErrPoolNotFound
+	}
+
+	poolJsonPath := s.poolJsonPath(poolId)
+	content, err := os.ReadFile(poolJsonPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Pool{}, ErrPoolNotFound
+		}
+		return Pool{}, err
+	}
+
+	var pool Pool
+	if err := json.Unmarshal(content, &pool); err != nil {
+		return Pool{}, err
+	}
+	pool.Id = poolId
+	// pool.json written before revisions were introduced
+	if pool.Revision == 0 {
+		pool.Revision = 1
+	}
+
+	// Get creation time from pool.json file
+	if fileInfo, err := os.Stat(poolJsonPath); err == nil {
+		pool.CreatedAt = fileInfo.ModTime()
+	}
+	return pool, nil
+}
+
+func (s *FileStore) writePoolFile(pool Pool) error {
+	content, err := json.Marshal(pool)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.poolJsonPath(pool.Id), content)
+}
+
+// readAllPools reads every pool, skipping folders without a readable pool.json
+func (s *FileStore) readAllPools() ([]Pool, error) {
+	poolDirs, err := os.ReadDir(s.poolFolder)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pool folder: %w", err)
+	}
+
+	var pools []Pool
+	for _, dir := range poolDirs {
+		if !dir.IsDir() {
+			continue
+		}
+		pool, err := s.readPoolFile(dir.Name())
+		if err != nil {
+			continue // Skip pools we can't read
+		}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+// checkRules checks pool against all other stored pools
+func (s *FileStore) checkRules(pool Pool) error {
+	pools, err := s.readAllPools()
+	if err != nil {
+		return err
+	}
+	return checkPoolRules(pool, pools)
+}
+
+func (s *FileStore) CreatePool(pool Pool) (string, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	poolId, err := GenerateUniqueID(s.poolFolder)
+	if err != nil {
+		return "", err
+	}
+	pool.Id = poolId
+	pool.Revision = 1
+
+	if err := s.checkRules(pool); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Join(s.poolFolder, poolId), os.ModePerm); err != nil {
+		return "", err
+	}
+	if err := s.writePoolFile(pool); err != nil {
+		return "", err
+	}
+	return poolId, nil
+}
+
+func (s *FileStore) GetPool(poolId string) (Pool, error) {
+	return s.readPoolFile(poolId)
+}
+
+func (s *FileStore) UpdatePool(poolId string, update func(pool *Pool) error) (Pool, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	pool, err := s.readPoolFile(poolId)
+	if err != nil {
+		return Pool{}, err
+	}
+	revision := pool.Revision
+	if err := update(&pool); err != nil {
+		return Pool{}, err
+	}
+	pool.Id = poolId
+	pool.Revision = revision + 1
+
+	if err := s.checkRules(pool); err != nil {
+		return Pool{}, err
+	}
+	if err := s.writePoolFile(pool); err != nil {
+		return Pool{}, err
+	}
+	return pool, nil
+}
+
+func (s *FileStore) DeletePool(poolId string) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	if _, err := s.readPoolFile(poolId); err != nil {
+		return err
+	}
+
+	// Keep the folder while it holds history, so the id is not given out again
+	if !FileExists(s.historyPath(poolId)) {
+		return os.RemoveAll(filepath.Join(s.poolFolder, poolId))
+	}
+	if err := s.DeleteCtfdData(poolId); err != nil {
+		return err
+	}
+	return os.Remove(s.poolJsonPath(poolId))
+}
+
+func (s *FileStore) historyPath(poolId string) string {
+	return filepath.Join(s.poolFolder, poolId, "history.jsonl")
+}
+
+// AddPoolHistory appends the entry as one JSON line to pools/<id>/history.jsonl
+func (s *FileStore) AddPoolHistory(entry PoolHistoryEntry) error {
+	if !validFolderIDRegex.MatchString(entry.PoolId) {
+		return ErrPoolNotFound
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	if err := os.MkdirAll(filepath.Join(s.poolFolder, entry.PoolId), os.ModePerm); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(s.historyPath(entry.PoolId), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(line, '\n'))
+	return err
+}
+
+// GetPoolHistory reads the history of a pool, oldest first
+func (s *FileStore) GetPoolHistory(poolId string) ([]PoolHistoryEntry, error) {
+	if !validFolderIDRegex.MatchString(poolId) {
+		return nil, nil
+	}
+	content, err := os.ReadFile(s.historyPath(poolId))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var history []PoolHistoryEntry
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var entry PoolHistoryEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, err
+		}
+		if entry.Pool != nil {
+			entry.Pool.Id = poolId
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
+
+func (s *FileStore) ListPools() ([]Pool, error) {
+	return s.readAllPools()
+}
+
+func (s *FileStore) MainUserIds() (map[string]bool, error) {
+	pools, err := s.readAllPools()
+	if err != nil {
+		return nil, err
+	}
+	return poolMainUserIds(pools), nil
+}
+
+func (s *FileStore) PoolUserIds() (map[string]bool, error) {
+	pools, err := s.readAllPools()
+	if err != nil {
+		return nil, err
+	}
+	return poolAllUserIds(pools), nil
+}
+
+func (s *FileStore) AnyUserInPools(userIds []string) (bool, error) {
+	allUserIds, err := s.PoolUserIds()
+	if err != nil {
+		return false, err
+	}
+	return anyIdIn(userIds, allUserIds), nil
+}
+
+func (s *FileStore) SaveTopology(topologyId, fileName string, content []byte) (string, error) {
+	return s.topologies.save(topologyId, fileName, content)
+}
+
+func (s *FileStore) GetTopology(topologyId string) (StoredFile, error) {
+	return s.topologies.read(topologyId)
+}
+
+func (s *FileStore) ListTopologies() ([]StoredItem, error) {
+	return s.topologies.list()
+}
+
+func (s *FileStore) DeleteTopology(topologyId string) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	if _, err := s.topologies.read(topologyId); err != nil {
+		return err
+	}
+
+	pools, err := s.readAllPools()
+	if err != nil {
+		return err
+	}
+	for _, pool := range pools {
+		if pool.TopologyId == topologyId {
+			return ErrTopologyInUse
+		}
+	}
+
+	templates, err := s.ListTemplates()
+	if err != nil {
+		return err
+	}
+	for _, template := range templates {
+		if template.TopologyId == topologyId {
+			return ErrTopologyInUse
+		}
+	}
+
+	return s.topologies.remove(topologyId)
+}
+
+func (s *FileStore) SaveScenario(scenarioId, fileName, scenarioMode string, content []byte) (string, error) {
+	return s.scenarios.save(scenarioId, fileName, content)
+}
+
+// GetScenario reads the scenario and its user mode from the archive
+func (s *FileStore) GetScenario(scenarioId string) (StoredFile, error) {
+	scenario, err := s.scenarios.read(scenarioId)
+	if err != nil {
+		return StoredFile{}, err
+	}
+	scenario.Mode, _ = GetScenarioModeFromZip(scenario.Content)
+	return scenario, nil
+}
+
+// ListScenarios lists the scenarios with the user mode read from every archive
+func (s *FileStore) ListScenarios() ([]StoredItem, error) {
+	items, err := s.scenarios.list()
+	if err != nil {
+		return nil, err
+	}
+	for i := range items {
+		if scenario, err := s.GetScenario(items[i].Id); err == nil {
+			items[i].Mode = scenario.Mode
+		}
+	}
+	return items, nil
+}
+
+func (s *FileStore) DeleteScenario(scenarioId string) error {
+	return s.scenarios.remove(scenarioId)
+}
+
+// poolMainUserIds returns the main users of pools
+func poolMainUserIds(pools []Pool) map[string]bool {
+	mainUsers := make(map[string]bool)
+	for _, pool := range pools {
+		for _, userTeam := range pool.UsersAndTeams {
+			if userTeam.MainUserId != "" {
+				mainUsers[userTeam.MainUserId] = true
+			}
+		}
+	}
+	return mainUsers
+}
+
+// poolAllUserIds returns the userIds and main users of pools
+func poolAllUserIds(pools []Pool) map[string]bool {
+	userIds := poolMainUserIds(pools)
+	for _, pool := range pools {
+		for _, userTeam := range pool.UsersAndTeams {
+			if userTeam.UserId != "" {
+				userIds[userTeam.UserId] = true
+			}
+		}
+	}
+	return userIds
+}
+
+func anyIdIn(ids []string, set map[string]bool) bool {
+	for _, id := range ids {
+		if set[id] {
+			return true
+		}
+	}
+	return false
+}
+
+// checkPoolRules enforces for stores without constraints what the SQLite
+// schema enforces: userIds are unique within a pool, a main user belongs to
+// only one pool and no userId of any pool is a main user
+func checkPoolRules(pool Pool, pools []Pool) error {
+	var others []Pool
+	for _, other := range pools {
+		if other.Id != pool.Id {
+			others = append(others, other)
+		}
+	}
+	otherMainUsers := poolMainUserIds(others)
+	otherUserIds := make(map[string]bool)
+	for _, other := range others {
+		for _, userTeam := range other.UsersAndTeams {
+			otherUserIds[userTeam.UserId] = true
+		}
+	}
+
+	mainUsers := poolMainUserIds([]Pool{pool})
+	for mainUserId := range mainUsers {
+		if otherMainUsers[mainUserId] {
+			return fmt.Errorf("%w: main user '%s' already belongs to another pool", ErrInvalidPool, mainUserId)
+		}
+		if otherUserIds[mainUserId] {
+			return fmt.Errorf("%w: main user '%s' is already a member of a pool", ErrInvalidPool, mainUserId)
+		}
+	}
+
+	userIds := make(map[string]bool)
+	for _, userTeam := range pool.UsersAndTeams {
+		if userIds[userTeam.UserId] {
+			return fmt.Errorf("%w: duplicate userId '%s'", ErrInvalidPool, userTeam.UserId)
+		}
+		userIds[userTeam.UserId] = true
+		if mainUsers[userTeam.UserId] || otherMainUsers[userTeam.UserId] {
+			return fmt.Errorf("%w: userId '%s' is already a main user of a pool", ErrInvalidPool, userTeam.UserId)
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) CreateTemplate(template PoolTemplate) (string, error) {
+	if template.CreatedAt.IsZero() {
+		template.CreatedAt = time.Now()
+	}
+	content, err := json.MarshalIndent(template, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	return s.templates.save("", "template.json", content)
+}
+
+func (s *FileStore) GetTemplate(templateId string) (PoolTemplate, error) {
+	file, err := s.templates.read(templateId)
+	if err != nil {
+		return PoolTemplate{}, err
+	}
+
+	var template PoolTemplate
+	if err := json.Unmarshal(file.Content, &template); err != nil {
+		return PoolTemplate{}, err
+	}
+	template.Id = templateId
+	return template, nil
+}
+
+func (s *FileStore) ListTemplates() ([]PoolTemplate, error) {
+	items, err := s.templates.list()
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []PoolTemplate
+	for _, item := range items {
+		template, err := s.GetTemplate(item.Id)
+		if err != nil {
+			continue
+		}
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+func (s *FileStore) DeleteTemplate(templateId string) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	return s.templates.remove(templateId)
+}
+
+// readRoles returns the role assignments in the role file, none if there is no file yet
+func (s *FileStore) readRoles() ([]RoleAssignment, error) {
+	content, err := os.ReadFile(s.roleFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var assignments []RoleAssignment
+	if err := json.Unmarshal(content, &assignments); err != nil {
+		return nil, err
+	}
+	return assignments, nil
+}
+
+func (s *FileStore) writeRoles(assignments []RoleAssignment) error {
+	sort.Slice(assignments, func(i, j int) bool { return assignments[i].UserId < assignments[j].UserId })
+	content, err := json.MarshalIndent(assignments, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.roleFile, content)
+}
+
+func (s *FileStore) SetRole(assignment RoleAssignment) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	assignments, err := s.readRoles()
+	if err != nil {
+		return err
+	}
+	assignments = slices.DeleteFunc(assignments, func(existing RoleAssignment) bool { return existing.UserId == assignment.UserId })
+	return s.writeRoles(append(assignments, assignment))
+}
+
+func (s *FileStore) GetRole(userId string) (RoleAssignment, error) {
+	assignments, err := s.readRoles()
+	if err != nil {
+		return RoleAssignment{}, err
+	}
+	for _, assignment := range assignments {
+		if assignment.UserId == userId {
+			return assignment, nil
+		}
+	}
+	return RoleAssignment{}, ErrNotFound
+}
+
+func (s *FileStore) ListRoles() ([]RoleAssignment, error) {
+	return s.readRoles()
+}
+
+func (s *FileStore) DeleteRole(userId string) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	assignments, err := s.readRoles()
+	if err != nil {
+		return err
+	}
+	remaining := slices.DeleteFunc(slices.Clone(assignments), func(existing RoleAssignment) bool { return existing.UserId == userId })
+	if len(remaining) == len(assignments) {
+		return ErrNotFound
+	}
+	return s.writeRoles(remaining)
+}
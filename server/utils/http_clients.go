@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"dulus/server/config"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Idle connections kept per upstream host, enough for a full fan-out of concurrent requests
+const maxIdleConnsPerHost = 64
+
+// Shared clients, one per upstream, so connections are reused across requests
+var (
+	httpClientsOnce      sync.Once
+	httpClientsErr       error
+	ludusHTTPClient      *http.Client
+	ludusAdminHTTPClient *http.Client
+	proxmoxHTTPClient    *http.Client
+	pocketbaseHTTPClient *http.Client
+)
+
+// InitHTTPClients builds the Ludus user API, Ludus admin API, Pocketbase and Proxmox
+// clients. It fails when a configured CA bundle cannot be read.
+func InitHTTPClients() error {
+	httpClientsOnce.Do(func() {
+		ludusTLS, err := newTLSConfig(config.LudusCACert, config.LudusPinnedCertSHA256)
+		if err != nil {
+			httpClientsErr = fmt.Errorf("ludus TLS: %w", err)
+			return
+		}
+		proxmoxTLS, err := newTLSConfig(config.ProxmoxCACert, config.ProxmoxPinnedCertSHA256)
+		if err != nil {
+			httpClientsErr = fmt.Errorf("proxmox TLS: %w", err)
+			return
+		}
+
+		if ludusTLS.InsecureSkipVerify && len(config.LudusPinnedCertSHA256) == 0 {
+			log.Println("Warning: Ludus certificates are not verified, set LUDUS_CA_CERT or LUDUS_PINNED_CERT_SHA256")
+		}
+		if proxmoxTLS.InsecureSkipVerify && len(config.ProxmoxPinnedCertSHA256) == 0 {
+			log.Println("Warning: Proxmox certificates are not verified, set PROXMOX_CA_CERT or PROXMOX_PINNED_CERT_SHA256")
+		}
+
+		ludusHTTPClient = newUpstreamClient("Ludus", ludusTLS)
+		ludusAdminHTTPClient = newUpstreamClient("Ludus", ludusTLS.Clone())
+		proxmoxHTTPClient = newUpstreamClient("Proxmox", proxmoxTLS)
+		// Pocketbase is part of Ludus 2.x, its certificate is trusted like Ludus's
+		pocketbaseHTTPClient = newUpstreamClient("Pocketbase", ludusTLS.Clone())
+	})
+	return httpClientsErr
+}
+
+// LudusHTTPClient returns the shared client for the Ludus user API
+func LudusHTTPClient() *http.Client {
+	InitHTTPClients()
+	return ludusHTTPClient
+}
+
+// LudusAdminHTTPClient returns the shared client for the Ludus admin API
+func LudusAdminHTTPClient() *http.Client {
+	InitHTTPClients()
+	return ludusAdminHTTPClient
+}
+
+// PocketbaseHTTPClient returns the shared client for the Pocketbase API of Ludus 2.x
+func PocketbaseHTTPClient() *http.Client {
+	InitHTTPClients()
+	return pocketbaseHTTPClient
+}
+
+// ProxmoxHTTPClient returns the shared client for the Proxmox API
+func ProxmoxHTTPClient() *http.Client {
+	InitHTTPClients()
+	return proxmoxHTTPClient
+}
+
+// newUpstreamClient creates a pooled client whose idempotent requests are
+// retried and which is guarded by the circuit breaker of upstream
+func newUpstreamClient(upstream string, tlsConfig *tls.Config) *http.Client {
+	transport := &http.Transport{
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+		MaxIdleConns:        maxIdleConnsPerHost * 2,
+		MaxIdleConnsPerHost: maxIdleConnsPerHost,
+		IdleConnTimeout:     90 * time.Second,
+	}
+	return &http.Client{Transport: newRetryTransport(transport, upstream)}
+}
+
+// newTLSConfig trusts the certificates in caFile and/or only accepts server
+// certificates whose SHA-256 fingerprint is in pins. With neither set the
+// certificate is not checked at all, like before these options existed.
+func newTLSConfig(caFile string, pins []string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if caFile != "" {
+		pem, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = pool
+	} else {
+		// Without a CA the chain cannot be verified, a pin replaces that check
+		tlsConfig.InsecureSkipVerify = true
+	}
+
+	if len(pins) > 0 {
+		allowed := make(map[string]bool, len(pins))
+		for _, pin := range pins {
+			allowed[normalizeFingerprint(pin)] = true
+		}
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return fmt.Errorf("server sent no certificate")
+			}
+			sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+			if !allowed[hex.EncodeToString(sum[:])] {
+				return fmt.Errorf("server certificate %x does not match the pinned fingerprint", sum)
+			}
+			return nil
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// normalizeFingerprint accepts "AB:CD:..." as printed by openssl as well as plain hex
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fingerprint), ":", ""))
+}
@@ -0,0 +1,545 @@
+package utils
+
+import (
+	"context"
+	"dulus/server/config"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Job types
+const (
+	JobTypeDeploy     = "DEPLOY"
+	JobTypeRedeploy   = "REDEPLOY"
+	JobTypeDestroy    = "DESTROY"
+	JobTypeDeletePool = "DELETE_POOL" // cascading pool delete, see StartPoolDeleteJob
+)
+
+// Job and batch states
+const (
+	JobStatusQueued    = "QUEUED"
+	JobStatusRunning   = "RUNNING"
+	JobStatusPaused    = "PAUSED"
+	JobStatusCompleted = "COMPLETED"
+	JobStatusFailed    = "FAILED"
+	JobStatusAborted   = "ABORTED"
+)
+
+// Per-user outcome states
+const (
+	UserStatusPending  = "PENDING"
+	UserStatusSent     = "SENT"
+	UserStatusRetrying = "RETRYING"
+	UserStatusSuccess  = "SUCCESS"
+	UserStatusFailed   = "FAILED"
+	UserStatusSkipped  = "SKIPPED"
+)
+
+type JobUserResult struct {
+	UserId     string     `json:"userId"`
+	Batch      int        `json:"batch"`
+	Status     string     `json:"status"`
+	RangeState string     `json:"rangeState,omitempty"`
+	Attempts   int        `json:"attempts"`
+	Error      string     `json:"error,omitempty"`
+	SentAt     *time.Time `json:"sentAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+type JobBatch struct {
+	Index      int        `json:"index"`
+	UserIds    []string   `json:"userIds"`
+	Status     string     `json:"status"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+type Job struct {
+	JobId              string          `json:"jobId"`
+	PoolId             string          `json:"poolId"`
+	Type               string          `json:"type"`
+	Status             string          `json:"status"`
+	CreatedBy          string          `json:"createdBy"`
+	ConcurrentRequests int             `json:"concurrentRequests"`
+	Policy             DeployPolicy    `json:"policy"`
+	CurrentBatch       int             `json:"currentBatch"`
+	Batches            []JobBatch      `json:"batches"`
+	Results            []JobUserResult `json:"results"`
+	Error              string          `json:"error,omitempty"`
+	CreatedAt          time.Time       `json:"createdAt"`
+	StartedAt          *time.Time      `json:"startedAt,omitempty"`
+	ResumedAt          *time.Time      `json:"resumedAt,omitempty"`
+	FinishedAt         *time.Time      `json:"finishedAt,omitempty"`
+}
+
+// Global job state, mirrored to disk on every change
+var (
+	jobs     = make(map[string]*Job)
+	jobMutex sync.RWMutex
+)
+
+// jobRunner is the worker currently executing a job. Cancelling its context
+// stops the worker's Ludus calls and wait loops, done is closed when it returns.
+type jobRunner struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Workers of running jobs by job id
+var (
+	jobRunners     = make(map[string]*jobRunner)
+	jobRunnerMutex sync.Mutex
+)
+
+// How long an abort waits for the worker to finish its in-flight Ludus call
+const jobStopTimeout = 30 * time.Second
+
+// ErrPoolJobActive is returned when a pool already has an unfinished job
+var ErrPoolJobActive = fmt.Errorf("pool already has an active job")
+
+// IsFinished reports whether the job reached a terminal state
+func (j *Job) IsFinished() bool {
+	return j.Status == JobStatusCompleted || j.Status == JobStatusFailed || j.Status == JobStatusAborted
+}
+
+// Result returns the per-user result entry for userId, or nil if the user is not part of the job
+func (j *Job) Result(userId string) *JobUserResult {
+	for i := range j.Results {
+		if j.Results[i].UserId == userId {
+			return &j.Results[i]
+		}
+	}
+	return nil
+}
+
+// Summary counts per-user results by status
+func (j *Job) Summary() map[string]int {
+	summary := make(map[string]int)
+	for _, result := range j.Results {
+		summary[result.Status]++
+	}
+	return summary
+}
+
+// copyJob returns a deep copy so callers never share slices with the manager
+func copyJob(job *Job) Job {
+	data, _ := json.Marshal(job)
+	var copied Job
+	json.Unmarshal(data, &copied)
+	return copied
+}
+
+// writeJob persists a job to <JobFolder>/<jobId>/job.json
+func writeJob(job *Job) error {
+	jobPath := filepath.Join(config.JobFolder, job.JobId)
+	if err := os.MkdirAll(jobPath, os.ModePerm); err != nil {
+		return err
+	}
+
+	file, err := os.Create(filepath.Join(jobPath, "job.json"))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(job)
+}
+
+// LoadJobs reads persisted jobs from disk. Unfinished destroy jobs are marked as
+// failed, unfinished deploy and redeploy jobs are kept for ResumeInterruptedJobs.
+func LoadJobs() error {
+	jobDirs, err := os.ReadDir(config.JobFolder)
+	if err != nil {
+		return fmt.Errorf("failed to read job folder: %w", err)
+	}
+
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+
+	for _, dir := range jobDirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(config.JobFolder, dir.Name(), "job.json"))
+		if err != nil {
+			continue
+		}
+
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil {
+			continue
+		}
+
+		if !job.IsFinished() && (job.Type == JobTypeDestroy || job.Type == JobTypeDeletePool) {
+			now := time.Now()
+			job.Status = JobStatusFailed
+			job.Error = "interrupted by service restart"
+			job.FinishedAt = &now
+			writeJob(&job)
+		}
+
+		jobs[job.JobId] = &job
+	}
+
+	return nil
+}
+
+// CreateJob registers a new queued job for a pool and splits userIds into batches
+func CreateJob(poolId, jobType, createdBy string, userIds []string, batchSize int, policy DeployPolicy) (Job, error) {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+
+	for _, existing := range jobs {
+		if existing.PoolId == poolId && !existing.IsFinished() {
+			return Job{}, ErrPoolJobActive
+		}
+	}
+
+	jobId, err := GenerateUniqueID(config.JobFolder)
+	if err != nil {
+		return Job{}, err
+	}
+
+	if batchSize <= 0 {
+		batchSize = len(userIds)
+	}
+
+	job := &Job{
+		JobId:              jobId,
+		PoolId:             poolId,
+		Type:               jobType,
+		Status:             JobStatusQueued,
+		CreatedBy:          createdBy,
+		ConcurrentRequests: batchSize,
+		Policy:             policy,
+		Batches:            []JobBatch{},
+		Results:            []JobUserResult{},
+		CreatedAt:          time.Now(),
+	}
+
+	for i := 0; i < len(userIds); i += batchSize {
+		end := i + batchSize
+		if end > len(userIds) {
+			end = len(userIds)
+		}
+
+		batchIndex := len(job.Batches)
+		job.Batches = append(job.Batches, JobBatch{
+			Index:   batchIndex,
+			UserIds: append([]string{}, userIds[i:end]...),
+			Status:  JobStatusQueued,
+		})
+		for _, userId := range userIds[i:end] {
+			job.Results = append(job.Results, JobUserResult{
+				UserId: userId,
+				Batch:  batchIndex,
+				Status: UserStatusPending,
+			})
+		}
+	}
+
+	if err := writeJob(job); err != nil {
+		return Job{}, err
+	}
+
+	jobs[jobId] = job
+	return copyJob(job), nil
+}
+
+// UpdateJob applies update to the job under lock and persists the result
+func UpdateJob(jobId string, update func(job *Job)) error {
+	jobMutex.Lock()
+	defer jobMutex.Unlock()
+
+	job, exists := jobs[jobId]
+	if !exists {
+		return os.ErrNotExist
+	}
+
+	update(job)
+	return writeJob(job)
+}
+
+// GetJob returns a copy of the job with the given ID
+func GetJob(jobId string) (Job, bool) {
+	jobMutex.RLock()
+	defer jobMutex.RUnlock()
+
+	job, exists := jobs[jobId]
+	if !exists {
+		return Job{}, false
+	}
+	return copyJob(job), true
+}
+
+// ListJobs returns copies of all jobs, newest first. Empty filters match everything.
+func ListJobs(poolId, status string) []Job {
+	jobMutex.RLock()
+	defer jobMutex.RUnlock()
+
+	list := make([]Job, 0, len(jobs))
+	for _, job := range jobs {
+		if poolId != "" && job.PoolId != poolId {
+			continue
+		}
+		if status != "" && job.Status != status {
+			continue
+		}
+		list = append(list, copyJob(job))
+	}
+
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].CreatedAt.After(list[j].CreatedAt)
+	})
+	return list
+}
+
+// GetActivePoolJob returns the unfinished job of a pool, if any
+func GetActivePoolJob(poolId string) (Job, bool) {
+	jobMutex.RLock()
+	defer jobMutex.RUnlock()
+
+	for _, job := range jobs {
+		if job.PoolId == poolId && !job.IsFinished() {
+			return copyJob(job), true
+		}
+	}
+	return Job{}, false
+}
+
+// IsPoolDeploying checks if a pool currently has an unfinished job
+func IsPoolDeploying(poolId string) bool {
+	_, active := GetActivePoolJob(poolId)
+	return active
+}
+
+// GetJobPolicy returns the deploy policy of a job
+func GetJobPolicy(jobId string) DeployPolicy {
+	jobMutex.RLock()
+	defer jobMutex.RUnlock()
+
+	if job, exists := jobs[jobId]; exists {
+		return job.Policy
+	}
+	return DeployPolicy{}
+}
+
+// IsJobActive checks whether the job is still queued or running. Paused jobs
+// are unfinished but not active, so their workers stop.
+func IsJobActive(jobId string) bool {
+	jobMutex.RLock()
+	defer jobMutex.RUnlock()
+
+	job, exists := jobs[jobId]
+	return exists && (job.Status == JobStatusQueued || job.Status == JobStatusRunning)
+}
+
+// startJobRunner registers the worker of a job and returns the context its
+// Ludus calls run under and the function it must call when it returns
+func startJobRunner(jobId string) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	runner := &jobRunner{cancel: cancel, done: make(chan struct{})}
+
+	jobRunnerMutex.Lock()
+	jobRunners[jobId] = runner
+	jobRunnerMutex.Unlock()
+
+	return ctx, func() {
+		cancel()
+		close(runner.done)
+
+		jobRunnerMutex.Lock()
+		if jobRunners[jobId] == runner {
+			delete(jobRunners, jobId)
+		}
+		jobRunnerMutex.Unlock()
+	}
+}
+
+// cancelJobRunner cancels the context of a job's worker and returns a channel
+// that is closed once the worker has returned
+func cancelJobRunner(jobId string) <-chan struct{} {
+	jobRunnerMutex.Lock()
+	runner, exists := jobRunners[jobId]
+	jobRunnerMutex.Unlock()
+
+	if !exists {
+		done := make(chan struct{})
+		close(done)
+		return done
+	}
+	runner.cancel()
+	return runner.done
+}
+
+// PauseJob moves a running job to paused, recording the reason, and interrupts
+// its worker. Users already sent a deploy stay SENT and are awaited on resume.
+func PauseJob(jobId, reason string) {
+	UpdateJob(jobId, func(job *Job) {
+		if job.Status != JobStatusRunning {
+			return
+		}
+		job.Status = JobStatusPaused
+		job.Error = reason
+	})
+	cancelJobRunner(jobId)
+}
+
+// AbortPoolJob marks the active job of a pool as aborted and stops its worker:
+// no further deploy request is sent and waits are interrupted. It waits for a
+// deploy request that is already in flight, so the returned job records exactly
+// which users were sent one.
+func AbortPoolJob(poolId string) (Job, bool) {
+	active, exists := GetActivePoolJob(poolId)
+	if !exists {
+		return Job{}, false
+	}
+
+	UpdateJob(active.JobId, func(job *Job) {
+		if job.IsFinished() {
+			return
+		}
+		now := time.Now()
+		job.Status = JobStatusAborted
+		job.FinishedAt = &now
+		for i := range job.Results {
+			if job.Results[i].Status == UserStatusPending {
+				job.Results[i].Status = UserStatusSkipped
+			}
+		}
+	})
+
+	select {
+	case <-cancelJobRunner(active.JobId):
+	case <-time.After(jobStopTimeout):
+	}
+
+	aborted, _ := GetJob(active.JobId)
+	return aborted, true
+}
+
+// SentUserIds returns the users of a job that were sent a deploy request
+func (j *Job) SentUserIds() []string {
+	var sent []string
+	for _, result := range j.Results {
+		if result.SentAt != nil {
+			sent = append(sent, result.UserId)
+		}
+	}
+	return sent
+}
+
+// MarkJobStarted moves a queued or paused job to running
+func MarkJobStarted(jobId string) {
+	UpdateJob(jobId, func(job *Job) {
+		if job.IsFinished() {
+			return
+		}
+		now := time.Now()
+		job.Status = JobStatusRunning
+		job.Error = ""
+		if job.StartedAt == nil {
+			job.StartedAt = &now
+		}
+	})
+}
+
+// MarkJobResumed records that an interrupted job was picked up again after a restart
+func MarkJobResumed(jobId string) {
+	UpdateJob(jobId, func(job *Job) {
+		now := time.Now()
+		job.ResumedAt = &now
+	})
+}
+
+// GetInterruptedJobs returns queued and running jobs, used on startup before any new
+// job is created. Paused jobs keep waiting for an explicit resume.
+func GetInterruptedJobs() []Job {
+	jobMutex.RLock()
+	defer jobMutex.RUnlock()
+
+	var interrupted []Job
+	for _, job := range jobs {
+		if job.Status == JobStatusQueued || job.Status == JobStatusRunning {
+			interrupted = append(interrupted, copyJob(job))
+		}
+	}
+
+	sort.Slice(interrupted, func(i, j int) bool {
+		return interrupted[i].CreatedAt.Before(interrupted[j].CreatedAt)
+	})
+	return interrupted
+}
+
+// MarkJobFinished moves a running job to a terminal state, unless it was already aborted
+func MarkJobFinished(jobId, status, errorMessage string) {
+	UpdateJob(jobId, func(job *Job) {
+		if job.IsFinished() {
+			return
+		}
+		now := time.Now()
+		job.Status = status
+		job.Error = errorMessage
+		job.FinishedAt = &now
+	})
+}
+
+// MarkBatchStarted marks a batch as running and makes it the current batch
+func MarkBatchStarted(jobId string, batchIndex int) {
+	UpdateJob(jobId, func(job *Job) {
+		if batchIndex >= len(job.Batches) {
+			return
+		}
+		now := time.Now()
+		job.CurrentBatch = batchIndex
+		job.Batches[batchIndex].Status = JobStatusRunning
+		if job.Batches[batchIndex].StartedAt == nil {
+			job.Batches[batchIndex].StartedAt = &now
+		}
+	})
+}
+
+// MarkBatchFinished marks a batch as completed
+func MarkBatchFinished(jobId string, batchIndex int) {
+	UpdateJob(jobId, func(job *Job) {
+		if batchIndex >= len(job.Batches) {
+			return
+		}
+		now := time.Now()
+		job.Batches[batchIndex].Status = JobStatusCompleted
+		job.Batches[batchIndex].FinishedAt = &now
+	})
+}
+
+// SetUserResult updates the per-user result of a job
+func SetUserResult(jobId, userId, status, rangeState, errorMessage string) {
+	UpdateJob(jobId, func(job *Job) {
+		result := job.Result(userId)
+		if result == nil {
+			return
+		}
+
+		now := time.Now()
+		result.Status = status
+		if rangeState != "" {
+			result.RangeState = rangeState
+		}
+		result.Error = errorMessage
+
+		switch status {
+		case UserStatusSent:
+			result.SentAt = &now
+			result.Attempts++
+		case UserStatusSuccess, UserStatusFailed, UserStatusSkipped:
+			result.FinishedAt = &now
+		}
+	})
+}
@@ -1,16 +1,12 @@
 package utils
 
 import (
-	"bytes"
+	"context"
 	"dulus/server/config"
 	"encoding/json"
 	"fmt"
-	"io"
-	"mime/multipart"
 	"net/http"
-	"net/url"
 	"regexp"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,11 +14,10 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-type LudusRequest struct {
-	Method  string
-	URL     string
-	Payload interface{}
-	UserID  string
+// LudusTask is one call of a bulk operation, UserID labels its result
+type LudusTask struct {
+	UserID string
+	Call   func() (interface{}, error)
 }
 
 type LudusResponse struct {
@@ -43,166 +38,218 @@ type UserTeam struct {
 }
 
 type Pool struct {
-	CreatedBy     string `json:"createdBy"`
-	Note          string `json:"note"`
-	TopologyId    string `json:"topologyId"`
-	Type          string `json:"type"`
-	UsersAndTeams []struct {
-		User       string `json:"user"`
-		UserId     string `json:"userId"`
-		Team       string `json:"team,omitempty"`
-		MainUserId string `json:"mainUserId,omitempty"`
-	} `json:"usersAndTeams"`
+	Id            string     `json:"-"`
+	CreatedAt     time.Time  `json:"-"`
+	Revision      int        `json:"revision"`
+	CreatedBy     string     `json:"createdBy"`
+	Note          string     `json:"note"`
+	TopologyId    string     `json:"topologyId"`
+	Type          string     `json:"type"`
+	UsersAndTeams []PoolUser `json:"usersAndTeams"`
+	CoInstructors []string   `json:"coInstructors,omitempty"`
+	Observers     []string   `json:"observers,omitempty"`
 }
 
-// Range status types
-type RangeStatus struct {
-	RangeState string `json:"rangeState"`
+// PoolUser is one member of a pool
+type PoolUser struct {
+	User       string `json:"user"`
+	UserId     string `json:"userId"`
+	Team       string `json:"team,omitempty"`
+	MainUserId string `json:"mainUserId,omitempty"`
 }
 
-type LogResult struct {
-	Result string `json:"result"`
+// LudusClient is the typed interface to the Ludus API. Every call is made on
+// behalf of the API key the client was created with and is cancelled with the
+// context the client is bound to.
+type LudusClient interface {
+	// WithContext returns a copy of the client whose calls are bound to ctx
+	WithContext(ctx context.Context) LudusClient
+
+	GetVersion() (string, error)
+
+	GetCurrentUser() (LudusUser, error)
+	GetUser(userId string) (LudusUser, error)
+	ListUsers() ([]LudusUser, error)
+	AddUser(userId, name string, isAdmin bool) (LudusUser, error)
+	DeleteUser(userId string) (LudusResult, error)
+	GetCredentials() (LudusCredentials, error)
+	GetWireguard(userId string) (string, error)
+
+	GetRange(userId string) (LudusRange, error)
+	DeployRange(userId string) (LudusResult, error)
+	AbortRange(userId string) (LudusResult, error)
+	DestroyRange(userId string) (LudusResult, error)
+	PowerOn(userId string) (LudusResult, error)
+	PowerOff(userId string) (LudusResult, error)
+	StartTesting(userId string) (LudusResult, error)
+	StopTesting(userId string) (LudusResult, error)
+	GetConfig(userId string) (string, error)
+	PutConfig(userId, content string, force bool) (LudusResult, error)
+	GetLogs(userId string) (string, error)
+
+	GetRangeAccess() ([]LudusRangeAccess, error)
+	GrantAccess(targetUserId, sourceUserId string) (LudusResult, error)
+	RevokeAccess(targetUserId, sourceUserId string) (LudusResult, error)
+
+	ListAnsibleRoles() ([]LudusAnsibleRole, error)
 }
 
-// MakeConcurrentLudusRequests processes multiple Ludus requests concurrently with optional sleep between requests
-func MakeConcurrentLudusRequests(requests []LudusRequest, apiKey string, maxConcurrency int) []LudusResponse {
-	// Create channels
-	requestChan := make(chan LudusRequest, len(requests))
-	responseChan := make(chan LudusResponse, len(requests))
+// RangeAction is a per-user range operation, e.g. LudusClient.PowerOn
+type RangeAction func(client LudusClient, userId string) (LudusResult, error)
 
-	// Start worker goroutines
-	var wg sync.WaitGroup
-	for i := 0; i < maxConcurrency && i < len(requests); i++ {
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			for req := range requestChan {
-				response, err := MakeLudusRequest(req.Method, req.URL, req.Payload, apiKey)
-				responseChan <- LudusResponse{
-					UserID:   req.UserID,
-					Response: response,
-					Error:    err,
-				}
-			}
-		}()
+// NewLudusClient creates the client used for an API key, speaking the API of
+// the detected Ludus version. It is a variable so tests can substitute a fake
+// implementation.
+var NewLudusClient = func(apiKey string) LudusClient {
+	client := NewHTTPLudusClient(config.LudusUrl, config.LudusAdminUrl, apiKey)
+	if ludusApiVersionFor(client) == LudusApiV2 {
+		return &LudusV2Client{client}
 	}
+	return client
+}
 
-	// Send requests to workers
-	for _, req := range requests {
-		requestChan <- req
-	}
-	close(requestChan)
-
-	// Wait for all workers to finish
-	go func() {
-		wg.Wait()
-		close(responseChan)
-	}()
-
-	// Collect results
-	results := make([]LudusResponse, 0, len(requests))
-	for response := range responseChan {
-		results = append(results, response)
+// RequestAPIKey returns the Ludus API key of the current request: the one kept
+// for its session, or the X-API-Key header
+func RequestAPIKey(c *gin.Context) string {
+	if apiKey := c.GetString("apiKey"); apiKey != "" {
+		return apiKey
 	}
+	return c.Request.Header.Get("X-API-Key")
+}
 
-	return results
+// LudusClientFromRequest creates a Ludus client for the API key of the current
+// request, whose calls stop when the caller goes away
+func LudusClientFromRequest(c *gin.Context) LudusClient {
+	return NewLudusClient(RequestAPIKey(c)).WithContext(c.Request.Context())
 }
 
-// MakeSequentialRequestsWithSleep processes multiple Ludus requests sequentially with optional sleep between requests
-func MakeSequentialRequestsWithSleep(requests []LudusRequest, apiKey string, sleepDuration time.Duration) []LudusResponse {
-	results := make([]LudusResponse, 0, len(requests))
+// ErrLudusUserNotFound is returned by GetUser when Ludus has no such user
+var ErrLudusUserNotFound = fmt.Errorf("ludus user not found")
 
-	for i, req := range requests {
-		response, err := MakeLudusRequest(req.Method, req.URL, req.Payload, apiKey)
-		results = append(results, LudusResponse{
-			UserID:   req.UserID,
-			Response: response,
-			Error:    err,
-		})
+// ErrLudusRangeNotFound is returned by GetRange when the user has no range
+var ErrLudusRangeNotFound = fmt.Errorf("ludus range not found")
 
-		// Sleep between requests if configured and not the last request
-		if sleepDuration > 0 && i < len(requests)-1 {
-			time.Sleep(sleepDuration)
-		}
-	}
+// LudusResult is the {"result": ...} envelope Ludus returns for most actions
+type LudusResult struct {
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	StatusCode int         `json:"-"`
+}
 
-	return results
+type LudusUser struct {
+	Name                  string `json:"name"`
+	UserID                string `json:"userID"`
+	DateCreated           string `json:"dateCreated,omitempty"`
+	DateLastActive        string `json:"dateLastActive,omitempty"`
+	IsAdmin               bool   `json:"isAdmin"`
+	ProxmoxUsername       string `json:"proxmoxUsername,omitempty"`
+	PortforwardingEnabled bool   `json:"portforwardingEnabled,omitempty"`
+	APIKey                string `json:"apiKey,omitempty"`
+}
+
+type LudusCredentials struct {
+	LudusEmail      string `json:"ludusEmail,omitempty"`
+	ProxmoxUsername string `json:"proxmoxUsername"`
+	ProxmoxPassword string `json:"proxmoxPassword"`
+	ProxmoxRealm    string `json:"proxmoxRealm,omitempty"`
 }
 
-// MakeLudusRequest makes a single request to Ludus API
-func MakeLudusRequest(method, url string, payload interface{}, apiKey string) (interface{}, error) {
-	client := createHTTPClient()
+type LudusVM struct {
+	ID          int    `json:"ID"`
+	ProxmoxID   int    `json:"proxmoxID"`
+	RangeNumber int    `json:"rangeNumber"`
+	Name        string `json:"name"`
+	PoweredOn   bool   `json:"poweredOn"`
+	IP          string `json:"ip,omitempty"`
+}
 
-	var req *http.Request
-	var err error
+type LudusRange struct {
+	UserID         string    `json:"userID"`
+	RangeID        string    `json:"rangeID,omitempty"`
+	RangeNumber    int       `json:"rangeNumber"`
+	RangeState     string    `json:"rangeState"`
+	NumberOfVMs    int       `json:"numberOfVMs"`
+	TestingEnabled bool      `json:"testingEnabled"`
+	LastDeployment string    `json:"lastDeployment,omitempty"`
+	AllowedDomains []string  `json:"allowedDomains,omitempty"`
+	AllowedIPs     []string  `json:"allowedIPs,omitempty"`
+	VMs            []LudusVM `json:"VMs"`
+}
 
-	if payload != nil {
-		jsonData, _ := json.Marshal(payload)
-		req, err = http.NewRequest(method, url, bytes.NewBuffer(jsonData))
-		if err != nil {
-			return nil, err
-		}
-		req.Header.Set("Content-Type", "application/json")
-	} else {
-		req, err = http.NewRequest(method, url, nil)
-		if err != nil {
-			return nil, err
+// AllPoweredOn reports whether every VM of the range is running
+func (r LudusRange) AllPoweredOn() bool {
+	for _, vm := range r.VMs {
+		if !vm.PoweredOn {
+			return false
 		}
 	}
+	return true
+}
 
-	req.Header.Set("X-Api-Key", apiKey)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+// LudusRangeAccess lists the users who can access the range of TargetUserID
+type LudusRangeAccess struct {
+	TargetUserID  string   `json:"targetUserID"`
+	SourceUserIDs []string `json:"sourceUserIDs"`
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+type LudusAnsibleRole struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	Type    string `json:"type"`
+	Global  bool   `json:"global"`
+}
 
-	var result interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return string(body), nil
+// UserTasks builds one task per user that runs call for that user
+func UserTasks(userIds []string, call func(userId string) (interface{}, error)) []LudusTask {
+	tasks := make([]LudusTask, len(userIds))
+	for i, userId := range userIds {
+		tasks[i] = LudusTask{
+			UserID: userId,
+			Call:   func() (interface{}, error) { return call(userId) },
+		}
 	}
+	return tasks
+}
 
-	return result, nil
+// RangeActionTasks builds one task per user that runs action against the user's range
+func RangeActionTasks(client LudusClient, userIds []string, action RangeAction) []LudusTask {
+	return UserTasks(userIds, func(userId string) (interface{}, error) {
+		return action(client, userId)
+	})
 }
 
-// MakeConcurrentFileUploads processes multiple file uploads concurrently
-func MakeConcurrentFileUploads(userIds []string, configContent string, force bool, apiKey string, maxConcurrency int) []LudusResponse {
+// RunConcurrentTasks runs tasks concurrently with at most maxConcurrency in flight
+func RunConcurrentTasks(tasks []LudusTask, maxConcurrency int) []LudusResponse {
 	if maxConcurrency <= 0 {
 		maxConcurrency = 5
 	}
 
 	// Create channels
-	userChan := make(chan string, len(userIds))
-	responseChan := make(chan LudusResponse, len(userIds))
+	taskChan := make(chan LudusTask, len(tasks))
+	responseChan := make(chan LudusResponse, len(tasks))
 
 	// Start worker goroutines
 	var wg sync.WaitGroup
-	for i := 0; i < maxConcurrency && i < len(userIds); i++ {
+	for i := 0; i < maxConcurrency && i < len(tasks); i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for userID := range userChan {
-				response, err := UploadConfigFile(userID, configContent, force, apiKey)
+			for task := range taskChan {
+				response, err := task.Call()
 				responseChan <- LudusResponse{
-					UserID:   userID,
+					UserID:   task.UserID,
 					Response: response,
-					Error:    err,
+					Error:    withTaskUser(err, task.UserID),
 				}
 			}
 		}()
 	}
 
-	// Send user IDs to workers
-	for _, userID := range userIds {
-		userChan <- userID
+	// Send tasks to workers
+	for _, task := range tasks {
+		taskChan <- task
 	}
-	close(userChan)
+	close(taskChan)
 
 	// Wait for all workers to finish
 	go func() {
@@ -211,7 +258,7 @@ func MakeConcurrentFileUploads(userIds []string, configContent string, force boo
 	}()
 
 	// Collect results
-	results := make([]LudusResponse, 0, len(userIds))
+	results := make([]LudusResponse, 0, len(tasks))
 	for response := range responseChan {
 		results = append(results, response)
 	}
@@ -219,232 +266,205 @@ func MakeConcurrentFileUploads(userIds []string, configContent string, force boo
 	return results
 }
 
-// UploadConfigFile uploads configuration file to Ludus
-func UploadConfigFile(userID, configContent string, force bool, apiKey string) (interface{}, error) {
-	var buf bytes.Buffer
-	writer := multipart.NewWriter(&buf)
-
-	// Add the file content
-	fileWriter, err := writer.CreateFormFile("file", "topology.yml")
-	if err != nil {
-		return nil, err
-	}
-	fileWriter.Write([]byte(configContent))
+// RunSequentialTasksWithSleep runs tasks one after another with optional sleep between them.
+// If onResponse is set it is called with each response as soon as its task returns.
+// Once ctx is cancelled no further task is started and the sleep is cut short.
+func RunSequentialTasksWithSleep(ctx context.Context, tasks []LudusTask, sleepDuration time.Duration, onResponse func(LudusResponse)) []LudusResponse {
+	results := make([]LudusResponse, 0, len(tasks))
 
-	// Add force parameter
-	writer.WriteField("force", strconv.FormatBool(force))
-	writer.Close()
+	for i, task := range tasks {
+		if ctx.Err() != nil {
+			break
+		}
 
-	// Create request
-	url := fmt.Sprintf("%s/range/config?userID=%s", config.LudusUrl, url.QueryEscape(userID))
-	req, err := http.NewRequest("PUT", url, &buf)
-	if err != nil {
-		return nil, err
-	}
+		response, err := task.Call()
+		result := LudusResponse{
+			UserID:   task.UserID,
+			Response: response,
+			Error:    withTaskUser(err, task.UserID),
+		}
+		results = append(results, result)
 
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	req.Header.Set("X-Api-Key", apiKey)
+		if onResponse != nil {
+			onResponse(result)
+		}
 
-	client := createHTTPClient()
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
+		// Sleep between requests if configured and not the last request
+		if sleepDuration > 0 && i < len(tasks)-1 {
+			if !sleepContext(ctx, sleepDuration) {
+				break
+			}
+		}
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
+	return results
+}
 
-	var result interface{}
-	if err := json.Unmarshal(body, &result); err != nil {
-		return string(body), nil
-	}
+// sleepContext waits for d and reports false if ctx was cancelled first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
 
-	return result, nil
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
 // AllRangesDeployed checks if all user ranges are deployed
-func AllRangesDeployed(userIds []string, apiKey string, c *gin.Context) bool {
-	requests := make([]LudusRequest, len(userIds))
-	for i, userID := range userIds {
-		requests[i] = LudusRequest{
-			Method: "GET",
-			URL:    config.LudusUrl + "/range/?userID=" + userID,
-			UserID: userID,
-		}
-	}
-
-	responses := MakeConcurrentLudusRequests(requests, apiKey, config.MaxConcurrentRequests)
+func AllRangesDeployed(client LudusClient, userIds []string, c *gin.Context) bool {
+	tasks := UserTasks(userIds, func(userId string) (interface{}, error) {
+		return client.GetRange(userId)
+	})
 
-	for _, resp := range responses {
+	for _, resp := range RunConcurrentTasks(tasks, config.MaxConcurrentRequests) {
 		if resp.Error != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to check range status for user " + resp.UserID})
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to check range status for user " + resp.UserID + ": " + resp.Error.Error()})
 			return false
 		}
 
-		if resp.Response != nil {
-			var rangeStatus RangeStatus
-			if data, err := json.Marshal(resp.Response); err == nil {
-				if err := json.Unmarshal(data, &rangeStatus); err == nil {
-					if rangeStatus.RangeState != "SUCCESS" && rangeStatus.RangeState != "DEPLOYED" {
-						c.JSON(http.StatusBadRequest, gin.H{"error": "Not all ranges are deployed. User " + resp.UserID + " has state: " + rangeStatus.RangeState})
-						return false
-					}
-				} else {
-					c.JSON(http.StatusBadRequest, gin.H{"error": "Unable to determine range state for user " + resp.UserID})
-					return false
-				}
-			}
+		rangeState := resp.Response.(LudusRange).RangeState
+		if rangeState != "SUCCESS" && rangeState != "DEPLOYED" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Not all ranges are deployed. User " + resp.UserID + " has state: " + rangeState})
+			return false
 		}
 	}
 	return true
 }
 
-// WaitForBatchDestroyed waits until all users in batch are destroyed
-func WaitForBatchDestroyed(userIds []string, apiKey string, checkInterval time.Duration) {
-	for {
-		allDestroyed := true
-
-		// Check status of all users in batch
-		requests := make([]LudusRequest, len(userIds))
-		for i, userID := range userIds {
-			requests[i] = LudusRequest{
-				Method: "GET",
-				URL:    config.LudusUrl + "/range/?userID=" + userID,
-				UserID: userID,
-			}
+// RangeStateUnavailable is reported for ranges that were not checked because Ludus is unavailable
+const RangeStateUnavailable = "unavailable"
+
+// GetRangeStates fetches the current range state of every user, at most
+// config.MaxConcurrentRequests at a time. Users whose range was not checked
+// because Ludus is unavailable get RangeStateUnavailable, users whose range
+// cannot be read otherwise get the state "unknown".
+func GetRangeStates(client LudusClient, userIds []string) map[string]string {
+	tasks := UserTasks(userIds, func(userId string) (interface{}, error) {
+		return client.GetRange(userId)
+	})
+
+	states := make(map[string]string, len(userIds))
+	for _, resp := range RunConcurrentTasks(tasks, config.MaxConcurrentRequests) {
+		if IsUpstreamUnavailable(resp.Error) {
+			states[resp.UserID] = RangeStateUnavailable
+			continue
 		}
-
-		responses := MakeConcurrentLudusRequests(requests, apiKey, len(userIds))
-
-		for _, resp := range responses {
-			if resp.Error != nil {
-				continue // Error might mean destroyed or not found
-			}
-
-			state := "unknown"
-			if resp.Response != nil {
-				// Handle both string and map responses
-				switch response := resp.Response.(type) {
-				case map[string]interface{}:
-					if rangeState, exists := response["rangeState"]; exists {
-						state = rangeState.(string)
-					}
-				case string:
-					state = response
-				}
-			}
-
-			// If any user is still destroying, we're not done
-			if state == "DESTROYING" {
-				allDestroyed = false
-				break
-			}
+		if resp.Error != nil || resp.Response.(LudusRange).RangeState == "" {
+			states[resp.UserID] = "unknown"
+			continue
 		}
+		states[resp.UserID] = resp.Response.(LudusRange).RangeState
+	}
+	return states
+}
 
-		// If all destroyed, continue
-		if allDestroyed {
-			return
-		}
+// WaitOptions controls polling and timeouts of the batch wait loops
+type WaitOptions struct {
+	CheckInterval time.Duration
+	RangeTimeout  time.Duration        // per range, 0 disables the limit
+	BatchDeadline time.Time            // whole batch, zero disables the limit
+	StartedAt     map[string]time.Time // when each range started, defaults to the start of the wait
+}
 
-		time.Sleep(checkInterval)
-	}
+// WaitForBatchDestroyed waits until all users in batch are destroyed and returns
+// the users whose range was still destroying when a timeout expired
+func WaitForBatchDestroyed(ctx context.Context, client LudusClient, userIds []string, options WaitOptions) []string {
+	_, timedOut := waitForRangeStates(ctx, client, userIds, "DESTROYING", options)
+	return timedOut
 }
 
-// WaitForBatchDeployment waits until all users in batch are deployed or failed
-func WaitForBatchDeployment(userIds []string, apiKey string, checkInterval time.Duration) {
+// WaitForBatchDeployment waits until all users in batch are deployed or failed.
+// It returns the last range state of each user and the users whose range was
+// still deploying when a timeout expired.
+func WaitForBatchDeployment(ctx context.Context, client LudusClient, userIds []string, options WaitOptions) (map[string]string, []string) {
+	return waitForRangeStates(ctx, client, userIds, "DEPLOYING", options)
+}
+
+// waitForRangeStates polls range states until no range is in busyState anymore.
+// Ranges that stay busy past the range timeout, or all busy ranges once the batch
+// deadline has passed, are given up on and returned as timed out. When ctx is
+// cancelled it returns right away with the states seen so far.
+func waitForRangeStates(ctx context.Context, client LudusClient, userIds []string, busyState string, options WaitOptions) (map[string]string, []string) {
+	waitStart := time.Now()
+	states := make(map[string]string, len(userIds))
+	var timedOut []string
+
+	remaining := append([]string{}, userIds...)
 	for {
-		allDone := true
-		// Check status of all users in batch
-		requests := make([]LudusRequest, len(userIds))
-		for i, userID := range userIds {
-			requests[i] = LudusRequest{
-				Method: "GET",
-				URL:    config.LudusUrl + "/range/?userID=" + userID,
-				UserID: userID,
-			}
+		currentStates := GetRangeStates(client.WithContext(ctx), remaining)
+		// Calls cut off by the cancellation say nothing about the ranges
+		if ctx.Err() != nil {
+			return states, timedOut
 		}
 
-		responses := MakeConcurrentLudusRequests(requests, apiKey, len(userIds))
-
-		for _, resp := range responses {
-			if resp.Error != nil {
-				continue // Error = done (failed)
+		// Check status of remaining users, an unknown state counts as done.
+		// While Ludus is unavailable the ranges are assumed to be still busy.
+		var stillBusy []string
+		for userId, state := range currentStates {
+			states[userId] = state
+			if state != busyState && state != RangeStateUnavailable {
+				continue
 			}
 
-			state := "unknown"
-			if resp.Response != nil {
-				if rangeState, exists := resp.Response.(map[string]interface{})["rangeState"]; exists {
-					state = rangeState.(string)
-				}
+			startedAt, exists := options.StartedAt[userId]
+			if !exists {
+				startedAt = waitStart
 			}
 
-			// If any user is still deploying, we're not done
-			if state == "DEPLOYING" {
-				allDone = false
+			rangeExpired := options.RangeTimeout > 0 && time.Since(startedAt) > options.RangeTimeout
+			batchExpired := !options.BatchDeadline.IsZero() && time.Now().After(options.BatchDeadline)
+			if rangeExpired || batchExpired {
+				timedOut = append(timedOut, userId)
+				continue
 			}
+			stillBusy = append(stillBusy, userId)
 		}
 
-		// If all done, continue to next batch
-		if allDone {
-			return
+		// If no range is busy anymore, the batch is done
+		if len(stillBusy) == 0 {
+			return states, timedOut
 		}
+		remaining = stillBusy
 
-		time.Sleep(checkInterval)
+		if !sleepContext(ctx, options.CheckInterval) {
+			return states, timedOut
+		}
 	}
 }
 
 // GetFlagsForUsers retrieves flags for multiple users concurrently
-func GetFlagsForUsers(c *gin.Context, userIds []string, apiKey string) (map[string][]Flag, bool) {
-	requests := make([]LudusRequest, len(userIds))
-	for i, userId := range userIds {
-		requests[i] = LudusRequest{
-			Method:  "GET",
-			URL:     config.LudusUrl + "/range/logs/?userID=" + userId,
-			Payload: nil,
-			UserID:  userId,
-		}
-	}
+func GetFlagsForUsers(c *gin.Context, client LudusClient, userIds []string) (map[string][]Flag, bool) {
+	tasks := UserTasks(userIds, func(userId string) (interface{}, error) {
+		return client.GetLogs(userId)
+	})
 
-	responses := MakeConcurrentLudusRequests(requests, apiKey, config.MaxConcurrentRequests)
+	responses := RunConcurrentTasks(tasks, config.MaxConcurrentRequests)
 
 	// Process responses and create a map of userId -> flags
 	userFlagsMap := make(map[string][]Flag)
 	flagPattern := regexp.MustCompile(`&%&&%&&%&(.*?)&%&&%&&%&`)
 	for _, resp := range responses {
 		if resp.Error != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get flags for user: " + resp.UserID})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get flags for user: " + resp.UserID + ": " + resp.Error.Error()})
 			return nil, false
 		}
 
 		// Extract flags for this user
-		flags := ExtractUserFlags(resp, flagPattern)
+		flags := ExtractUserFlags(resp.Response.(string), flagPattern)
 		userFlagsMap[resp.UserID] = flags
 	}
 
 	return userFlagsMap, true
 }
 
-// ExtractUserFlags extracts flags from a single user's log response
-func ExtractUserFlags(resp LudusResponse, flagPattern *regexp.Regexp) []Flag {
+// ExtractUserFlags extracts flags from a single user's range logs
+func ExtractUserFlags(logs string, flagPattern *regexp.Regexp) []Flag {
 	var flags []Flag
 
-	if resp.Error != nil || resp.Response == nil {
-		return flags
-	}
-
-	var logResult LogResult
-	if data, err := json.Marshal(resp.Response); err == nil {
-		if err := json.Unmarshal(data, &logResult); err != nil {
-			return flags
-		}
-	} else {
-		return flags
-	}
-
-	matches := flagPattern.FindAllStringSubmatch(logResult.Result, -1)
+	matches := flagPattern.FindAllStringSubmatch(logs, -1)
 	if len(matches) == 0 {
 		return flags
 	}
@@ -472,24 +492,15 @@ func ExtractUserFlags(resp LudusResponse, flagPattern *regexp.Regexp) []Flag {
 }
 
 // GetLudusServerVersion retrieves the Ludus server version and trims the commit hash
-func GetLudusServerVersion(apiKey string) (string, error) {
-	response, err := MakeLudusRequest("GET", config.LudusUrl+"/", nil, apiKey)
+func GetLudusServerVersion(client LudusClient) (string, error) {
+	versionStr, err := client.GetVersion()
 	if err != nil {
 		return "", err
 	}
 
-	// Parse response to extract version
-	if resultMap, ok := response.(map[string]interface{}); ok {
-		if result, exists := resultMap["result"]; exists {
-			if versionStr, ok := result.(string); ok {
-				// Trim the part after + (e.g., "Ludus Server v1.0.0+abc123a" -> "Ludus Server v1.0.0")
-				if plusIndex := strings.Index(versionStr, "+"); plusIndex != -1 {
-					return versionStr[:plusIndex], nil
-				}
-				return versionStr, nil
-			}
-		}
+	// Trim the part after + (e.g., "Ludus Server v1.0.0+abc123a" -> "Ludus Server v1.0.0")
+	if plusIndex := strings.Index(versionStr, "+"); plusIndex != -1 {
+		return versionStr[:plusIndex], nil
 	}
-
-	return "", fmt.Errorf("invalid response format")
+	return versionStr, nil
 }
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Longest Ludus response body kept as error message when it is not JSON
+const maxLudusErrorMessageLength = 200
+
+// LudusError is a Ludus API call that returned a non-2xx status
+type LudusError struct {
+	StatusCode int    `json:"statusCode"`
+	Message    string `json:"message"`
+	Endpoint   string `json:"endpoint"`
+	UserID     string `json:"userId,omitempty"`
+}
+
+func (e *LudusError) Error() string {
+	target := ""
+	if e.UserID != "" {
+		target = " for user " + e.UserID
+	}
+	return fmt.Sprintf("ludus %s%s returned %d: %s", e.Endpoint, target, e.StatusCode, e.Message)
+}
+
+// newLudusError builds the error for a failed response. The user is taken from
+// the userID or rangeID query parameter when the endpoint has one.
+func newLudusError(req *http.Request, statusCode int, body []byte) *LudusError {
+	ludusErr := &LudusError{
+		StatusCode: statusCode,
+		Message:    ludusErrorMessage(statusCode, body),
+		Endpoint:   req.Method + " " + req.URL.Path,
+		UserID:     req.URL.Query().Get("userID"),
+	}
+	if ludusErr.UserID == "" {
+		ludusErr.UserID = req.URL.Query().Get("rangeID")
+	}
+	return ludusErr
+}
+
+// ludusErrorMessage extracts the {"error": "..."} message Ludus sends with failures
+func ludusErrorMessage(statusCode int, body []byte) string {
+	var response struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(body, &response); err == nil && response.Error != "" {
+		return response.Error
+	}
+
+	message := strings.TrimSpace(string(body))
+	if message == "" {
+		return http.StatusText(statusCode)
+	}
+	if len(message) > maxLudusErrorMessageLength {
+		message = message[:maxLudusErrorMessageLength] + "..."
+	}
+	return message
+}
+
+// AsLudusError returns the LudusError wrapped in err, if any
+func AsLudusError(err error) (*LudusError, bool) {
+	var ludusErr *LudusError
+	if errors.As(err, &ludusErr) {
+		return ludusErr, true
+	}
+	return nil, false
+}
+
+// LudusStatusCode returns the HTTP status Ludus answered with, or 0 if the
+// call failed before Ludus responded
+func LudusStatusCode(err error) int {
+	if ludusErr, ok := AsLudusError(err); ok {
+		return ludusErr.StatusCode
+	}
+	return 0
+}
+
+// IsLudusNotFound reports whether err means the user or range does not exist
+func IsLudusNotFound(err error) bool {
+	return errors.Is(err, ErrLudusUserNotFound) || errors.Is(err, ErrLudusRangeNotFound) ||
+		LudusStatusCode(err) == http.StatusNotFound
+}
+
+// withTaskUser labels a LudusError with the user of the bulk task it came from
+// when the endpoint did not name the user itself
+func withTaskUser(err error, userId string) error {
+	if ludusErr, ok := AsLudusError(err); ok && ludusErr.UserID == "" {
+		labeled := *ludusErr
+		labeled.UserID = userId
+		return &labeled
+	}
+	return err
+}
+
+// ResponseResult converts one bulk response to its per-user result entry:
+// {"userId", "status": "success", "response"} or {"userId", "status": "failed", "error"},
+// both with the HTTP status of the Ludus call when known
+func ResponseResult(resp LudusResponse) gin.H {
+	if resp.Error != nil {
+		result := gin.H{"userId": resp.UserID, "status": "failed", "error": resp.Error.Error()}
+		if ludusErr, ok := AsLudusError(resp.Error); ok {
+			result["statusCode"] = ludusErr.StatusCode
+			result["endpoint"] = ludusErr.Endpoint
+			result["error"] = ludusErr.Message
+		}
+		return result
+	}
+
+	result := gin.H{"userId": resp.UserID, "status": "success", "response": resp.Response}
+	if ludusResult, ok := resp.Response.(LudusResult); ok && ludusResult.StatusCode != 0 {
+		result["statusCode"] = ludusResult.StatusCode
+	}
+	return result
+}
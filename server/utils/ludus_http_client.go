@@ -0,0 +1,403 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"dulus/server/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// HTTPLudusClient implements LudusClient against the Ludus 1.x REST API, where
+// every user owns exactly one range addressed by ?userID=
+type HTTPLudusClient struct {
+	BaseURL         string
+	AdminURL        string
+	APIKey          string
+	HTTPClient      *http.Client
+	AdminHTTPClient *http.Client
+	Timeout         time.Duration // per call including retries, 0 disables
+	ctx             context.Context
+}
+
+// NewHTTPLudusClient creates a client on top of the shared Ludus connections
+func NewHTTPLudusClient(baseURL, adminURL, apiKey string) *HTTPLudusClient {
+	return &HTTPLudusClient{
+		BaseURL:         baseURL,
+		AdminURL:        adminURL,
+		APIKey:          apiKey,
+		HTTPClient:      LudusHTTPClient(),
+		AdminHTTPClient: LudusAdminHTTPClient(),
+		Timeout:         time.Duration(config.LudusRequestTimeoutSeconds) * time.Second,
+	}
+}
+
+// WithContext returns a copy of the client whose calls are bound to ctx
+func (l *HTTPLudusClient) WithContext(ctx context.Context) LudusClient {
+	return l.withContext(ctx)
+}
+
+func (l *HTTPLudusClient) withContext(ctx context.Context) *HTTPLudusClient {
+	copied := *l
+	copied.ctx = ctx
+	return &copied
+}
+
+// context returns the context calls are bound to
+func (l *HTTPLudusClient) context() context.Context {
+	if l.ctx == nil {
+		return context.Background()
+	}
+	return l.ctx
+}
+
+// userQuery returns the ?userID= query string for userId
+func userQuery(userId string) string {
+	return "?userID=" + url.QueryEscape(userId)
+}
+
+// send makes a single request to Ludus and returns the raw response body and
+// status. A non-2xx status is returned as a *LudusError.
+func (l *HTTPLudusClient) send(req *http.Request) ([]byte, int, error) {
+	req.Header.Set("X-Api-Key", l.APIKey)
+
+	if l.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), l.Timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	httpClient := l.HTTPClient
+	if l.AdminHTTPClient != nil && strings.HasPrefix(req.URL.String(), l.AdminURL) {
+		httpClient = l.AdminHTTPClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		// Report an open circuit as is instead of wrapped in the request URL
+		var unavailable *UnavailableError
+		if errors.As(err, &unavailable) {
+			return nil, 0, unavailable
+		}
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, resp.StatusCode, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return body, resp.StatusCode, newLudusError(req, resp.StatusCode, body)
+	}
+	return body, resp.StatusCode, nil
+}
+
+// newJSONRequest builds a request with an optional JSON payload
+func newJSONRequest(ctx context.Context, method, url string, payload interface{}) (*http.Request, error) {
+	var body io.Reader
+	if payload != nil {
+		jsonData, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		body = bytes.NewBuffer(jsonData)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// request sends an optional JSON payload and returns the raw response body and status
+func (l *HTTPLudusClient) request(method, url string, payload interface{}) ([]byte, int, error) {
+	req, err := newJSONRequest(l.context(), method, url, payload)
+	if err != nil {
+		return nil, 0, err
+	}
+	return l.send(req)
+}
+
+// requestJSON sends a request and decodes the JSON response into target
+func (l *HTTPLudusClient) requestJSON(method, url string, payload, target interface{}) error {
+	body, _, err := l.request(method, url, payload)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(body, target); err != nil {
+		return fmt.Errorf("unexpected response from Ludus %s: %w", url, err)
+	}
+	return nil
+}
+
+// requestResult sends a request whose response is a {"result": ...} envelope.
+// A body that is not JSON is returned as the result text.
+func (l *HTTPLudusClient) requestResult(method, url string, payload interface{}) (LudusResult, error) {
+	body, statusCode, err := l.request(method, url, payload)
+	if err != nil {
+		return LudusResult{}, err
+	}
+	return decodeLudusResult(body, statusCode), nil
+}
+
+// requestIdempotentResult is requestResult for calls that are safe to retry
+// although their method is not, like access grants
+func (l *HTTPLudusClient) requestIdempotentResult(method, url string, payload interface{}) (LudusResult, error) {
+	req, err := newJSONRequest(l.context(), method, url, payload)
+	if err != nil {
+		return LudusResult{}, err
+	}
+	MarkIdempotent(req)
+
+	body, statusCode, err := l.send(req)
+	if err != nil {
+		return LudusResult{}, err
+	}
+	return decodeLudusResult(body, statusCode), nil
+}
+
+func decodeLudusResult(body []byte, statusCode int) LudusResult {
+	var result LudusResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		result = LudusResult{Result: string(body)}
+	}
+	result.StatusCode = statusCode
+	return result
+}
+
+// resultString decodes a {"result": "<text>"} response
+func (l *HTTPLudusClient) resultString(url string) (string, error) {
+	var response struct {
+		Result string `json:"result"`
+	}
+	if err := l.requestJSON("GET", url, nil, &response); err != nil {
+		return "", err
+	}
+	return response.Result, nil
+}
+
+func (l *HTTPLudusClient) GetVersion() (string, error) {
+	return l.resultString(l.BaseURL + "/")
+}
+
+func (l *HTTPLudusClient) GetCurrentUser() (LudusUser, error) {
+	var users []LudusUser
+	if err := l.requestJSON("GET", l.BaseURL+"/user", nil, &users); err != nil {
+		return LudusUser{}, err
+	}
+	if len(users) == 0 {
+		return LudusUser{}, ErrLudusUserNotFound
+	}
+	return users[0], nil
+}
+
+func (l *HTTPLudusClient) GetUser(userId string) (LudusUser, error) {
+	var users []LudusUser
+	if err := l.requestJSON("GET", l.BaseURL+"/user"+userQuery(userId), nil, &users); err != nil {
+		return LudusUser{}, err
+	}
+	if len(users) == 0 {
+		return LudusUser{}, ErrLudusUserNotFound
+	}
+	return users[0], nil
+}
+
+func (l *HTTPLudusClient) ListUsers() ([]LudusUser, error) {
+	var users []LudusUser
+	if err := l.requestJSON("GET", l.BaseURL+"/user/all", nil, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (l *HTTPLudusClient) AddUser(userId, name string, isAdmin bool) (LudusUser, error) {
+	payload := gin.H{
+		"name":    name,
+		"userID":  userId,
+		"isAdmin": isAdmin,
+	}
+
+	var user LudusUser
+	if err := l.requestJSON("POST", l.AdminURL+"/user", payload, &user); err != nil {
+		return LudusUser{}, err
+	}
+	return user, nil
+}
+
+func (l *HTTPLudusClient) DeleteUser(userId string) (LudusResult, error) {
+	return l.requestResult("DELETE", l.AdminURL+"/user/"+url.PathEscape(userId), nil)
+}
+
+func (l *HTTPLudusClient) GetCredentials() (LudusCredentials, error) {
+	var response struct {
+		Result LudusCredentials `json:"result"`
+	}
+	if err := l.requestJSON("GET", l.BaseURL+"/user/credentials", nil, &response); err != nil {
+		return LudusCredentials{}, err
+	}
+	return response.Result, nil
+}
+
+func (l *HTTPLudusClient) GetWireguard(userId string) (string, error) {
+	var response struct {
+		Result struct {
+			WireGuardConfig string `json:"wireGuardConfig"`
+		} `json:"result"`
+	}
+	if err := l.requestJSON("GET", l.BaseURL+"/user/wireguard"+userQuery(userId), nil, &response); err != nil {
+		return "", err
+	}
+	return response.Result.WireGuardConfig, nil
+}
+
+func (l *HTTPLudusClient) GetRange(userId string) (LudusRange, error) {
+	return l.getRange(l.BaseURL + "/range/" + userQuery(userId))
+}
+
+// getRange fetches a range, Ludus answers with an empty list when there is none
+func (l *HTTPLudusClient) getRange(url string) (LudusRange, error) {
+	body, _, err := l.request("GET", url, nil)
+	if err != nil {
+		return LudusRange{}, err
+	}
+
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		var ranges []LudusRange
+		if err := json.Unmarshal(trimmed, &ranges); err != nil {
+			return LudusRange{}, fmt.Errorf("unexpected range response: %w", err)
+		}
+		if len(ranges) == 0 {
+			return LudusRange{}, ErrLudusRangeNotFound
+		}
+		return ranges[0], nil
+	}
+
+	var rangeInfo LudusRange
+	if err := json.Unmarshal(body, &rangeInfo); err != nil {
+		return LudusRange{}, fmt.Errorf("unexpected range response: %w", err)
+	}
+	return rangeInfo, nil
+}
+
+func (l *HTTPLudusClient) DeployRange(userId string) (LudusResult, error) {
+	payload := gin.H{"tags": "all", "force": true}
+	return l.requestResult("POST", l.BaseURL+"/range/deploy/"+userQuery(userId), payload)
+}
+
+func (l *HTTPLudusClient) AbortRange(userId string) (LudusResult, error) {
+	return l.requestResult("POST", l.BaseURL+"/range/abort/"+userQuery(userId), nil)
+}
+
+func (l *HTTPLudusClient) DestroyRange(userId string) (LudusResult, error) {
+	return l.requestResult("DELETE", l.BaseURL+"/range/"+userQuery(userId), nil)
+}
+
+func (l *HTTPLudusClient) PowerOn(userId string) (LudusResult, error) {
+	payload := gin.H{"machines": []string{"all"}}
+	return l.requestResult("PUT", l.BaseURL+"/range/poweron"+userQuery(userId), payload)
+}
+
+func (l *HTTPLudusClient) PowerOff(userId string) (LudusResult, error) {
+	payload := gin.H{"machines": []string{"all"}}
+	return l.requestResult("PUT", l.BaseURL+"/range/poweroff"+userQuery(userId), payload)
+}
+
+func (l *HTTPLudusClient) StartTesting(userId string) (LudusResult, error) {
+	return l.requestResult("PUT", l.BaseURL+"/testing/start/"+userQuery(userId), nil)
+}
+
+func (l *HTTPLudusClient) StopTesting(userId string) (LudusResult, error) {
+	payload := gin.H{"force": true}
+	return l.requestResult("PUT", l.BaseURL+"/testing/stop/"+userQuery(userId), payload)
+}
+
+func (l *HTTPLudusClient) GetConfig(userId string) (string, error) {
+	return l.resultString(l.BaseURL + "/range/config/" + userQuery(userId))
+}
+
+func (l *HTTPLudusClient) PutConfig(userId, content string, force bool) (LudusResult, error) {
+	return l.putConfig(l.BaseURL+"/range/config"+userQuery(userId), content, force)
+}
+
+// putConfig uploads a range configuration as multipart form data
+func (l *HTTPLudusClient) putConfig(url, content string, force bool) (LudusResult, error) {
+	var buf bytes.Buffer
+	writer := multipart.NewWriter(&buf)
+
+	// Add the file content
+	fileWriter, err := writer.CreateFormFile("file", "topology.yml")
+	if err != nil {
+		return LudusResult{}, err
+	}
+	fileWriter.Write([]byte(content))
+
+	// Add force parameter
+	writer.WriteField("force", strconv.FormatBool(force))
+	writer.Close()
+
+	req, err := http.NewRequestWithContext(l.context(), "PUT", url, &buf)
+	if err != nil {
+		return LudusResult{}, err
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+
+	body, statusCode, err := l.send(req)
+	if err != nil {
+		return LudusResult{}, err
+	}
+	return decodeLudusResult(body, statusCode), nil
+}
+
+func (l *HTTPLudusClient) GetLogs(userId string) (string, error) {
+	return l.resultString(l.BaseURL + "/range/logs/" + userQuery(userId))
+}
+
+func (l *HTTPLudusClient) GetRangeAccess() ([]LudusRangeAccess, error) {
+	var access []LudusRangeAccess
+	if err := l.requestJSON("GET", l.BaseURL+"/range/access", nil, &access); err != nil {
+		return nil, err
+	}
+	return access, nil
+}
+
+func (l *HTTPLudusClient) GrantAccess(targetUserId, sourceUserId string) (LudusResult, error) {
+	return l.changeAccess("grant", targetUserId, sourceUserId)
+}
+
+func (l *HTTPLudusClient) RevokeAccess(targetUserId, sourceUserId string) (LudusResult, error) {
+	return l.changeAccess("revoke", targetUserId, sourceUserId)
+}
+
+// changeAccess grants or revokes sourceUserId's access to targetUserId's range
+func (l *HTTPLudusClient) changeAccess(action, targetUserId, sourceUserId string) (LudusResult, error) {
+	payload := gin.H{
+		"action":       action,
+		"targetUserID": targetUserId,
+		"sourceUserID": sourceUserId,
+		"force":        true,
+	}
+	return l.requestIdempotentResult("POST", l.BaseURL+"/range/access", payload)
+}
+
+func (l *HTTPLudusClient) ListAnsibleRoles() ([]LudusAnsibleRole, error) {
+	var roles []LudusAnsibleRole
+	if err := l.requestJSON("GET", l.BaseURL+"/ansible", nil, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
@@ -0,0 +1,148 @@
+package utils
+
+import (
+	"context"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+// LudusV2Client implements LudusClient against the Ludus 2.x REST API. Ranges
+// are separate entities there, addressed by ?rangeID=, and sharing is done by
+// assigning users to ranges. User endpoints are unchanged from 1.x.
+type LudusV2Client struct {
+	*HTTPLudusClient
+}
+
+func NewLudusV2Client(baseURL, adminURL, apiKey string) *LudusV2Client {
+	return &LudusV2Client{NewHTTPLudusClient(baseURL, adminURL, apiKey)}
+}
+
+// WithContext returns a copy of the client whose calls are bound to ctx
+func (l *LudusV2Client) WithContext(ctx context.Context) LudusClient {
+	return &LudusV2Client{l.withContext(ctx)}
+}
+
+// ludusAccessibleRange is an entry of GET /ranges/accessible
+type ludusAccessibleRange struct {
+	RangeID string `json:"rangeID"`
+}
+
+// rangeIdFor returns the id of the default range Ludus 2.x creates for a user,
+// which carries the same id as the user
+func rangeIdFor(userId string) string {
+	return userId
+}
+
+// rangeQuery returns the ?rangeID= query string for the default range of userId
+func rangeQuery(userId string) string {
+	return "?rangeID=" + url.QueryEscape(rangeIdFor(userId))
+}
+
+func (l *LudusV2Client) GetRange(userId string) (LudusRange, error) {
+	return l.getRange(l.BaseURL + "/range" + rangeQuery(userId))
+}
+
+func (l *LudusV2Client) DeployRange(userId string) (LudusResult, error) {
+	payload := gin.H{"tags": "all", "force": true}
+	return l.requestResult("POST", l.BaseURL+"/range/deploy"+rangeQuery(userId), payload)
+}
+
+func (l *LudusV2Client) AbortRange(userId string) (LudusResult, error) {
+	return l.requestResult("POST", l.BaseURL+"/range/abort"+rangeQuery(userId), nil)
+}
+
+func (l *LudusV2Client) DestroyRange(userId string) (LudusResult, error) {
+	return l.requestResult("DELETE", l.BaseURL+"/range"+rangeQuery(userId), nil)
+}
+
+func (l *LudusV2Client) PowerOn(userId string) (LudusResult, error) {
+	payload := gin.H{"machines": []string{"all"}}
+	return l.requestResult("PUT", l.BaseURL+"/range/poweron"+rangeQuery(userId), payload)
+}
+
+func (l *LudusV2Client) PowerOff(userId string) (LudusResult, error) {
+	payload := gin.H{"machines": []string{"all"}}
+	return l.requestResult("PUT", l.BaseURL+"/range/poweroff"+rangeQuery(userId), payload)
+}
+
+func (l *LudusV2Client) StartTesting(userId string) (LudusResult, error) {
+	return l.requestResult("PUT", l.BaseURL+"/testing/start"+rangeQuery(userId), nil)
+}
+
+func (l *LudusV2Client) StopTesting(userId string) (LudusResult, error) {
+	payload := gin.H{"force": true}
+	return l.requestResult("PUT", l.BaseURL+"/testing/stop"+rangeQuery(userId), payload)
+}
+
+func (l *LudusV2Client) GetConfig(userId string) (string, error) {
+	return l.resultString(l.BaseURL + "/range/config" + rangeQuery(userId))
+}
+
+func (l *LudusV2Client) PutConfig(userId, content string, force bool) (LudusResult, error) {
+	return l.putConfig(l.BaseURL+"/range/config"+rangeQuery(userId), content, force)
+}
+
+func (l *LudusV2Client) GetLogs(userId string) (string, error) {
+	return l.resultString(l.BaseURL + "/range/logs" + rangeQuery(userId))
+}
+
+// GetRangeAccess rebuilds the 1.x access list from the ranges each user can
+// reach, since 2.x has no endpoint listing all shares at once. Each entry names
+// a range owner and the users who were granted access to that range
+func (l *LudusV2Client) GetRangeAccess() ([]LudusRangeAccess, error) {
+	users, err := l.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	userIds := make([]string, len(users))
+	for i, user := range users {
+		userIds[i] = user.UserID
+	}
+
+	responses := RunConcurrentTasks(UserTasks(userIds, func(userId string) (interface{}, error) {
+		var ranges []ludusAccessibleRange
+		err := l.requestJSON("GET", l.BaseURL+"/ranges/accessible"+userQuery(userId), nil, &ranges)
+		return ranges, err
+	}), 0)
+
+	// Group the users with access by the owner of each range, keeping owners
+	// in the order they were first seen
+	var owners []string
+	sourcesByOwner := make(map[string][]string)
+	for _, resp := range responses {
+		if resp.Error != nil {
+			return nil, resp.Error
+		}
+
+		for _, r := range resp.Response.([]ludusAccessibleRange) {
+			// The user's own default range is not a share
+			if r.RangeID == rangeIdFor(resp.UserID) {
+				continue
+			}
+			if _, seen := sourcesByOwner[r.RangeID]; !seen {
+				owners = append(owners, r.RangeID)
+			}
+			sourcesByOwner[r.RangeID] = append(sourcesByOwner[r.RangeID], resp.UserID)
+		}
+	}
+
+	access := make([]LudusRangeAccess, 0, len(owners))
+	for _, owner := range owners {
+		access = append(access, LudusRangeAccess{TargetUserID: owner, SourceUserIDs: sourcesByOwner[owner]})
+	}
+	return access, nil
+}
+
+// GrantAccess assigns sourceUserId to the default range of targetUserId
+func (l *LudusV2Client) GrantAccess(targetUserId, sourceUserId string) (LudusResult, error) {
+	endpoint := "/ranges/assign/" + url.PathEscape(sourceUserId) + "/" + url.PathEscape(rangeIdFor(targetUserId))
+	return l.requestIdempotentResult("POST", l.BaseURL+endpoint, nil)
+}
+
+// RevokeAccess removes sourceUserId from the default range of targetUserId
+func (l *LudusV2Client) RevokeAccess(targetUserId, sourceUserId string) (LudusResult, error) {
+	endpoint := "/ranges/revoke/" + url.PathEscape(sourceUserId) + "/" + url.PathEscape(rangeIdFor(targetUserId))
+	return l.requestIdempotentResult("DELETE", l.BaseURL+endpoint, nil)
+}
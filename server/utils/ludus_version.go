@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"dulus/server/config"
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Ludus API generations the client can talk to
+const (
+	LudusApiV1 = 1
+	LudusApiV2 = 2
+)
+
+var ludusVersionPattern = regexp.MustCompile(`v?(\d+)\.\d+`)
+
+// Detected Ludus API generation, 0 until known. While a detection runs or after
+// one failed, other callers do not wait for it but assume 1.x until
+// ludusApiRetryAt; each failure doubles the wait up to ludusApiMaxRetryDelay.
+var (
+	ludusApiVersion      int
+	ludusApiDetecting    bool
+	ludusApiRetryAt      time.Time
+	ludusApiRetryDelay   time.Duration
+	ludusApiVersionMutex sync.Mutex
+)
+
+// Wait before detecting the Ludus version again after a failure, doubling up to the maximum
+const (
+	ludusApiMinRetryDelay = 5 * time.Second
+	ludusApiMaxRetryDelay = 5 * time.Minute
+)
+
+// ParseLudusMajorVersion extracts the major version from a Ludus version string
+// such as "Ludus Server v2.0.1+abc123a"
+func ParseLudusMajorVersion(version string) (int, error) {
+	match := ludusVersionPattern.FindStringSubmatch(version)
+	if match == nil {
+		return 0, fmt.Errorf("unrecognized Ludus version %q", version)
+	}
+	return strconv.Atoi(match[1])
+}
+
+// configuredLudusApiVersion returns the version forced by LUDUS_API_VERSION, or 0 for auto detection
+func configuredLudusApiVersion() int {
+	switch config.LudusApiVersion {
+	case "1":
+		return LudusApiV1
+	case "2":
+		return LudusApiV2
+	}
+	return 0
+}
+
+// DetectLudusApiVersion determines which API generation the Ludus server speaks,
+// either from LUDUS_API_VERSION or by asking the server with client
+func DetectLudusApiVersion(client *HTTPLudusClient) (int, error) {
+	if version := configuredLudusApiVersion(); version != 0 {
+		return version, nil
+	}
+
+	versionStr, err := client.GetVersion()
+	if err != nil {
+		return 0, err
+	}
+	major, err := ParseLudusMajorVersion(versionStr)
+	if err != nil {
+		return 0, err
+	}
+	if major >= LudusApiV2 {
+		return LudusApiV2, nil
+	}
+	return LudusApiV1, nil
+}
+
+// InitLudusApiVersion detects the Ludus version at startup with the service API
+// key. Without the key detection happens on the first request instead.
+func InitLudusApiVersion() {
+	if configuredLudusApiVersion() == 0 && config.LudusServiceApiKey == "" {
+		log.Println("Ludus API version will be detected on the first request")
+		return
+	}
+
+	client := NewHTTPLudusClient(config.LudusUrl, config.LudusAdminUrl, config.LudusServiceApiKey)
+	if version := ludusApiVersionFor(client); version != 0 {
+		log.Printf("Using Ludus %d.x API", version)
+	}
+}
+
+// ludusApiVersionFor returns the detected API generation, detecting it with
+// client if it is not known yet. Only one caller detects at a time, without
+// holding the lock; the others and the callers during the backoff after a
+// failed detection are treated as 1.x.
+func ludusApiVersionFor(client *HTTPLudusClient) int {
+	ludusApiVersionMutex.Lock()
+	if ludusApiVersion != 0 {
+		defer ludusApiVersionMutex.Unlock()
+		return ludusApiVersion
+	}
+	if ludusApiDetecting || time.Now().Before(ludusApiRetryAt) {
+		ludusApiVersionMutex.Unlock()
+		return LudusApiV1
+	}
+	ludusApiDetecting = true
+	ludusApiVersionMutex.Unlock()
+
+	version, err := DetectLudusApiVersion(client)
+
+	ludusApiVersionMutex.Lock()
+	defer ludusApiVersionMutex.Unlock()
+	ludusApiDetecting = false
+	if err != nil {
+		ludusApiRetryDelay = min(max(2*ludusApiRetryDelay, ludusApiMinRetryDelay), ludusApiMaxRetryDelay)
+		ludusApiRetryAt = time.Now().Add(ludusApiRetryDelay)
+		log.Printf("Failed to detect Ludus API version, assuming 1.x for %s: %v", ludusApiRetryDelay, err)
+		return LudusApiV1
+	}
+	ludusApiVersion = version
+	return version
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseLudusMajorVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		major   int
+	}{
+		{version: "Ludus Server v2.0.1+abc123a", major: 2},
+		{version: "Ludus Server v1.11.4+0a2cd9e", major: 1},
+		{version: "1.0", major: 1},
+	}
+	for _, tt := range tests {
+		if major, err := ParseLudusMajorVersion(tt.version); err != nil || major != tt.major {
+			t.Errorf("%q: expected %d, got %d (%v)", tt.version, tt.major, major, err)
+		}
+	}
+	if _, err := ParseLudusMajorVersion("Ludus Server"); err == nil {
+		t.Error("expected an error without a version number")
+	}
+}
+
+func TestLudusApiVersionBacksOffAfterFailure(t *testing.T) {
+	t.Cleanup(func() {
+		ludusApiVersion, ludusApiRetryAt, ludusApiRetryDelay = 0, time.Time{}, 0
+	})
+
+	var requests atomic.Int32
+	var available atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if !available.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(`{"result": "Ludus Server v2.0.1+abc123a"}`))
+	}))
+	defer server.Close()
+	client := &HTTPLudusClient{BaseURL: server.URL, HTTPClient: server.Client(), Timeout: time.Second}
+
+	if version := ludusApiVersionFor(client); version != LudusApiV1 {
+		t.Fatalf("expected 1.x after a failed detection, got %d", version)
+	}
+	if version := ludusApiVersionFor(client); version != LudusApiV1 || requests.Load() != 1 {
+		t.Fatalf("expected 1.x without asking Ludus again, got %d after %d requests", version, requests.Load())
+	}
+
+	// Once the backoff has passed the version is detected again
+	available.Store(true)
+	ludusApiVersionMutex.Lock()
+	ludusApiRetryAt = time.Now()
+	ludusApiVersionMutex.Unlock()
+	if version := ludusApiVersionFor(client); version != LudusApiV2 {
+		t.Fatalf("expected 2.x once Ludus answers, got %d", version)
+	}
+	if version := ludusApiVersionFor(client); version != LudusApiV2 || requests.Load() != 2 {
+		t.Fatalf("expected the cached 2.x, got %d after %d requests", version, requests.Load())
+	}
+}
@@ -0,0 +1,397 @@
+package utils
+
+import (
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore keeps everything in maps, for tests that should not need a data
+// directory. It enforces the same pool rules as the other stores.
+type MemoryStore struct {
+	mutex      sync.RWMutex
+	pools      map[string]Pool
+	topologies map[string]StoredFile
+	scenarios  map[string]StoredFile
+	ctfdData   map[string]CtfdData
+	history    map[string][]PoolHistoryEntry
+	templates  map[string]PoolTemplate
+	roles      map[string]RoleAssignment
+
+	// writeMutex serializes writes only, so an update callback may still read the store
+	writeMutex sync.Mutex
+}
+
+// NewMemoryStore creates an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		pools:      make(map[string]Pool),
+		topologies: make(map[string]StoredFile),
+		scenarios:  make(map[string]StoredFile),
+		ctfdData:   make(map[string]CtfdData),
+		history:    make(map[string][]PoolHistoryEntry),
+		templates:  make(map[string]PoolTemplate),
+		roles:      make(map[string]RoleAssignment),
+	}
+}
+
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// newId returns a random id that is not a key of items
+func newId[T any](items map[string]T) string {
+	for {
+		id := RandomString(6)
+		if _, exists := items[id]; !exists {
+			return id
+		}
+	}
+}
+
+func (s *MemoryStore) allPools() []Pool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	pools := make([]Pool, 0, len(s.pools))
+	for _, pool := range s.pools {
+		pools = append(pools, copyPool(pool))
+	}
+	return pools
+}
+
+func (s *MemoryStore) CreatePool(pool Pool) (string, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	s.mutex.RLock()
+	for {
+		pool.Id = newId(s.pools)
+		if _, used := s.history[pool.Id]; !used {
+			break
+		}
+	}
+	s.mutex.RUnlock()
+	if pool.CreatedAt.IsZero() {
+		pool.CreatedAt = time.Now()
+	}
+	pool.Revision = 1
+
+	if err := checkPoolRules(pool, s.allPools()); err != nil {
+		return "", err
+	}
+
+	s.mutex.Lock()
+	s.pools[pool.Id] = copyPool(pool)
+	s.mutex.Unlock()
+	return pool.Id, nil
+}
+
+func (s *MemoryStore) GetPool(poolId string) (Pool, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	pool, exists := s.pools[poolId]
+	if !exists {
+		return Pool{}, ErrPoolNotFound
+	}
+	return copyPool(pool), nil
+}
+
+func (s *MemoryStore) UpdatePool(poolId string, update func(pool *Pool) error) (Pool, error) {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+
+	pool, err := s.GetPool(poolId)
+	if err != nil {
+		return Pool{}, err
+	}
+	revision := pool.Revision
+	if err := update(&pool); err != nil {
+		return Pool{}, err
+	}
+	pool.Id = poolId
+	pool.Revision = revision + 1
+
+	if err := checkPoolRules(pool, s.allPools()); err != nil {
+		return Pool{}, err
+	}
+
+	s.mutex.Lock()
+	s.pools[poolId] = copyPool(pool)
+	s.mutex.Unlock()
+	return pool, nil
+}
+
+func (s *MemoryStore) DeletePool(poolId string) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.pools[poolId]; !exists {
+		return ErrPoolNotFound
+	}
+	delete(s.pools, poolId)
+	delete(s.ctfdData, poolId)
+	return nil
+}
+
+func (s *MemoryStore) AddPoolHistory(entry PoolHistoryEntry) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if entry.Pool != nil {
+		snapshot := copyPool(*entry.Pool)
+		entry.Pool = &snapshot
+	}
+	s.history[entry.PoolId] = append(s.history[entry.PoolId], entry)
+	return nil
+}
+
+func (s *MemoryStore) GetPoolHistory(poolId string) ([]PoolHistoryEntry, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	return append([]PoolHistoryEntry(nil), s.history[poolId]...), nil
+}
+
+func (s *MemoryStore) ListPools() ([]Pool, error) {
+	return s.allPools(), nil
+}
+
+func (s *MemoryStore) MainUserIds() (map[string]bool, error) {
+	return poolMainUserIds(s.allPools()), nil
+}
+
+func (s *MemoryStore) PoolUserIds() (map[string]bool, error) {
+	return poolAllUserIds(s.allPools()), nil
+}
+
+func (s *MemoryStore) AnyUserInPools(userIds []string) (bool, error) {
+	return anyIdIn(userIds, poolAllUserIds(s.allPools())), nil
+}
+
+// saveFile stores an item file, replacing an existing one or creating a new item for an empty id
+func (s *MemoryStore) saveFile(items map[string]StoredFile, id, fileName, mode string, content []byte) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if id == "" {
+		id = newId(items)
+	} else if _, exists := items[id]; !exists {
+		return "", ErrNotFound
+	}
+
+	items[id] = StoredFile{
+		StoredItem: StoredItem{Id: id, Name: filepath.Base(fileName), Mode: mode, CreatedAt: time.Now()},
+		Content:    append([]byte(nil), content...),
+	}
+	return id, nil
+}
+
+func (s *MemoryStore) getFile(items map[string]StoredFile, id string) (StoredFile, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	item, exists := items[id]
+	if !exists {
+		return StoredFile{}, ErrNotFound
+	}
+	return item, nil
+}
+
+func (s *MemoryStore) listFiles(items map[string]StoredFile) []StoredItem {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	list := make([]StoredItem, 0, len(items))
+	for _, item := range items {
+		list = append(list, item.StoredItem)
+	}
+	return list
+}
+
+func (s *MemoryStore) SaveTopology(topologyId, fileName string, content []byte) (string, error) {
+	return s.saveFile(s.topologies, topologyId, fileName, "", content)
+}
+
+func (s *MemoryStore) GetTopology(topologyId string) (StoredFile, error) {
+	return s.getFile(s.topologies, topologyId)
+}
+
+func (s *MemoryStore) ListTopologies() ([]StoredItem, error) {
+	return s.listFiles(s.topologies), nil
+}
+
+func (s *MemoryStore) DeleteTopology(topologyId string) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.topologies[topologyId]; !exists {
+		return ErrNotFound
+	}
+	for _, pool := range s.pools {
+		if pool.TopologyId == topologyId {
+			return ErrTopologyInUse
+		}
+	}
+	for _, template := range s.templates {
+		if template.TopologyId == topologyId {
+			return ErrTopologyInUse
+		}
+	}
+	delete(s.topologies, topologyId)
+	return nil
+}
+
+func (s *MemoryStore) SaveScenario(scenarioId, fileName, scenarioMode string, content []byte) (string, error) {
+	return s.saveFile(s.scenarios, scenarioId, fileName, scenarioMode, content)
+}
+
+func (s *MemoryStore) GetScenario(scenarioId string) (StoredFile, error) {
+	return s.getFile(s.scenarios, scenarioId)
+}
+
+func (s *MemoryStore) ListScenarios() ([]StoredItem, error) {
+	return s.listFiles(s.scenarios), nil
+}
+
+func (s *MemoryStore) DeleteScenario(scenarioId string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.scenarios[scenarioId]; !exists {
+		return ErrNotFound
+	}
+	delete(s.scenarios, scenarioId)
+	return nil
+}
+
+func (s *MemoryStore) GetCtfdData(poolId string) (CtfdData, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	data, exists := s.ctfdData[poolId]
+	if !exists {
+		return CtfdData{}, ErrNotFound
+	}
+	return data, nil
+}
+
+func (s *MemoryStore) SaveCtfdData(poolId string, data CtfdData) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.ctfdData[poolId] = data
+	return nil
+}
+
+func (s *MemoryStore) DeleteCtfdData(poolId string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	delete(s.ctfdData, poolId)
+	return nil
+}
+
+func (s *MemoryStore) HasCtfdData(poolId string) bool {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	_, exists := s.ctfdData[poolId]
+	return exists
+}
+
+func (s *MemoryStore) CreateTemplate(template PoolTemplate) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	template.Id = newId(s.templates)
+	if template.CreatedAt.IsZero() {
+		template.CreatedAt = time.Now()
+	}
+	template.Teams = append([]string(nil), template.Teams...)
+	s.templates[template.Id] = template
+	return template.Id, nil
+}
+
+func (s *MemoryStore) GetTemplate(templateId string) (PoolTemplate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	template, exists := s.templates[templateId]
+	if !exists {
+		return PoolTemplate{}, ErrNotFound
+	}
+	template.Teams = append([]string(nil), template.Teams...)
+	return template, nil
+}
+
+func (s *MemoryStore) ListTemplates() ([]PoolTemplate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	templates := make([]PoolTemplate, 0, len(s.templates))
+	for _, template := range s.templates {
+		template.Teams = append([]string(nil), template.Teams...)
+		templates = append(templates, template)
+	}
+	return templates, nil
+}
+
+func (s *MemoryStore) DeleteTemplate(templateId string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.templates[templateId]; !exists {
+		return ErrNotFound
+	}
+	delete(s.templates, templateId)
+	return nil
+}
+
+func (s *MemoryStore) SetRole(assignment RoleAssignment) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.roles[assignment.UserId] = assignment
+	return nil
+}
+
+func (s *MemoryStore) GetRole(userId string) (RoleAssignment, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	assignment, exists := s.roles[userId]
+	if !exists {
+		return RoleAssignment{}, ErrNotFound
+	}
+	return assignment, nil
+}
+
+func (s *MemoryStore) ListRoles() ([]RoleAssignment, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	assignments := make([]RoleAssignment, 0, len(s.roles))
+	for _, assignment := range s.roles {
+		assignments = append(assignments, assignment)
+	}
+	sort.Slice(assignments, func(i, j int) bool { return assignments[i].UserId < assignments[j].UserId })
+	return assignments, nil
+}
+
+func (s *MemoryStore) DeleteRole(userId string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, exists := s.roles[userId]; !exists {
+		return ErrNotFound
+	}
+	delete(s.roles, userId)
+	return nil
+}
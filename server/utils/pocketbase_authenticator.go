@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// pocketbaseRequestTimeout limits a single call to the Pocketbase API
+const pocketbaseRequestTimeout = 10 * time.Second
+
+// pocketbaseUserIdRegex matches the userIds that can be put into a records
+// filter; Ludus userIds are alphanumeric
+var pocketbaseUserIdRegex = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// PocketbaseUserFields names the collection Ludus 2.x keeps its users in and the
+// fields holding a user's id, admin flag and API key hash
+type PocketbaseUserFields struct {
+	Collection string
+	UserId     string
+	IsAdmin    string
+	APIKey     string
+}
+
+// validate checks that all names can be used in queries and filters
+func (f PocketbaseUserFields) validate() error {
+	for _, name := range []string{f.Collection, f.UserId, f.IsAdmin, f.APIKey} {
+		if !validIdentifierRegex.MatchString(name) {
+			return fmt.Errorf("invalid Pocketbase collection or field name %q", name)
+		}
+	}
+	return nil
+}
+
+// PocketbaseFileAuthenticator reads users from the SQLite data file of Pocketbase
+// (pb_data/data.db), which keeps every collection in a table of its name
+type PocketbaseFileAuthenticator struct {
+	sqliteUserLookup
+}
+
+// OpenPocketbaseFileAuthenticator opens the Pocketbase data file at path read-only
+func OpenPocketbaseFileAuthenticator(path string, fields PocketbaseUserFields) (*PocketbaseFileAuthenticator, error) {
+	if err := fields.validate(); err != nil {
+		return nil, err
+	}
+	db, err := openReadOnlySQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	return &PocketbaseFileAuthenticator{sqliteUserLookup{
+		db: db,
+		query: fmt.Sprintf(`SELECT "%s", "%s" FROM "%s" WHERE "%s" = ?`,
+			fields.IsAdmin, fields.APIKey, fields.Collection, fields.UserId),
+	}}, nil
+}
+
+// PocketbaseHTTPAuthenticator looks up users through the Pocketbase records API
+// as a superuser. The superuser token is requested on first use and again when
+// Pocketbase rejects it.
+type PocketbaseHTTPAuthenticator struct {
+	client   *http.Client
+	baseUrl  string
+	email    string
+	password string
+	fields   PocketbaseUserFields
+
+	tokenMutex sync.Mutex
+	token      string
+}
+
+// NewPocketbaseHTTPAuthenticator creates an authenticator for the Pocketbase API
+// at baseUrl that logs in as the superuser email
+func NewPocketbaseHTTPAuthenticator(client *http.Client, baseUrl, email, password string, fields PocketbaseUserFields) (*PocketbaseHTTPAuthenticator, error) {
+	if err := fields.validate(); err != nil {
+		return nil, err
+	}
+	return &PocketbaseHTTPAuthenticator{
+		client:   client,
+		baseUrl:  baseUrl,
+		email:    email,
+		password: password,
+		fields:   fields,
+	}, nil
+}
+
+// LookupUser finds the record of userId in the users collection
+func (a *PocketbaseHTTPAuthenticator) LookupUser(ctx context.Context, userId string) (AuthUser, error) {
+	if !pocketbaseUserIdRegex.MatchString(userId) {
+		return AuthUser{}, ErrUnknownUser
+	}
+
+	query := url.Values{
+		"filter":    {fmt.Sprintf("%s='%s'", a.fields.UserId, userId)},
+		"perPage":   {"1"},
+		"skipTotal": {"1"},
+	}
+	endpoint := a.baseUrl + "/api/collections/" + a.fields.Collection + "/records?" + query.Encode()
+
+	var page struct {
+		Items []map[string]interface{} `json:"items"`
+	}
+	for renew := false; ; renew = true {
+		token, err := a.superuserToken(ctx, renew)
+		if err != nil {
+			return AuthUser{}, err
+		}
+		status, err := a.request(ctx, http.MethodGet, endpoint, token, nil, &page)
+		if err != nil {
+			return AuthUser{}, err
+		}
+		if (status == http.StatusUnauthorized || status == http.StatusForbidden) && !renew {
+			continue
+		}
+		if status != http.StatusOK {
+			return AuthUser{}, fmt.Errorf("pocketbase answered the user lookup with status %d", status)
+		}
+		break
+	}
+
+	if len(page.Items) == 0 {
+		return AuthUser{}, ErrUnknownUser
+	}
+	record := page.Items[0]
+	hashedAPIKey, _ := record[a.fields.APIKey].(string)
+	if hashedAPIKey == "" {
+		return AuthUser{}, fmt.Errorf("pocketbase returned no %s for user %s", a.fields.APIKey, userId)
+	}
+	isAdmin, _ := record[a.fields.IsAdmin].(bool)
+	return AuthUser{UserId: userId, IsAdmin: isAdmin, HashedAPIKey: hashedAPIKey}, nil
+}
+
+// superuserToken returns the superuser token, logging in first if there is none
+// yet or renew is set
+func (a *PocketbaseHTTPAuthenticator) superuserToken(ctx context.Context, renew bool) (string, error) {
+	a.tokenMutex.Lock()
+	defer a.tokenMutex.Unlock()
+
+	if a.token != "" && !renew {
+		return a.token, nil
+	}
+
+	var auth struct {
+		Token string `json:"token"`
+	}
+	credentials := map[string]string{"identity": a.email, "password": a.password}
+	status, err := a.request(ctx, http.MethodPost, a.baseUrl+"/api/collections/_superusers/auth-with-password", "", credentials, &auth)
+	if err != nil {
+		return "", err
+	}
+	if status != http.StatusOK || auth.Token == "" {
+		return "", fmt.Errorf("pocketbase superuser login failed with status %d", status)
+	}
+	a.token = auth.Token
+	return a.token, nil
+}
+
+// request calls the Pocketbase API and decodes a 200 response into out
+func (a *PocketbaseHTTPAuthenticator) request(ctx context.Context, method, endpoint, token string, body, out interface{}) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, pocketbaseRequestTimeout)
+	defer cancel()
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Close has nothing to release, connections belong to the shared client
+func (a *PocketbaseHTTPAuthenticator) Close() error {
+	return nil
+}
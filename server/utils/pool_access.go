@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PoolAccess is what a request does with a pool. The owner of a pool (the user
+// who created it) may do everything, its co-instructors everything but changing
+// the access lists and deleting it, and its observers only view it. Admins may
+// do everything with every pool.
+type PoolAccess int
+
+const (
+	// PoolAccessView reads a pool, its ranges, jobs and schedules
+	PoolAccessView PoolAccess = iota
+	// PoolAccessManage changes a pool, deploys its ranges and reads its secrets
+	PoolAccessManage
+	// PoolAccessOwner changes the access lists of a pool and deletes it
+	PoolAccessOwner
+)
+
+// CanAccessPool reports whether the caller of c may access pool as asked
+func CanAccessPool(c *gin.Context, pool Pool, access PoolAccess) bool {
+	userId := c.GetString("userID")
+	switch {
+	case c.GetString("role") == RoleAdmin || pool.CreatedBy == userId:
+		return true
+	case slices.Contains(pool.CoInstructors, userId):
+		return access <= PoolAccessManage
+	case slices.Contains(pool.Observers, userId):
+		return access == PoolAccessView
+	}
+	return false
+}
+
+// poolForAccess returns the pool whose access lists apply to poolId: the pool
+// itself, or for a deleted pool its last recorded state
+func poolForAccess(poolId string) (Pool, error) {
+	pool, err := store.GetPool(poolId)
+	if !errors.Is(err, ErrNotFound) {
+		return pool, err
+	}
+
+	history, historyErr := store.GetPoolHistory(poolId)
+	if historyErr != nil {
+		return Pool{}, historyErr
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Pool != nil {
+			return *history[i].Pool, nil
+		}
+	}
+	return Pool{}, err
+}
+
+// AuthorizePoolWithResponse checks that the caller of c may access the pool
+// poolId as asked and responds with 403 if not. Unknown pools are let through,
+// the handler answers them with 404.
+func AuthorizePoolWithResponse(c *gin.Context, poolId string, access PoolAccess) bool {
+	if !validFolderIDRegex.MatchString(poolId) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return false
+	}
+
+	pool, err := poolForAccess(poolId)
+	if errors.Is(err, ErrNotFound) {
+		return true
+	}
+	if err != nil {
+		WriteStoreError(c, err)
+		return false
+	}
+
+	if !CanAccessPool(c, pool, access) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+		return false
+	}
+	return true
+}
+
+// RequirePoolAccess returns a middleware that lets a request with a poolId query
+// parameter through only if the caller may access that pool as asked. It runs
+// after RequirePermission, which sets the caller's role. Requests without poolId
+// are left to the handler.
+func RequirePoolAccess(access PoolAccess) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		poolId := c.Query("poolId")
+		if poolId == "" {
+			return
+		}
+		if !AuthorizePoolWithResponse(c, poolId, access) {
+			c.Abort()
+		}
+	}
+}
+
+// PoolAccessFilter returns a function reporting whether the caller of c may
+// access the pool poolId as asked, for filtering lists of jobs and schedules.
+// Every pool is looked up once.
+func PoolAccessFilter(c *gin.Context, access PoolAccess) func(poolId string) bool {
+	allowed := make(map[string]bool)
+	return func(poolId string) bool {
+		if result, seen := allowed[poolId]; seen {
+			return result
+		}
+		pool, err := poolForAccess(poolId)
+		allowed[poolId] = err == nil && CanAccessPool(c, pool, access)
+		return allowed[poolId]
+	}
+}
+
+// PoolAccessResponse returns the owner and access lists of a pool for a response
+func PoolAccessResponse(pool Pool) gin.H {
+	return gin.H{
+		"owner":         pool.CreatedBy,
+		"coInstructors": nonNilIds(pool.CoInstructors),
+		"observers":     nonNilIds(pool.Observers),
+	}
+}
+
+// nonNilIds returns ids, an empty list instead of nil
+func nonNilIds(ids []string) []string {
+	if ids == nil {
+		return []string{}
+	}
+	return ids
+}
+
+// SetPoolAccess replaces the access lists of a pool that are not nil. The owner
+// cannot be on them and nobody on both. Errors wrap ErrInvalidPool.
+func SetPoolAccess(pool *Pool, coInstructors, observers []string) error {
+	if coInstructors != nil {
+		pool.CoInstructors = coInstructors
+	}
+	if observers != nil {
+		pool.Observers = observers
+	}
+
+	for _, userId := range pool.CoInstructors {
+		if userId == pool.CreatedBy {
+			return fmt.Errorf("%w: %s owns the pool", ErrInvalidPool, userId)
+		}
+		if slices.Contains(pool.Observers, userId) {
+			return fmt.Errorf("%w: %s cannot be co-instructor and observer", ErrInvalidPool, userId)
+		}
+	}
+	if slices.Contains(pool.Observers, pool.CreatedBy) {
+		return fmt.Errorf("%w: %s owns the pool", ErrInvalidPool, pool.CreatedBy)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package utils
+
+import (
+	"log"
+	"slices"
+	"time"
+)
+
+// Actions recorded in the pool history
+const (
+	PoolActionCreate         = "create"
+	PoolActionTopology       = "topology"
+	PoolActionNote           = "note"
+	PoolActionUsers          = "users"
+	PoolActionUsersRemove    = "users_remove"
+	PoolActionUserUpdate     = "user_update"
+	PoolActionRestore        = "restore"
+	PoolActionAccess         = "access"
+	PoolActionDelete         = "delete"
+	PoolActionCtfdData       = "ctfd_data"
+	PoolActionCtfdDataDelete = "ctfd_data_delete"
+)
+
+// PoolHistoryEntry is one recorded change of a pool. Revision is the pool
+// revision after the change (the last one for a delete) and Pool the pool as
+// it was then, nil after a delete.
+type PoolHistoryEntry struct {
+	PoolId    string       `json:"poolId"`
+	Revision  int          `json:"revision"`
+	Action    string       `json:"action"`
+	UserId    string       `json:"userId"`
+	Timestamp time.Time    `json:"timestamp"`
+	Changes   []PoolChange `json:"changes"`
+	Pool      *Pool        `json:"pool"`
+}
+
+// PoolChange is the before and after value of one changed field. Changes of
+// members have the field "usersAndTeams" and the member's userId as key; a
+// nil before or after means the member was added or removed.
+type PoolChange struct {
+	Field  string      `json:"field"`
+	Key    string      `json:"key,omitempty"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// DiffPools lists the differences between two states of a pool. A nil before
+// is a created pool, a nil after a deleted one.
+func DiffPools(before, after *Pool) []PoolChange {
+	changes := []PoolChange{}
+
+	fields := []struct {
+		name  string
+		value func(pool *Pool) string
+	}{
+		{"type", func(pool *Pool) string { return pool.Type }},
+		{"topologyId", func(pool *Pool) string { return pool.TopologyId }},
+		{"note", func(pool *Pool) string { return pool.Note }},
+	}
+	for _, field := range fields {
+		var beforeValue, afterValue interface{}
+		if before != nil {
+			beforeValue = field.value(before)
+		}
+		if after != nil {
+			afterValue = field.value(after)
+		}
+		if beforeValue != afterValue {
+			changes = append(changes, PoolChange{Field: field.name, Before: beforeValue, After: afterValue})
+		}
+	}
+
+	accessLists := []struct {
+		name  string
+		value func(pool *Pool) []string
+	}{
+		{"coInstructors", func(pool *Pool) []string { return nonNilIds(pool.CoInstructors) }},
+		{"observers", func(pool *Pool) []string { return nonNilIds(pool.Observers) }},
+	}
+	for _, list := range accessLists {
+		var beforeValue, afterValue []string
+		if before != nil {
+			beforeValue = list.value(before)
+		}
+		if after != nil {
+			afterValue = list.value(after)
+		}
+		if !slices.Equal(beforeValue, afterValue) {
+			changes = append(changes, PoolChange{Field: list.name, Before: beforeValue, After: afterValue})
+		}
+	}
+
+	afterUsers := make(map[string]PoolUser)
+	if after != nil {
+		for _, user := range after.UsersAndTeams {
+			afterUsers[user.UserId] = user
+		}
+	}
+	beforeUsers := make(map[string]bool)
+	if before != nil {
+		for _, user := range before.UsersAndTeams {
+			beforeUsers[user.UserId] = true
+			afterUser, exists := afterUsers[user.UserId]
+			if !exists {
+				changes = append(changes, PoolChange{Field: "usersAndTeams", Key: user.UserId, Before: user, After: nil})
+			} else if afterUser != user {
+				changes = append(changes, PoolChange{Field: "usersAndTeams", Key: user.UserId, Before: user, After: afterUser})
+			}
+		}
+	}
+	if after != nil {
+		for _, user := range after.UsersAndTeams {
+			if !beforeUsers[user.UserId] {
+				changes = append(changes, PoolChange{Field: "usersAndTeams", Key: user.UserId, Before: nil, After: user})
+			}
+		}
+	}
+
+	return changes
+}
+
+// NewPoolHistoryEntry describes the change of a pool from before to after by userId
+func NewPoolHistoryEntry(poolId, action, userId string, before, after *Pool) PoolHistoryEntry {
+	entry := PoolHistoryEntry{
+		PoolId:    poolId,
+		Action:    action,
+		UserId:    userId,
+		Timestamp: time.Now(),
+		Changes:   DiffPools(before, after),
+	}
+	if after != nil {
+		snapshot := copyPool(*after)
+		entry.Pool = &snapshot
+		entry.Revision = after.Revision
+	} else if before != nil {
+		entry.Revision = before.Revision
+	}
+	return entry
+}
+
+// RecordPoolHistory stores a history entry. The change itself already happened,
+// so a failure is only logged.
+func RecordPoolHistory(entry PoolHistoryEntry) {
+	if err := store.AddPoolHistory(entry); err != nil {
+		log.Printf("Failed to record %s of pool %s in its history: %v", entry.Action, entry.PoolId, err)
+	}
+}
+
+// recordCtfdDataChange records that the CTFd data of a pool was generated or deleted
+func recordCtfdDataChange(poolId, action, userId string, exists bool) {
+	pool, err := store.GetPool(poolId)
+	if err != nil {
+		return
+	}
+	entry := NewPoolHistoryEntry(poolId, action, userId, &pool, &pool)
+	entry.Changes = append(entry.Changes, PoolChange{Field: "ctfdData", Before: !exists, After: exists})
+	RecordPoolHistory(entry)
+}
+
+// PoolHistory returns the recorded changes of a pool, oldest first
+func PoolHistory(poolId string) ([]PoolHistoryEntry, error) {
+	return store.GetPoolHistory(poolId)
+}
+
+// PoolAtRevision returns the pool as recorded at revision, or ErrNotFound
+func PoolAtRevision(poolId string, revision int) (Pool, error) {
+	history, err := store.GetPoolHistory(poolId)
+	if err != nil {
+		return Pool{}, err
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Revision == revision && history[i].Pool != nil {
+			return copyPool(*history[i].Pool), nil
+		}
+	}
+	return Pool{}, ErrNotFound
+}
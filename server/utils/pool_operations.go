@@ -3,120 +3,224 @@ package utils
 import (
 	"dulus/server/config"
 	"encoding/json"
+	"fmt"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
-// ReadPoolInternal reads pool data without HTTP handling (for internal use)
-func ReadPoolInternal(poolPath string) (Pool, error) {
-	filePath := filepath.Join(poolPath, "pool.json")
-	file, err := os.Open(filePath)
-	if err != nil {
-		return Pool{}, err
+// ReadPoolById reads a pool by its id without HTTP handling (for background work)
+func ReadPoolById(poolId string) (Pool, error) {
+	if !validFolderIDRegex.MatchString(poolId) {
+		return Pool{}, fmt.Errorf("invalid pool id %q", poolId)
 	}
-	defer file.Close()
+	return store.GetPool(poolId)
+}
 
-	var pool Pool
-	if err := json.NewDecoder(file).Decode(&pool); err != nil {
-		return Pool{}, err
+// ReadPoolWithResponse reads pool data and handles HTTP responses
+func ReadPoolWithResponse(c *gin.Context, poolId string) (Pool, bool) {
+	if !validFolderIDRegex.MatchString(poolId) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return Pool{}, false
 	}
-	return pool, nil
+
+	pool, err := store.GetPool(poolId)
+	if err != nil {
+		WriteStoreError(c, err)
+		return Pool{}, false
+	}
+	return pool, true
 }
 
-// ReadPoolWithResponse reads pool data and handles HTTP responses
-func ReadPoolWithResponse(c *gin.Context, poolPath string) (Pool, bool) {
-	pool, err := ReadPoolInternal(poolPath)
+// ValidatePoolIdFormat checks that poolId is a well-formed id, without reading the pool
+func ValidatePoolIdFormat(c *gin.Context, poolId string) bool {
+	return validStoreId(c, poolId)
+}
+
+// ValidatePoolId checks that the pool exists
+func ValidatePoolId(c *gin.Context, poolId string) bool {
+	_, ok := ReadPoolWithResponse(c, poolId)
+	return ok
+}
+
+// CreatePoolWithResponse stores a new pool, records it in the pool history and handles HTTP responses
+func CreatePoolWithResponse(c *gin.Context, pool Pool) (string, bool) {
+	poolId, err := store.CreatePool(pool)
 	if err != nil {
-		if os.IsNotExist(err) {
-			c.JSON(http.StatusNotFound, gin.H{"error": "Pool not found"})
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+		WriteStoreError(c, err)
+		return "", false
+	}
+
+	if created, err := store.GetPool(poolId); err == nil {
+		RecordPoolHistory(NewPoolHistoryEntry(poolId, PoolActionCreate, c.GetString("userID"), nil, &created))
+	}
+	return poolId, true
+}
+
+// UpdatePoolWithResponse changes a pool in one transaction, records the change as
+// action in the pool history and handles HTTP responses. Errors of update wrapping
+// ErrInvalidPool are answered with 400. When the request has an If-Match header
+// the pool is only changed if its revision matches, otherwise 412 is returned.
+// The ETag of the new revision is set on success.
+func UpdatePoolWithResponse(c *gin.Context, poolId, action string, update func(pool *Pool) error) (Pool, bool) {
+	if !validFolderIDRegex.MatchString(poolId) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return Pool{}, false
+	}
+
+	ifMatch := c.GetHeader("If-Match")
+	var before Pool
+	pool, err := store.UpdatePool(poolId, func(pool *Pool) error {
+		// Checked inside the update, so no other change can slip in between
+		if ifMatch != "" && !PoolETagMatches(ifMatch, pool.Revision) {
+			return ErrRevisionMismatch
 		}
+		before = copyPool(*pool)
+		return update(pool)
+	})
+	if err != nil {
+		WriteStoreError(c, err)
 		return Pool{}, false
 	}
+
+	RecordPoolHistory(NewPoolHistoryEntry(poolId, action, c.GetString("userID"), &before, &pool))
+	SetPoolETag(c, pool)
 	return pool, true
 }
 
-// WritePoolData writes pool data to pool.json file
-func WritePoolData(poolPath string, poolData map[string]interface{}) error {
-	filePath := filepath.Join(poolPath, "pool.json")
-	file, err := os.Create(filePath)
-	if err != nil {
-		return err
+// PoolETag returns the entity tag of a pool revision
+func PoolETag(revision int) string {
+	return `"` + strconv.Itoa(revision) + `"`
+}
+
+// SetPoolETag sets the ETag header to the revision of pool
+func SetPoolETag(c *gin.Context, pool Pool) {
+	c.Header("ETag", PoolETag(pool.Revision))
+}
+
+// PoolETagMatches reports whether an If-Match header value matches the pool
+// revision. Weak tags never match, as If-Match requires strong comparison.
+func PoolETagMatches(ifMatch string, revision int) bool {
+	etag := PoolETag(revision)
+	for _, candidate := range strings.Split(ifMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
 	}
-	defer file.Close()
-	return json.NewEncoder(file).Encode(poolData)
+	return false
 }
 
-// WritePoolDataWithResponse writes pool data and handles HTTP responses
-func WritePoolDataWithResponse(c *gin.Context, poolPath string, poolData map[string]interface{}) bool {
-	if err := WritePoolData(poolPath, poolData); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+// DeletePoolWithResponse deletes a pool together with its CTFd data, records it in
+// the pool history and handles HTTP responses
+func DeletePoolWithResponse(c *gin.Context, poolId string) bool {
+	pool, ok := ReadPoolWithResponse(c, poolId)
+	if !ok {
+		return false
+	}
+
+	if err := deletePool(pool, c.GetString("userID")); err != nil {
+		WriteStoreError(c, err)
 		return false
 	}
 	return true
 }
 
-// GetAllPools returns all pools with basic information (excluding sensitive data)
-func GetAllPools(poolFolder string) ([]map[string]interface{}, error) {
+// deletePool deletes a pool read before and records it in the pool history as deleted by userId
+func deletePool(pool Pool, userId string) error {
+	if err := store.DeletePool(pool.Id); err != nil {
+		return err
+	}
+
+	RecordPoolHistory(NewPoolHistoryEntry(pool.Id, PoolActionDelete, userId, &pool, nil))
+	return nil
+}
+
+// copyPool detaches the members and access lists of a pool from the slices of the original
+func copyPool(pool Pool) Pool {
+	pool.UsersAndTeams = append([]PoolUser(nil), pool.UsersAndTeams...)
+	pool.CoInstructors = append([]string(nil), pool.CoInstructors...)
+	pool.Observers = append([]string(nil), pool.Observers...)
+	return pool
+}
+
+// PoolUsersFromMaps converts processed usersAndTeams entries to pool members
+func PoolUsersFromMaps(usersAndTeams []interface{}) ([]PoolUser, error) {
+	data, err := json.Marshal(usersAndTeams)
+	if err != nil {
+		return nil, err
+	}
+	var users []PoolUser
+	if err := json.Unmarshal(data, &users); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// GetAllPools returns the pools the caller of c may view with basic information
+// (excluding sensitive data)
+func GetAllPools(c *gin.Context) ([]map[string]interface{}, error) {
 	var pools []map[string]interface{}
 
-	files, err := os.ReadDir(poolFolder)
+	storedPools, err := store.ListPools()
 	if err != nil {
 		return nil, err
 	}
 
-	for _, file := range files {
-		if file.IsDir() {
-			poolPath := filepath.Join(poolFolder, file.Name())
-			pool, err := ReadPoolInternal(poolPath)
-			if err != nil {
-				continue // Skip pools we can't read
-			}
-
-			// Create pool data map for list view (without sensitive data)
-			poolData := map[string]interface{}{
-				"poolId":     file.Name(),
-				"createdBy":  pool.CreatedBy,
-				"note":       pool.Note,
-				"topologyId": pool.TopologyId,
-				"type":       pool.Type,
-				"ctfdData":   HasCtfdData(poolPath),
-			}
-
-			// Get creation time from pool.json file
-			poolJsonPath := filepath.Join(poolPath, "pool.json")
-			if fileInfo, err := os.Stat(poolJsonPath); err == nil {
-				poolData["createdAt"] = fileInfo.ModTime().Format(config.TimestampFormat)
-			}
-
-			pools = append(pools, poolData)
+	for _, pool := range storedPools {
+		if !CanAccessPool(c, pool, PoolAccessView) {
+			continue
 		}
+
+		// Create pool data map for list view (without sensitive data)
+		pools = append(pools, map[string]interface{}{
+			"poolId":        pool.Id,
+			"createdBy":     pool.CreatedBy,
+			"note":          pool.Note,
+			"topologyId":    pool.TopologyId,
+			"type":          pool.Type,
+			"revision":      pool.Revision,
+			"coInstructors": nonNilIds(pool.CoInstructors),
+			"observers":     nonNilIds(pool.Observers),
+			"ctfdData":      HasCtfdData(pool.Id),
+			"createdAt":     pool.CreatedAt.Format(config.TimestampFormat),
+		})
 	}
 
 	return pools, nil
 }
 
+// CountPools returns the number of pools
+func CountPools() int {
+	pools, err := store.ListPools()
+	if err != nil {
+		return 0
+	}
+	return len(pools)
+}
+
 // ExecuteTestingAction is a generic helper function for testing actions
-func ExecuteTestingAction(c *gin.Context, endpoint string, payload interface{}) {
+func ExecuteTestingAction(c *gin.Context, action RangeAction) {
 	poolId, ok := GetRequiredQueryParam(c, "poolId")
 	if !ok {
 		return
 	}
 
-	poolPath, ok := ValidateFolderId(c, config.PoolFolder, poolId)
+	pool, ok := ReadPoolWithResponse(c, poolId)
 	if !ok {
 		return
 	}
 
-	pool, ok := ReadPoolWithResponse(c, poolPath)
-	if !ok {
-		return
-	}
+	responses := RunTestingAction(LudusClientFromRequest(c), pool, action)
+	results := ConvertResponsesToResults(responses)
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
 
+// RunTestingAction runs a testing or power action against every range of a pool.
+// For SHARED pools only the main users own ranges.
+func RunTestingAction(client LudusClient, pool Pool, action RangeAction) []LudusResponse {
 	var users []string
 	if pool.Type == "SHARED" {
 		_, mainUsers := ExtractUserIdsAndMainUserIdsFromPool(pool)
@@ -126,19 +230,5 @@ func ExecuteTestingAction(c *gin.Context, endpoint string, payload interface{})
 		users = userIds
 	}
 
-	apiKey := c.Request.Header.Get("X-API-Key")
-
-	requests := make([]LudusRequest, len(users))
-	for i, userID := range users {
-		requests[i] = LudusRequest{
-			Method:  "PUT",
-			URL:     config.LudusUrl + endpoint + "?userID=" + userID,
-			Payload: payload,
-			UserID:  userID,
-		}
-	}
-
-	responses := MakeConcurrentLudusRequests(requests, apiKey, config.MaxConcurrentRequests)
-	results := ConvertResponsesToResults(responses)
-	c.JSON(http.StatusOK, gin.H{"results": results})
+	return RunConcurrentTasks(RangeActionTasks(client, users, action), config.MaxConcurrentRequests)
 }
@@ -0,0 +1,167 @@
+package utils
+
+import (
+	"dulus/server/config"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PoolTemplate holds what repeated runs of a course share: the pool type, the
+// topology, a default note and the team layout. Pools are created from it with a
+// new member list.
+type PoolTemplate struct {
+	Id         string    `json:"-"`
+	Name       string    `json:"name"`
+	Type       string    `json:"type"`
+	TopologyId string    `json:"topologyId"`
+	Note       string    `json:"note"`
+	Teams      []string  `json:"teams"`
+	CreatedBy  string    `json:"createdBy"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// TemplateResponse converts a template to its API representation
+func TemplateResponse(template PoolTemplate) gin.H {
+	teams := template.Teams
+	if teams == nil {
+		teams = []string{}
+	}
+	return gin.H{
+		"templateId": template.Id,
+		"name":       template.Name,
+		"type":       template.Type,
+		"topologyId": template.TopologyId,
+		"note":       template.Note,
+		"teams":      teams,
+		"createdBy":  template.CreatedBy,
+		"createdAt":  template.CreatedAt.Format(config.TimestampFormat),
+	}
+}
+
+// CreateTemplateWithResponse stores a new pool template and handles HTTP responses
+func CreateTemplateWithResponse(c *gin.Context, template PoolTemplate) (string, bool) {
+	templateId, err := store.CreateTemplate(template)
+	if err != nil {
+		WriteStoreError(c, err)
+		return "", false
+	}
+	return templateId, true
+}
+
+// ReadTemplateWithResponse reads a pool template and handles HTTP responses
+func ReadTemplateWithResponse(c *gin.Context, templateId string) (PoolTemplate, bool) {
+	if !validStoreId(c, templateId) {
+		return PoolTemplate{}, false
+	}
+
+	template, err := store.GetTemplate(templateId)
+	if err != nil {
+		WriteStoreError(c, err)
+		return PoolTemplate{}, false
+	}
+	return template, true
+}
+
+// DeleteTemplateWithResponse deletes a pool template and handles HTTP responses
+func DeleteTemplateWithResponse(c *gin.Context, templateId string) bool {
+	if !validStoreId(c, templateId) {
+		return false
+	}
+	if err := store.DeleteTemplate(templateId); err != nil {
+		WriteStoreError(c, err)
+		return false
+	}
+	return true
+}
+
+// GetAllTemplates returns all pool templates
+func GetAllTemplates() ([]gin.H, error) {
+	templates, err := store.ListTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	list := []gin.H{}
+	for _, template := range templates {
+		list = append(list, TemplateResponse(template))
+	}
+	return list, nil
+}
+
+// ParseNamesCSV reads members from a CSV with the name in the first column and
+// an optional team in the second. Empty lines and a header row starting with
+// "user" or "name" are skipped.
+func ParseNamesCSV(content []byte) ([]PoolUser, error) {
+	records, err := readCSVRecords(content)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPool, err)
+	}
+
+	var users []PoolUser
+	for i, record := range records {
+		name := strings.TrimSpace(record[0])
+		if name == "" {
+			continue
+		}
+		if i == 0 && (strings.EqualFold(name, "user") || strings.EqualFold(name, "name")) {
+			continue
+		}
+
+		user := PoolUser{User: name}
+		if len(record) > 1 {
+			user.Team = strings.TrimSpace(record[1])
+		}
+		users = append(users, user)
+	}
+
+	if len(users) == 0 {
+		return nil, fmt.Errorf("%w: no names in CSV", ErrInvalidPool)
+	}
+	return users, nil
+}
+
+// PoolTeams returns the distinct teams of a pool in the order they first appear
+func PoolTeams(pool Pool) []string {
+	seen := make(map[string]bool)
+	var teams []string
+	for _, user := range pool.UsersAndTeams {
+		if user.Team != "" && !seen[user.Team] {
+			seen[user.Team] = true
+			teams = append(teams, user.Team)
+		}
+	}
+	return teams
+}
+
+// AssignTeams spreads the members without a team over teams in turn
+func AssignTeams(users []PoolUser, teams []string) {
+	if len(teams) == 0 {
+		return
+	}
+
+	next := 0
+	for i := range users {
+		if users[i].Team == "" {
+			users[i].Team = teams[next%len(teams)]
+			next++
+		}
+	}
+}
+
+// PoolMembersWithResponse validates and processes the members of a new pool and
+// handles HTTP responses. SHARED members need a mainUserId, INDIVIDUAL members
+// must not have one. Generated userIds also avoid the users in Ludus.
+func PoolMembersWithResponse(c *gin.Context, users []PoolUser, poolType string) ([]PoolUser, bool) {
+	members, err := processPoolMembers(users, poolType, OperationCreate, LudusUserIds(LudusClientFromRequest(c)))
+	if err != nil {
+		log.Printf("Rejected pool members: %v", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Bad Request"})
+		return nil, false
+	}
+	return members, true
+}
@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"dulus/server/config"
 	"encoding/json"
 	"fmt"
@@ -8,7 +9,6 @@ import (
 	"math"
 	"net/http"
 	"net/url"
-	"os"
 	"strings"
 	"time"
 )
@@ -16,6 +16,7 @@ import (
 type ProxmoxClient struct {
 	BaseURL    string
 	HTTPClient *http.Client
+	Timeout    time.Duration // per call including retries, 0 disables
 }
 
 type ProxmoxAuthResponse struct {
@@ -71,10 +72,19 @@ type ProxmoxStatistics struct {
 func NewProxmoxClient(baseURL string) *ProxmoxClient {
 	return &ProxmoxClient{
 		BaseURL:    baseURL,
-		HTTPClient: createHTTPClient(),
+		HTTPClient: ProxmoxHTTPClient(),
+		Timeout:    time.Duration(config.ProxmoxRequestTimeoutSeconds) * time.Second,
 	}
 }
 
+// requestContext limits a Proxmox call to the configured timeout
+func (p *ProxmoxClient) requestContext() (context.Context, context.CancelFunc) {
+	if p.Timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), p.Timeout)
+}
+
 // AuthenticateProxmox authenticates with Proxmox and returns auth data
 func (p *ProxmoxClient) AuthenticateProxmox(username, password string) (*ProxmoxAuthResponse, error) {
 	authURL := fmt.Sprintf("%s/api2/json/access/ticket", p.BaseURL)
@@ -83,12 +93,17 @@ func (p *ProxmoxClient) AuthenticateProxmox(username, password string) (*Proxmox
 	data.Set("username", username)
 	data.Set("password", password)
 
-	req, err := http.NewRequest("POST", authURL, strings.NewReader(data.Encode()))
+	ctx, cancel := p.requestContext()
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", authURL, strings.NewReader(data.Encode()))
 	if err != nil {
 		return nil, fmt.Errorf("failed to create auth request: %w", err)
 	}
 
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	// Logging in again is harmless, so the ticket request may be retried
+	MarkIdempotent(req)
 
 	resp, err := p.HTTPClient.Do(req)
 	if err != nil {
@@ -117,7 +132,10 @@ func (p *ProxmoxClient) AuthenticateProxmox(username, password string) (*Proxmox
 func (p *ProxmoxClient) GetClusterResources(auth *ProxmoxAuthResponse) (*ProxmoxClusterResourcesResponse, error) {
 	resourcesURL := fmt.Sprintf("%s/api2/json/cluster/resources", p.BaseURL)
 
-	req, err := http.NewRequest("GET", resourcesURL, nil)
+	ctx, cancel := p.requestContext()
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", resourcesURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create resources request: %w", err)
 	}
@@ -153,7 +171,7 @@ func (p *ProxmoxClient) GetClusterResources(auth *ProxmoxAuthResponse) (*Proxmox
 }
 
 // ParseStatistics processes cluster resources and returns statistics
-func ParseStatistics(resources *ProxmoxClusterResourcesResponse, apiKey string) *ProxmoxStatistics {
+func ParseStatistics(resources *ProxmoxClusterResourcesResponse, client LudusClient) *ProxmoxStatistics {
 	stats := &ProxmoxStatistics{}
 
 	var nodeResource *ProxmoxResource
@@ -186,13 +204,13 @@ func ParseStatistics(resources *ProxmoxClusterResourcesResponse, apiKey string)
 	}
 
 	// Get additional statistics
-	stats.NumberOfTopologies = countDirectories(config.TopologyConfigFolder)
-	stats.NumberOfScenarios = countDirectories(config.CtfdScenarioFolder)
-	stats.NumberOfPools = countDirectories(config.PoolFolder)
-	stats.NumberOfRoles = getRolesCount(apiKey)
+	stats.NumberOfTopologies = CountTopologies()
+	stats.NumberOfScenarios = CountScenarios()
+	stats.NumberOfPools = CountPools()
+	stats.NumberOfRoles = getRolesCount(client)
 
 	// Get Ludus server version
-	version, err := GetLudusServerVersion(apiKey)
+	version, err := GetLudusServerVersion(client)
 	if err == nil {
 		stats.LudusVersion = version
 	} else {
@@ -255,52 +273,11 @@ func formatUptime(seconds int) string {
 	return strings.Join(parts, " ")
 }
 
-// countDirectories counts the number of directories in a given path
-func countDirectories(dirPath string) int {
-	entries, err := os.ReadDir(dirPath)
-	if err != nil {
-		return 0
-	}
-
-	count := 0
-	for _, entry := range entries {
-		if entry.IsDir() {
-			count++
-		}
-	}
-	return count
-}
-
 // getRolesCount gets the number of roles from Ludus API
-func getRolesCount(apiKey string) int {
-	client := createHTTPClient()
-
-	req, err := http.NewRequest("GET", config.LudusUrl+"/ansible", nil)
+func getRolesCount(client LudusClient) int {
+	roles, err := client.ListAnsibleRoles()
 	if err != nil {
 		return 0
 	}
-
-	req.Header.Set("X-Api-Key", apiKey)
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return 0
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return 0
-	}
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return 0
-	}
-
-	var roles []interface{}
-	if err := json.Unmarshal(body, &roles); err != nil {
-		return 0
-	}
-
 	return len(roles)
 }
@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"context"
+	"dulus/server/config"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// retryTransport retries idempotent requests that failed in transit or got a
+// gateway error, with jittered exponential backoff, and guards every upstream
+// host with a circuit breaker. A request counts as idempotent if its method is
+// GET, HEAD or PUT, or it carries an (unsent) Idempotency-Key header like the
+// net/http transport expects.
+type retryTransport struct {
+	base        http.RoundTripper
+	upstream    string
+	maxAttempts int
+	baseDelay   time.Duration
+	maxDelay    time.Duration
+}
+
+// One circuit breaker per upstream host, shared by all clients talking to it
+var (
+	circuitBreakers     = make(map[string]*CircuitBreaker)
+	circuitBreakerMutex sync.Mutex
+)
+
+// circuitBreakerFor returns the breaker of an upstream, e.g. "Ludus" at localhost:8080
+func circuitBreakerFor(upstream, host string) *CircuitBreaker {
+	circuitBreakerMutex.Lock()
+	defer circuitBreakerMutex.Unlock()
+
+	key := upstream + "|" + host
+	if breaker, exists := circuitBreakers[key]; exists {
+		return breaker
+	}
+	breaker := &CircuitBreaker{
+		Upstream:         upstream,
+		FailureThreshold: config.CircuitBreakerFailureThreshold,
+		OpenDuration:     time.Duration(config.CircuitBreakerOpenSeconds) * time.Second,
+	}
+	circuitBreakers[key] = breaker
+	return breaker
+}
+
+func newRetryTransport(base http.RoundTripper, upstream string) *retryTransport {
+	return &retryTransport{
+		base:        base,
+		upstream:    upstream,
+		maxAttempts: config.UpstreamRetryMaxAttempts,
+		baseDelay:   time.Duration(config.UpstreamRetryBaseDelayMs) * time.Millisecond,
+		maxDelay:    time.Duration(config.UpstreamRetryMaxDelayMs) * time.Millisecond,
+	}
+}
+
+// MarkIdempotent allows retrying a request whose method alone is not idempotent,
+// e.g. a POST that grants range access. The header is not sent.
+func MarkIdempotent(req *http.Request) {
+	req.Header["Idempotency-Key"] = nil
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodPut:
+		return true
+	}
+	_, hasKey := req.Header["Idempotency-Key"]
+	return hasKey
+}
+
+// isGatewayError reports statuses that mean the upstream itself is struggling
+func isGatewayError(statusCode int) bool {
+	return statusCode == http.StatusBadGateway || statusCode == http.StatusServiceUnavailable ||
+		statusCode == http.StatusGatewayTimeout
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	attempts := 1
+	if isIdempotent(req) && t.maxAttempts > 1 && (req.Body == nil || req.GetBody != nil) {
+		attempts = t.maxAttempts
+	}
+
+	breaker := circuitBreakerFor(t.upstream, req.URL.Host)
+
+	var resp *http.Response
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err := breaker.Allow(); err != nil {
+			return nil, err
+		}
+
+		attemptReq := req
+		if attempt > 1 && req.GetBody != nil {
+			body, bodyErr := req.GetBody()
+			if bodyErr != nil {
+				return nil, bodyErr
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+
+		resp, err = t.base.RoundTrip(attemptReq)
+		if req.Context().Err() != nil || errors.Is(err, context.Canceled) {
+			// An aborted job or a client that went away is not an upstream failure
+			breaker.Abandon()
+			return resp, err
+		}
+		failed := err != nil || isGatewayError(resp.StatusCode)
+		breaker.Record(!failed)
+
+		if !failed || attempt >= attempts {
+			return resp, err
+		}
+
+		// Discard the failed response before trying again
+		if resp != nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		}
+
+		select {
+		case <-time.After(t.backoff(attempt)):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// backoff returns a random delay up to baseDelay * 2^(attempt-1), capped at maxDelay
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	delay := t.baseDelay << (attempt - 1)
+	if delay > t.maxDelay || delay <= 0 {
+		delay = t.maxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(delay)))
+}
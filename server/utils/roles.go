@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"dulus/server/config"
+	"errors"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles of Dulus users, from most to least privileged
+const (
+	RoleAdmin      = "admin"
+	RoleInstructor = "instructor"
+	RoleObserver   = "observer"
+	RoleStudent    = "student"
+)
+
+// Roles lists all roles, from most to least privileged
+var Roles = []string{RoleAdmin, RoleInstructor, RoleObserver, RoleStudent}
+
+// Permissions required by the route groups
+const (
+	// PermissionView reads pools, topologies, jobs, schedules and range states
+	PermissionView = "view"
+	// PermissionManage changes pools, topologies, scenarios, ranges, jobs and schedules
+	PermissionManage = "manage"
+	// PermissionSecrets reads CTFd logins and flags, scenarios and WireGuard configs
+	PermissionSecrets = "secrets"
+	// PermissionAdmin assigns roles and deletes Ludus users by userId
+	PermissionAdmin = "admin"
+)
+
+// rolePermissions is the permission matrix. Every role may ask for its own role.
+var rolePermissions = map[string][]string{
+	RoleAdmin:      {PermissionView, PermissionManage, PermissionSecrets, PermissionAdmin},
+	RoleInstructor: {PermissionView, PermissionManage, PermissionSecrets},
+	RoleObserver:   {PermissionView},
+	RoleStudent:    {},
+}
+
+// RoleAssignment is the role an admin gave a user
+type RoleAssignment struct {
+	UserId     string    `json:"userId"`
+	Role       string    `json:"role"`
+	AssignedBy string    `json:"assignedBy"`
+	AssignedAt time.Time `json:"assignedAt"`
+}
+
+// ValidRole reports whether role is one of Roles
+func ValidRole(role string) bool {
+	return slices.Contains(Roles, role)
+}
+
+// RolePermissions returns the permissions of role
+func RolePermissions(role string) []string {
+	return append([]string{}, rolePermissions[role]...)
+}
+
+// HasPermission reports whether role grants permission
+func HasPermission(role, permission string) bool {
+	return slices.Contains(rolePermissions[role], permission)
+}
+
+// UserRole returns the role of a user: admin for Ludus admins, otherwise the
+// assigned role or DEFAULT_ROLE
+func UserRole(userId string, isLudusAdmin bool) (string, error) {
+	if isLudusAdmin {
+		return RoleAdmin, nil
+	}
+
+	assignment, err := store.GetRole(userId)
+	if errors.Is(err, ErrNotFound) {
+		return config.DefaultRole, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return assignment.Role, nil
+}
+
+// RequirePermission returns a middleware that lets a request through only if
+// the role of the authenticated user grants permission, otherwise it responds
+// with 403. It runs after the API key check, which sets userID and isAdmin, and
+// sets the user's role in the context.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, err := UserRole(c.GetString("userID"), c.GetBool("isAdmin"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
+			c.Abort()
+			return
+		}
+
+		c.Set("role", role)
+		if permission != "" && !HasPermission(role, permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Forbidden"})
+			c.Abort()
+			return
+		}
+	}
+}
+
+// AssignRoleWithResponse gives a user a role and handles HTTP responses
+func AssignRoleWithResponse(c *gin.Context, userId, role string) (RoleAssignment, bool) {
+	assignment := RoleAssignment{
+		UserId:     userId,
+		Role:       role,
+		AssignedBy: c.GetString("userID"),
+		AssignedAt: time.Now(),
+	}
+	if err := store.SetRole(assignment); err != nil {
+		WriteStoreError(c, err)
+		return RoleAssignment{}, false
+	}
+	return assignment, true
+}
+
+// RemoveRoleWithResponse removes the role of a user, who gets DEFAULT_ROLE again,
+// and handles HTTP responses
+func RemoveRoleWithResponse(c *gin.Context, userId string) bool {
+	if err := store.DeleteRole(userId); err != nil {
+		WriteStoreError(c, err)
+		return false
+	}
+	return true
+}
+
+// GetAllRoles returns all role assignments
+func GetAllRoles() ([]RoleAssignment, error) {
+	assignments, err := store.ListRoles()
+	if err != nil {
+		return nil, err
+	}
+	if assignments == nil {
+		assignments = []RoleAssignment{}
+	}
+	return assignments, nil
+}
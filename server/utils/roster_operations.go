@@ -0,0 +1,486 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Limits for reading a roster: the size of an XLSX XML part, the highest row
+// number and the highest XLSX column (CV), so a few cells far out cannot make
+// every row allocate thousands of empty values
+const (
+	maxRosterSheetSize = 32 << 20
+	maxRosterRows      = 10000
+	maxRosterColumns   = 100
+)
+
+// RosterColumns selects the columns of an imported roster. Each is a header name
+// (case-insensitive) or a column letter; empty selects the "name" (or "user"),
+// "team" and "mainUserId" headers. Team and main user are optional.
+type RosterColumns struct {
+	Name       string
+	Team       string
+	MainUserId string
+}
+
+// RosterRow is one member row of an imported roster with its validation result.
+// Input is the name as written in the file, User and UserId what it becomes.
+type RosterRow struct {
+	Row        int      `json:"row"`
+	Input      string   `json:"input"`
+	User       string   `json:"user"`
+	UserId     string   `json:"userId"`
+	Team       string   `json:"team,omitempty"`
+	MainUserId string   `json:"mainUserId,omitempty"`
+	Valid      bool     `json:"valid"`
+	Errors     []string `json:"errors"`
+	Warnings   []string `json:"warnings"`
+}
+
+// RosterReport is the validation report of an imported roster. Its members are
+// only added to the pool when Valid is true.
+type RosterReport struct {
+	Valid   bool        `json:"valid"`
+	Total   int         `json:"total"`
+	Invalid int         `json:"invalid"`
+	Errors  []string    `json:"errors"`
+	Rows    []RosterRow `json:"rows"`
+}
+
+// Members returns the members of the report's valid rows
+func (r RosterReport) Members() []PoolUser {
+	var members []PoolUser
+	for _, row := range r.Rows {
+		if row.Valid {
+			members = append(members, PoolUser{User: row.User, UserId: row.UserId, Team: row.Team, MainUserId: row.MainUserId})
+		}
+	}
+	return members
+}
+
+// ReadRoster reads the member rows of an uploaded CSV or XLSX roster. The first
+// non-empty row is the header, empty rows are skipped. Errors wrap ErrInvalidPool.
+func ReadRoster(fileName string, content []byte, columns RosterColumns) ([]RosterRow, error) {
+	var records [][]string
+	var err error
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".csv":
+		records, err = readCSVRecords(content)
+	case ".xlsx":
+		records, err = readXLSXRecords(content)
+	default:
+		err = fmt.Errorf("unsupported file type %s", filepath.Ext(fileName))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPool, err)
+	}
+
+	header := -1
+	for i, record := range records {
+		if !emptyRecord(record) {
+			header = i
+			break
+		}
+	}
+	if header < 0 {
+		return nil, fmt.Errorf("%w: the roster is empty", ErrInvalidPool)
+	}
+
+	nameColumn, err := rosterColumn(records[header], columns.Name, true, "name", "user")
+	if err != nil {
+		return nil, err
+	}
+	teamColumn, err := rosterColumn(records[header], columns.Team, false, "team")
+	if err != nil {
+		return nil, err
+	}
+	mainUserColumn, err := rosterColumn(records[header], columns.MainUserId, false, "mainUserId")
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []RosterRow
+	for i := header + 1; i < len(records); i++ {
+		if emptyRecord(records[i]) {
+			continue
+		}
+		rows = append(rows, RosterRow{
+			Row:        i + 1,
+			Input:      recordValue(records[i], nameColumn),
+			Team:       recordValue(records[i], teamColumn),
+			MainUserId: recordValue(records[i], mainUserColumn),
+		})
+	}
+	return rows, nil
+}
+
+// ValidateRoster checks the rows of a roster against each other and against the
+// members of pool, with the same rules as adding members through the API, and
+// reports every problem per row. Names are kept as display names; the userIds
+// generated from them avoid the userIds of pools and ludusUserIds. A userId that
+// differs from the name gets a warning.
+func ValidateRoster(pool Pool, rows []RosterRow, ludusUserIds map[string]bool) (RosterReport, error) {
+	existingMainUsers, err := GetAllMainUsersFromPools()
+	if err != nil {
+		return RosterReport{}, err
+	}
+	taken, err := UserIdsInUse(ludusUserIds)
+	if err != nil {
+		return RosterReport{}, err
+	}
+
+	poolUsers := make(map[string]bool)
+	poolMainUsers := make(map[string]bool)
+	for _, user := range pool.UsersAndTeams {
+		poolUsers[strings.ToLower(user.User)] = true
+		taken[strings.ToLower(user.UserId)] = true
+		if user.MainUserId != "" {
+			poolMainUsers[user.MainUserId] = true
+		}
+	}
+
+	rowHasTeam := false
+	for _, row := range rows {
+		rowHasTeam = rowHasTeam || row.Team != ""
+		if row.MainUserId != "" {
+			taken[strings.ToLower(row.MainUserId)] = true
+		}
+	}
+	teamRequired := rowHasTeam
+	teamAllowed := true
+	if len(pool.UsersAndTeams) > 0 {
+		teamRequired = pool.UsersAndTeams[0].Team != ""
+		teamAllowed = teamRequired
+	}
+
+	report := RosterReport{Errors: []string{}, Rows: []RosterRow{}}
+	seenUsers := make(map[string]int)
+	for _, row := range rows {
+		row.Errors = []string{}
+		row.Warnings = []string{}
+		row.User = row.Input
+
+		base := userIdBase(row.User)
+		switch {
+		case row.User == "":
+			row.Errors = append(row.Errors, "name is empty")
+		case base == "BATCH":
+			row.Errors = append(row.Errors, fmt.Sprintf("name '%s' has no letters or digits", row.User))
+		default:
+			row.UserId = generateUserId(row.User, taken)
+			compact := strings.ToLower(strings.ReplaceAll(row.User, " ", ""))
+			if replaceSpecialChars(compact) != compact {
+				row.Warnings = append(row.Warnings, fmt.Sprintf("special characters were replaced or removed in userId '%s'", row.UserId))
+			}
+			if len(base) > maxUserIdLength {
+				row.Warnings = append(row.Warnings, fmt.Sprintf("name is too long for a userId, it was shortened to '%s'", row.UserId))
+			}
+			if preferred := shortenUserId(base, maxUserIdLength); row.UserId != preferred {
+				row.Warnings = append(row.Warnings, fmt.Sprintf("userId '%s' is already taken, '%s' is used instead", preferred, row.UserId))
+			}
+		}
+
+		if row.User != "" {
+			if poolUsers[strings.ToLower(row.User)] {
+				row.Errors = append(row.Errors, fmt.Sprintf("user '%s' is already a member of the pool", row.User))
+			} else if first, exists := seenUsers[strings.ToLower(row.User)]; exists {
+				row.Errors = append(row.Errors, fmt.Sprintf("duplicate user '%s', first in row %d", row.User, first))
+			} else {
+				seenUsers[strings.ToLower(row.User)] = row.Row
+			}
+		}
+
+		if row.Team == "" && teamRequired {
+			row.Errors = append(row.Errors, "team is required, if one user has a team all must have one")
+		} else if row.Team != "" && !teamAllowed {
+			row.Errors = append(row.Errors, "team is not allowed, the members of the pool have no team")
+		}
+
+		if pool.Type == "SHARED" && row.MainUserId == "" {
+			row.Errors = append(row.Errors, "mainUserId is required in SHARED pools")
+		} else if pool.Type != "SHARED" && row.MainUserId != "" {
+			row.Errors = append(row.Errors, "mainUserId is not allowed in INDIVIDUAL pools")
+		} else if row.MainUserId != "" && existingMainUsers[row.MainUserId] && !poolMainUsers[row.MainUserId] {
+			row.Errors = append(row.Errors, fmt.Sprintf("main user '%s' already belongs to another pool", row.MainUserId))
+		}
+
+		row.Valid = len(row.Errors) == 0
+		if !row.Valid {
+			report.Invalid++
+		}
+		report.Rows = append(report.Rows, row)
+	}
+	report.Total = len(report.Rows)
+
+	if report.Total == 0 {
+		report.Errors = append(report.Errors, "the roster has no members")
+	}
+	if report.Total > 0 && report.Invalid == 0 {
+		// The rules of the pool itself have the last word
+		if err := AddRosterMembers(&pool, report.Members()); err != nil {
+			report.Errors = append(report.Errors, err.Error())
+		}
+	}
+	report.Valid = report.Total > 0 && report.Invalid == 0 && len(report.Errors) == 0
+	return report, nil
+}
+
+// AddRosterMembers adds members of a validated roster, which already have their
+// userIds, to pool
+func AddRosterMembers(pool *Pool, members []PoolUser) error {
+	combined := append(append([]PoolUser{}, pool.UsersAndTeams...), members...)
+	processed, err := processPoolMembers(combined, pool.Type, OperationAdd, nil)
+	if err != nil {
+		return err
+	}
+	pool.UsersAndTeams = processed
+	return nil
+}
+
+// rosterColumn returns the index of the column selected by spec in header, or
+// the first header in defaults when spec is empty. An optional column that is
+// not selected and not found is -1.
+func rosterColumn(header []string, spec string, required bool, defaults ...string) (int, error) {
+	names := defaults
+	if spec != "" {
+		names = []string{spec}
+	}
+	for _, name := range names {
+		for i, value := range header {
+			if strings.EqualFold(strings.TrimSpace(value), strings.TrimSpace(name)) {
+				return i, nil
+			}
+		}
+	}
+
+	if spec != "" {
+		if index, ok := columnIndex(strings.TrimSpace(spec)); ok {
+			return index, nil
+		}
+		return -1, fmt.Errorf("%w: column '%s' not found", ErrInvalidPool, spec)
+	}
+	if required {
+		return -1, fmt.Errorf("%w: no '%s' column", ErrInvalidPool, defaults[0])
+	}
+	return -1, nil
+}
+
+// columnIndex converts a column letter such as "A" or "AB" to its zero-based index
+func columnIndex(letters string) (int, bool) {
+	if letters == "" || len(letters) > 3 {
+		return 0, false
+	}
+	index := 0
+	for _, char := range strings.ToUpper(letters) {
+		if char < 'A' || char > 'Z' {
+			return 0, false
+		}
+		index = index*26 + int(char-'A') + 1
+	}
+	return index - 1, true
+}
+
+// recordValue returns the trimmed value of a record's column, empty if it has none
+func recordValue(record []string, column int) string {
+	if column < 0 || column >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[column])
+}
+
+// emptyRecord reports whether all values of a record are blank
+func emptyRecord(record []string) bool {
+	for _, value := range record {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// readCSVRecords reads all records of a CSV file, a UTF-8 byte order mark is dropped.
+// Records are indexed by the line they start on, so row numbers match the file
+// although the CSV reader skips blank lines.
+func readCSVRecords(content []byte) ([][]string, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))))
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	var records [][]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		if line > maxRosterRows {
+			return nil, fmt.Errorf("more than %d rows", maxRosterRows)
+		}
+		for len(records) < line-1 {
+			records = append(records, nil)
+		}
+		records = append(records, record)
+	}
+}
+
+// XLSX parts needed to read the cell values of the first worksheet
+type xlsxWorkbook struct {
+	Sheets []struct {
+		RelationId string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+	} `xml:"sheets>sheet"`
+}
+
+type xlsxRelationships struct {
+	Relationships []struct {
+		Id     string `xml:"Id,attr"`
+		Target string `xml:"Target,attr"`
+	} `xml:"Relationship"`
+}
+
+type xlsxText struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (t xlsxText) String() string {
+	text := t.Text
+	for _, run := range t.Runs {
+		text += run.Text
+	}
+	return text
+}
+
+type xlsxSharedStrings struct {
+	Items []xlsxText `xml:"si"`
+}
+
+type xlsxWorksheet struct {
+	Rows []struct {
+		Index int `xml:"r,attr"`
+		Cells []struct {
+			Ref    string   `xml:"r,attr"`
+			Type   string   `xml:"t,attr"`
+			Value  string   `xml:"v"`
+			Inline xlsxText `xml:"is"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+// readXLSXRecords reads the cell values of the first worksheet of an XLSX file.
+// Records are indexed by row number, rows missing in the sheet are empty.
+func readXLSXRecords(content []byte) ([][]string, error) {
+	archive, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("not an XLSX file: %v", err)
+	}
+	files := make(map[string]*zip.File)
+	for _, file := range archive.File {
+		files[file.Name] = file
+	}
+
+	sheetPath := "xl/worksheets/sheet1.xml"
+	var workbook xlsxWorkbook
+	var relationships xlsxRelationships
+	if readXLSXPart(files, "xl/workbook.xml", &workbook) == nil && len(workbook.Sheets) > 0 &&
+		readXLSXPart(files, "xl/_rels/workbook.xml.rels", &relationships) == nil {
+		for _, relationship := range relationships.Relationships {
+			if relationship.Id == workbook.Sheets[0].RelationId {
+				if strings.HasPrefix(relationship.Target, "/") {
+					sheetPath = strings.TrimPrefix(relationship.Target, "/")
+				} else {
+					sheetPath = path.Join("xl", relationship.Target)
+				}
+				break
+			}
+		}
+	}
+
+	var sharedStrings xlsxSharedStrings
+	if _, exists := files["xl/sharedStrings.xml"]; exists {
+		if err := readXLSXPart(files, "xl/sharedStrings.xml", &sharedStrings); err != nil {
+			return nil, err
+		}
+	}
+
+	var sheet xlsxWorksheet
+	if err := readXLSXPart(files, sheetPath, &sheet); err != nil {
+		return nil, err
+	}
+
+	var records [][]string
+	for _, row := range sheet.Rows {
+		index := row.Index - 1
+		if index >= maxRosterRows {
+			return nil, fmt.Errorf("more than %d rows", maxRosterRows)
+		}
+		if index < len(records) {
+			index = len(records)
+		}
+		for len(records) <= index {
+			records = append(records, nil)
+		}
+
+		var record []string
+		for position, cell := range row.Cells {
+			column := position
+			if cell.Ref != "" {
+				letters := strings.TrimRight(cell.Ref, "0123456789")
+				if parsed, ok := columnIndex(letters); ok {
+					column = parsed
+				}
+			}
+			if column >= maxRosterColumns {
+				return nil, fmt.Errorf("row %d has cells beyond column %d", index+1, maxRosterColumns)
+			}
+			for len(record) <= column {
+				record = append(record, "")
+			}
+
+			switch cell.Type {
+			case "s":
+				item, err := strconv.Atoi(cell.Value)
+				if err != nil || item < 0 || item >= len(sharedStrings.Items) {
+					return nil, fmt.Errorf("invalid shared string in cell %s", cell.Ref)
+				}
+				record[column] = sharedStrings.Items[item].String()
+			case "inlineStr":
+				record[column] = cell.Inline.String()
+			default:
+				record[column] = cell.Value
+			}
+		}
+		records[index] = record
+	}
+	return records, nil
+}
+
+// readXLSXPart decodes one XML part of an XLSX file
+func readXLSXPart(files map[string]*zip.File, name string, value interface{}) error {
+	file, exists := files[name]
+	if !exists {
+		return fmt.Errorf("XLSX part %s is missing", name)
+	}
+	reader, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	if err := xml.NewDecoder(io.LimitReader(reader, maxRosterSheetSize)).Decode(value); err != nil {
+		return fmt.Errorf("invalid XLSX part %s: %v", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// useTestStore replaces the store with an empty in-memory one for one test
+func useTestStore(t *testing.T) Store {
+	t.Helper()
+	previous := store
+	store = NewMemoryStore()
+	t.Cleanup(func() { store = previous })
+	return store
+}
+
+// rosterXLSX builds an XLSX file whose first worksheet has sheetData, with
+// "Name" and "Team" as shared strings 0 and 1
+func rosterXLSX(t *testing.T, sheetData string) []byte {
+	t.Helper()
+	parts := map[string]string{
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<si><t>Name</t></si><si><r><t>Te</t></r><r><t>am</t></r></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">` +
+			`<sheetData>` + sheetData + `</sheetData></worksheet>`,
+	}
+
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	for name, content := range parts {
+		writer, err := archive.Create(name)
+		if err != nil {
+			t.Fatalf("create %s: %v", name, err)
+		}
+		writer.Write([]byte(content))
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatalf("close archive: %v", err)
+	}
+	return buffer.Bytes()
+}
+
+func TestReadRosterCSV(t *testing.T) {
+	content := "\xef\xbb\xbfName,Team\n\n Ján Novák ,red\n,\nEva Kovac,blue\n"
+	rows, err := ReadRoster("roster.csv", []byte(content), RosterColumns{})
+	if err != nil {
+		t.Fatalf("read roster: %v", err)
+	}
+	expected := []RosterRow{
+		{Row: 3, Input: "Ján Novák", Team: "red"},
+		{Row: 5, Input: "Eva Kovac", Team: "blue"},
+	}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, rows)
+	}
+
+	// Columns can be selected by letter
+	rows, err = ReadRoster("roster.csv", []byte("a,b\nred,Eva Kovac\n"), RosterColumns{Name: "B", Team: "A"})
+	if err != nil || len(rows) != 1 || rows[0].Input != "Eva Kovac" || rows[0].Team != "red" {
+		t.Fatalf("expected Eva Kovac of team red, got %+v (%v)", rows, err)
+	}
+
+	for name, content := range map[string]string{"no name column": "a,b\nEva,red\n", "empty": "\n,\n"} {
+		if _, err := ReadRoster("roster.csv", []byte(content), RosterColumns{}); !errors.Is(err, ErrInvalidPool) {
+			t.Errorf("%s: expected an invalid roster, got %v", name, err)
+		}
+	}
+}
+
+func TestReadRosterXLSX(t *testing.T) {
+	content := rosterXLSX(t, `<row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="s"><v>1</v></c></row>`+
+		`<row r="3"><c r="A3" t="inlineStr"><is><t>Ján Novák</t></is></c><c r="C3" t="inlineStr"><is><t>red</t></is></c></row>`)
+	rows, err := ReadRoster("Roster.XLSX", content, RosterColumns{})
+	if err != nil {
+		t.Fatalf("read roster: %v", err)
+	}
+	expected := []RosterRow{{Row: 3, Input: "Ján Novák", Team: "red"}}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, rows)
+	}
+}
+
+func TestReadRosterXLSXRejectsFarColumns(t *testing.T) {
+	var sheetData strings.Builder
+	sheetData.WriteString(`<row r="1"><c r="A1" t="s"><v>0</v></c></row>`)
+	sheetData.WriteString(`<row r="2"><c r="ZZZ2"><v>1</v></c></row>`)
+	if _, err := ReadRoster("roster.xlsx", rosterXLSX(t, sheetData.String()), RosterColumns{}); !errors.Is(err, ErrInvalidPool) {
+		t.Fatalf("expected a cell in column ZZZ to be rejected, got %v", err)
+	}
+}
+
+func TestValidateRoster(t *testing.T) {
+	useTestStore(t)
+	pool := Pool{Type: "INDIVIDUAL"}
+	rows := []RosterRow{
+		{Row: 2, Input: "Ján Novák"},
+		{Row: 3, Input: "Jan Novak"},
+		{Row: 4, Input: "Maximilian Alexander Schmidt"},
+		{Row: 5, Input: "ján novák"},
+		{Row: 6, Input: "!!!"},
+	}
+
+	report, err := ValidateRoster(pool, rows, map[string]bool{})
+	if err != nil {
+		t.Fatalf("validate roster: %v", err)
+	}
+	if report.Valid || report.Total != 5 || report.Invalid != 2 {
+		t.Fatalf("expected 2 of 5 rows invalid, got %+v", report)
+	}
+
+	tests := []struct {
+		userId   string
+		valid    bool
+		errors   []string
+		warnings []string
+	}{
+		{userId: "BATCHjannovak", valid: true, errors: []string{},
+			warnings: []string{"special characters were replaced or removed in userId 'BATCHjannovak'"}},
+		{userId: "BATCHjannovak2", valid: true, errors: []string{},
+			warnings: []string{"userId 'BATCHjannovak' is already taken, 'BATCHjannovak2' is used instead"}},
+		{userId: "BATCHmaximilianalex", valid: true, errors: []string{},
+			warnings: []string{"name is too long for a userId, it was shortened to 'BATCHmaximilianalex'"}},
+		{userId: "BATCHjannovak3", valid: false,
+			errors: []string{"duplicate user 'ján novák', first in row 2"},
+			warnings: []string{
+				"special characters were replaced or removed in userId 'BATCHjannovak3'",
+				"userId 'BATCHjannovak' is already taken, 'BATCHjannovak3' is used instead",
+			}},
+		{userId: "", valid: false, errors: []string{"name '!!!' has no letters or digits"}, warnings: []string{}},
+	}
+	for i, tt := range tests {
+		row := report.Rows[i]
+		if row.UserId != tt.userId || row.Valid != tt.valid || !reflect.DeepEqual(row.Errors, tt.errors) || !reflect.DeepEqual(row.Warnings, tt.warnings) {
+			t.Errorf("row %d: expected %s (valid %v, errors %q, warnings %q), got %s (valid %v, errors %q, warnings %q)",
+				row.Row, tt.userId, tt.valid, tt.errors, tt.warnings, row.UserId, row.Valid, row.Errors, row.Warnings)
+		}
+	}
+}
+
+func TestValidateRosterAgainstPool(t *testing.T) {
+	useTestStore(t)
+	pool := Pool{Type: "INDIVIDUAL", UsersAndTeams: []PoolUser{{User: "Eva Kovac", UserId: "BATCHevakovac", Team: "red"}}}
+	rows := []RosterRow{
+		{Row: 2, Input: "eva kovac", Team: "blue"},
+		{Row: 3, Input: "Adam Horak"},
+		{Row: 4, Input: "Petra Novotna", Team: "red"},
+	}
+
+	report, err := ValidateRoster(pool, rows, nil)
+	if err != nil {
+		t.Fatalf("validate roster: %v", err)
+	}
+	expected := [][]string{
+		{"user 'eva kovac' is already a member of the pool"},
+		{"team is required, if one user has a team all must have one"},
+		{},
+	}
+	for i, errors := range expected {
+		if !reflect.DeepEqual(report.Rows[i].Errors, errors) {
+			t.Errorf("row %d: expected errors %q, got %q", report.Rows[i].Row, errors, report.Rows[i].Errors)
+		}
+	}
+	if members := report.Members(); len(members) != 1 || members[0].UserId != "BATCHpetranovotna" {
+		t.Fatalf("expected Petra Novotna as the only valid member, got %+v", members)
+	}
+}
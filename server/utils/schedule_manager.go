@@ -0,0 +1,379 @@
+package utils
+
+import (
+	"dulus/server/config"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Scheduled pool actions
+const (
+	ScheduleActionDeploy   = "DEPLOY"
+	ScheduleActionPowerOn  = "POWER_ON"
+	ScheduleActionPowerOff = "POWER_OFF"
+	ScheduleActionDestroy  = "DESTROY"
+)
+
+// Outcome of a schedule run
+const (
+	ScheduleRunSuccess = "SUCCESS"
+	ScheduleRunFailed  = "FAILED"
+	ScheduleRunSkipped = "SKIPPED"
+)
+
+// Number of runs kept in the schedule history file
+const maxScheduleHistory = 1000
+
+// Schedule runs one pool action either once at RunAt or repeatedly according to Cron
+type Schedule struct {
+	ScheduleId         string     `json:"scheduleId"`
+	PoolId             string     `json:"poolId"`
+	Action             string     `json:"action"`
+	RunAt              *time.Time `json:"runAt,omitempty"`
+	Cron               string     `json:"cron,omitempty"`
+	ConcurrentRequests int        `json:"concurrentRequests,omitempty"`
+	Enabled            bool       `json:"enabled"`
+	Note               string     `json:"note,omitempty"`
+	CreatedBy          string     `json:"createdBy"`
+	CreatedAt          time.Time  `json:"createdAt"`
+	LastRunAt          *time.Time `json:"lastRunAt,omitempty"`
+	NextRunAt          *time.Time `json:"nextRunAt,omitempty"`
+}
+
+type ScheduleRun struct {
+	RunId        string                   `json:"runId"`
+	ScheduleId   string                   `json:"scheduleId"`
+	PoolId       string                   `json:"poolId"`
+	Action       string                   `json:"action"`
+	Status       string                   `json:"status"`
+	JobId        string                   `json:"jobId,omitempty"`
+	Error        string                   `json:"error,omitempty"`
+	Results      []map[string]interface{} `json:"results,omitempty"`
+	ScheduledFor time.Time                `json:"scheduledFor"`
+	StartedAt    time.Time                `json:"startedAt"`
+	FinishedAt   time.Time                `json:"finishedAt"`
+}
+
+// Global schedule state, mirrored to disk on every change
+var (
+	schedules       = make(map[string]*Schedule)
+	scheduleHistory []ScheduleRun
+	scheduleMutex   sync.RWMutex
+)
+
+// IsValidScheduleAction reports whether action is one of the supported pool actions
+func IsValidScheduleAction(action string) bool {
+	switch action {
+	case ScheduleActionDeploy, ScheduleActionPowerOn, ScheduleActionPowerOff, ScheduleActionDestroy:
+		return true
+	}
+	return false
+}
+
+// IsRecurring reports whether the schedule runs on a cron expression
+func (s *Schedule) IsRecurring() bool {
+	return s.Cron != ""
+}
+
+// computeNextRun returns the next time the schedule is due after t, or nil if it will not run again
+func (s *Schedule) computeNextRun(t time.Time) *time.Time {
+	if !s.Enabled {
+		return nil
+	}
+
+	if !s.IsRecurring() {
+		if s.RunAt == nil || s.LastRunAt != nil {
+			return nil
+		}
+		runAt := *s.RunAt
+		return &runAt
+	}
+
+	cron, err := ParseCron(s.Cron)
+	if err != nil {
+		return nil
+	}
+	next := cron.Next(t.In(time.Local))
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+// ValidateSchedule checks that a schedule has exactly one of runAt and cron and a valid action
+func ValidateSchedule(schedule Schedule) error {
+	if !IsValidScheduleAction(schedule.Action) {
+		return fmt.Errorf("invalid action %q", schedule.Action)
+	}
+	if (schedule.RunAt == nil) == (schedule.Cron == "") {
+		return fmt.Errorf("exactly one of runAt and cron must be set")
+	}
+	if schedule.Cron != "" {
+		cron, err := ParseCron(schedule.Cron)
+		if err != nil {
+			return err
+		}
+		if cron.Next(time.Now()).IsZero() {
+			return fmt.Errorf("cron expression %q never matches", schedule.Cron)
+		}
+	}
+	if schedule.ConcurrentRequests < 0 {
+		return fmt.Errorf("concurrentRequests must not be negative")
+	}
+	return nil
+}
+
+// writeSchedule persists a schedule to <ScheduleFolder>/<scheduleId>/schedule.json
+func writeSchedule(schedule *Schedule) error {
+	schedulePath := filepath.Join(config.ScheduleFolder, schedule.ScheduleId)
+	if err := os.MkdirAll(schedulePath, os.ModePerm); err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(schedulePath, "schedule.json"), schedule)
+}
+
+// writeScheduleHistory persists the run history to <ScheduleFolder>/history.json
+func writeScheduleHistory() error {
+	return writeJSONFile(filepath.Join(config.ScheduleFolder, "history.json"), scheduleHistory)
+}
+
+// writeJSONFile replaces path atomically, so a crash mid-write cannot leave a
+// truncated file that LoadSchedules would refuse at startup
+func writeJSONFile(path string, value interface{}) error {
+	content, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(content, '\n'))
+}
+
+// LoadSchedules reads persisted schedules and the run history from disk
+func LoadSchedules() error {
+	scheduleDirs, err := os.ReadDir(config.ScheduleFolder)
+	if err != nil {
+		return fmt.Errorf("failed to read schedule folder: %w", err)
+	}
+
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	for _, dir := range scheduleDirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		data, err := os.ReadFile(filepath.Join(config.ScheduleFolder, dir.Name(), "schedule.json"))
+		if err != nil {
+			continue
+		}
+
+		var schedule Schedule
+		if err := json.Unmarshal(data, &schedule); err != nil {
+			continue
+		}
+		schedules[schedule.ScheduleId] = &schedule
+	}
+
+	data, err := os.ReadFile(filepath.Join(config.ScheduleFolder, "history.json"))
+	if err == nil {
+		if err := json.Unmarshal(data, &scheduleHistory); err != nil {
+			return fmt.Errorf("failed to parse schedule history: %w", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read schedule history: %w", err)
+	}
+
+	return nil
+}
+
+// CreateSchedule stores a new schedule and computes its first run
+func CreateSchedule(schedule Schedule) (Schedule, error) {
+	if err := ValidateSchedule(schedule); err != nil {
+		return Schedule{}, err
+	}
+
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	scheduleId, err := GenerateUniqueID(config.ScheduleFolder)
+	if err != nil {
+		return Schedule{}, err
+	}
+
+	schedule.ScheduleId = scheduleId
+	schedule.CreatedAt = time.Now()
+	schedule.LastRunAt = nil
+	schedule.NextRunAt = schedule.computeNextRun(schedule.CreatedAt)
+
+	if err := writeSchedule(&schedule); err != nil {
+		return Schedule{}, err
+	}
+
+	schedules[scheduleId] = &schedule
+	return schedule, nil
+}
+
+// UpdateSchedule applies update to a schedule, validates the result and recomputes its next run
+func UpdateSchedule(scheduleId string, update func(schedule *Schedule)) (Schedule, error) {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	existing, exists := schedules[scheduleId]
+	if !exists {
+		return Schedule{}, os.ErrNotExist
+	}
+
+	updated := *existing
+	update(&updated)
+	if err := ValidateSchedule(updated); err != nil {
+		return Schedule{}, err
+	}
+
+	// A changed one-off time makes the schedule due again
+	if !updated.IsRecurring() && (existing.RunAt == nil || !existing.RunAt.Equal(*updated.RunAt)) {
+		updated.LastRunAt = nil
+	}
+	updated.NextRunAt = updated.computeNextRun(time.Now())
+
+	if err := writeSchedule(&updated); err != nil {
+		return Schedule{}, err
+	}
+
+	schedules[scheduleId] = &updated
+	return updated, nil
+}
+
+// DeleteSchedule removes a schedule, its past runs stay in the history
+func DeleteSchedule(scheduleId string) error {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	if _, exists := schedules[scheduleId]; !exists {
+		return os.ErrNotExist
+	}
+
+	if err := os.RemoveAll(filepath.Join(config.ScheduleFolder, scheduleId)); err != nil {
+		return err
+	}
+
+	delete(schedules, scheduleId)
+	return nil
+}
+
+// GetSchedule returns a copy of the schedule with the given id
+func GetSchedule(scheduleId string) (Schedule, bool) {
+	scheduleMutex.RLock()
+	defer scheduleMutex.RUnlock()
+
+	schedule, exists := schedules[scheduleId]
+	if !exists {
+		return Schedule{}, false
+	}
+	return *schedule, true
+}
+
+// ListSchedules returns schedules ordered by next run, optionally filtered by pool
+func ListSchedules(poolId string) []Schedule {
+	scheduleMutex.RLock()
+	defer scheduleMutex.RUnlock()
+
+	var result []Schedule
+	for _, schedule := range schedules {
+		if poolId != "" && schedule.PoolId != poolId {
+			continue
+		}
+		result = append(result, *schedule)
+	}
+
+	// Schedules that will not run again go last
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i].NextRunAt, result[j].NextRunAt
+		switch {
+		case a != nil && b != nil:
+			return a.Before(*b)
+		case a != nil || b != nil:
+			return a != nil
+		default:
+			return result[i].CreatedAt.Before(result[j].CreatedAt)
+		}
+	})
+	return result
+}
+
+// DeletePoolSchedules removes all schedules of a pool
+func DeletePoolSchedules(poolId string) {
+	for _, schedule := range ListSchedules(poolId) {
+		DeleteSchedule(schedule.ScheduleId)
+	}
+}
+
+// ClaimDueSchedules returns the schedules due at now and advances their next run,
+// so a schedule is handed out once per occurrence even if its action runs long
+func ClaimDueSchedules(now time.Time) []Schedule {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	var due []Schedule
+	for _, schedule := range schedules {
+		if !schedule.Enabled || schedule.NextRunAt == nil || schedule.NextRunAt.After(now) {
+			continue
+		}
+
+		due = append(due, *schedule)
+
+		lastRun := now
+		schedule.LastRunAt = &lastRun
+		schedule.NextRunAt = schedule.computeNextRun(now)
+		if !schedule.IsRecurring() {
+			schedule.Enabled = false
+		}
+		if err := writeSchedule(schedule); err != nil {
+			log.Printf("Failed to persist schedule %s after claiming it: %v", schedule.ScheduleId, err)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].NextRunAt.Before(*due[j].NextRunAt)
+	})
+	return due
+}
+
+// RecordScheduleRun appends a run to the history, dropping the oldest runs beyond the limit
+func RecordScheduleRun(run ScheduleRun) {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+
+	run.RunId = RandomString(8)
+	scheduleHistory = append(scheduleHistory, run)
+	if len(scheduleHistory) > maxScheduleHistory {
+		scheduleHistory = scheduleHistory[len(scheduleHistory)-maxScheduleHistory:]
+	}
+	if err := writeScheduleHistory(); err != nil {
+		log.Printf("Failed to persist schedule history: %v", err)
+	}
+}
+
+// GetScheduleHistory returns past runs, newest first, optionally filtered by pool and schedule
+func GetScheduleHistory(poolId, scheduleId string) []ScheduleRun {
+	scheduleMutex.RLock()
+	defer scheduleMutex.RUnlock()
+
+	var result []ScheduleRun
+	for i := len(scheduleHistory) - 1; i >= 0; i-- {
+		run := scheduleHistory[i]
+		if poolId != "" && run.PoolId != poolId {
+			continue
+		}
+		if scheduleId != "" && run.ScheduleId != scheduleId {
+			continue
+		}
+		result = append(result, run)
+	}
+	return result
+}
@@ -0,0 +1,142 @@
+package utils
+
+import (
+	"dulus/server/config"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Interval between checks for due schedules
+const scheduleCheckInterval = 30 * time.Second
+
+// StartScheduler checks for due schedules in a background goroutine for the
+// lifetime of the process. Runs missed for longer than the configured grace
+// period (e.g. while the service was down) are recorded as skipped.
+func StartScheduler() {
+	go func() {
+		ticker := time.NewTicker(scheduleCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			runDueSchedules(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+// runDueSchedules starts every due schedule in its own goroutine so a long destroy
+// of one pool does not delay the schedules of other pools
+func runDueSchedules(now time.Time) {
+	grace := time.Duration(config.ScheduleMissedRunGraceMinutes) * time.Minute
+
+	for _, schedule := range ClaimDueSchedules(now) {
+		if now.Sub(*schedule.NextRunAt) > grace {
+			RecordScheduleRun(ScheduleRun{
+				ScheduleId:   schedule.ScheduleId,
+				PoolId:       schedule.PoolId,
+				Action:       schedule.Action,
+				Status:       ScheduleRunSkipped,
+				Error:        "run was missed while the service was not running",
+				ScheduledFor: *schedule.NextRunAt,
+				StartedAt:    now,
+				FinishedAt:   now,
+			})
+			continue
+		}
+
+		go func(schedule Schedule) {
+			RecordScheduleRun(ExecuteSchedule(schedule, *schedule.NextRunAt))
+		}(schedule)
+	}
+}
+
+// ExecuteSchedule runs the action of a schedule with the service API key and
+// reports the outcome. Deploys only start a job, its progress is tracked under /jobs.
+func ExecuteSchedule(schedule Schedule, scheduledFor time.Time) ScheduleRun {
+	run := ScheduleRun{
+		ScheduleId:   schedule.ScheduleId,
+		PoolId:       schedule.PoolId,
+		Action:       schedule.Action,
+		Status:       ScheduleRunSuccess,
+		ScheduledFor: scheduledFor,
+		StartedAt:    time.Now(),
+	}
+
+	log.Printf("Running schedule %s: %s for pool %s", schedule.ScheduleId, schedule.Action, schedule.PoolId)
+
+	jobId, responses, err := executeScheduleAction(schedule)
+	run.JobId = jobId
+	if err != nil {
+		run.Status = ScheduleRunFailed
+		run.Error = err.Error()
+	}
+
+	failed := 0
+	for _, resp := range responses {
+		result := gin.H{"userId": resp.UserID, "status": UserStatusSuccess}
+		if resp.Error != nil {
+			failed++
+			result["status"] = UserStatusFailed
+			result["error"] = resp.Error.Error()
+			if statusCode := LudusStatusCode(resp.Error); statusCode != 0 {
+				result["statusCode"] = statusCode
+			}
+		}
+		run.Results = append(run.Results, result)
+	}
+	if failed > 0 && err == nil {
+		run.Status = ScheduleRunFailed
+		run.Error = fmt.Sprintf("action failed for %d of %d users", failed, len(responses))
+	}
+
+	run.FinishedAt = time.Now()
+	return run
+}
+
+// executeScheduleAction performs the pool action the same way the matching range
+// endpoint does and returns the job it created, if any, and the per-user responses
+func executeScheduleAction(schedule Schedule) (string, []LudusResponse, error) {
+	if config.LudusServiceApiKey == "" {
+		return "", nil, fmt.Errorf("LUDUS_SERVICE_API_KEY is not set")
+	}
+	client := NewLudusClient(config.LudusServiceApiKey)
+
+	pool, err := ReadPoolById(schedule.PoolId)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read pool: %w", err)
+	}
+
+	switch schedule.Action {
+	case ScheduleActionDeploy:
+		// Capped like the other fan-out paths, the schedule may predate a lower limit
+		concurrentRequests := schedule.ConcurrentRequests
+		if concurrentRequests == 0 || concurrentRequests > config.MaxConcurrentRequests {
+			concurrentRequests = config.MaxConcurrentRequests
+		}
+		userIds := PoolUserIds(pool, SharedMainUserOnly)
+		job, err := StartPoolJob(client, schedule.PoolId, JobTypeDeploy, schedule.CreatedBy, userIds, concurrentRequests, DefaultDeployPolicy())
+		if err != nil {
+			return "", nil, err
+		}
+		return job.JobId, nil, nil
+
+	case ScheduleActionPowerOn:
+		return "", RunTestingAction(client, pool, LudusClient.PowerOn), nil
+
+	case ScheduleActionPowerOff:
+		return "", RunTestingAction(client, pool, LudusClient.PowerOff), nil
+
+	case ScheduleActionDestroy:
+		userIds := PoolUserIds(pool, SharedMainUserOnly)
+		job, responses, err := DestroyPoolRanges(client, schedule.PoolId, schedule.CreatedBy, userIds)
+		if err != nil {
+			return "", nil, err
+		}
+		return job.JobId, responses, nil
+	}
+
+	return "", nil, fmt.Errorf("unknown action %q", schedule.Action)
+}
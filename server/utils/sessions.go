@@ -0,0 +1,271 @@
+package utils
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"dulus/server/config"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidSessionToken is returned for tokens that are malformed, not signed
+// by this server, expired or of a revoked session
+var ErrInvalidSessionToken = errors.New("invalid session token")
+
+// ErrSessionRefreshInProgress is returned for a refresh token that is being
+// exchanged by another request at the moment, e.g. a double submit
+var ErrSessionRefreshInProgress = errors.New("session refresh in progress")
+
+// Types of session tokens
+const (
+	sessionTokenAccess  = "access"
+	sessionTokenRefresh = "refresh"
+)
+
+// Session is a login with a Ludus API key. The key stays on the server and is
+// used for the calls to Ludus made on behalf of the session. Sessions are kept
+// in memory only, so a restart ends them.
+type Session struct {
+	Id        string
+	UserId    string
+	IsAdmin   bool
+	APIKey    string
+	RefreshId string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+
+	// Set while the refresh token is exchanged, so it is not used twice meanwhile
+	refreshing bool
+}
+
+// SessionTokens are issued at login and on every refresh. The refresh token
+// can be used once; its successor comes with the next access token.
+type SessionTokens struct {
+	UserId           string `json:"userId"`
+	TokenType        string `json:"tokenType"`
+	AccessToken      string `json:"accessToken"`
+	ExpiresAt        string `json:"expiresAt"`
+	RefreshToken     string `json:"refreshToken"`
+	RefreshExpiresAt string `json:"refreshExpiresAt"`
+}
+
+// sessionClaims is the signed payload of a session token
+type sessionClaims struct {
+	SessionId string `json:"sid"`
+	Type      string `json:"typ"`
+	RefreshId string `json:"rid,omitempty"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+var (
+	sessionMutex sync.Mutex
+	sessions     = make(map[string]*Session)
+
+	// Tokens are signed with a key made at startup, they end with the sessions
+	sessionKeyOnce sync.Once
+	sessionKey     []byte
+)
+
+// sessionSigningKey returns the key session tokens are signed with
+func sessionSigningKey() []byte {
+	sessionKeyOnce.Do(func() {
+		sessionKey = make([]byte, 32)
+		if _, err := rand.Read(sessionKey); err != nil {
+			panic("Failed to generate session signing key")
+		}
+	})
+	return sessionKey
+}
+
+// signSessionToken encodes claims as "<payload>.<HMAC-SHA256 of payload>", both base64url
+func signSessionToken(claims sessionClaims) string {
+	payload, _ := json.Marshal(claims)
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, sessionSigningKey())
+	mac.Write([]byte(encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// parseSessionToken checks the signature, type and expiry of a token and returns its claims
+func parseSessionToken(token, tokenType string) (sessionClaims, error) {
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found {
+		return sessionClaims{}, ErrInvalidSessionToken
+	}
+	sum, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil {
+		return sessionClaims{}, ErrInvalidSessionToken
+	}
+	mac := hmac.New(sha256.New, sessionSigningKey())
+	mac.Write([]byte(encoded))
+	if !hmac.Equal(sum, mac.Sum(nil)) {
+		return sessionClaims{}, ErrInvalidSessionToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return sessionClaims{}, ErrInvalidSessionToken
+	}
+	var claims sessionClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return sessionClaims{}, ErrInvalidSessionToken
+	}
+	if claims.Type != tokenType || time.Now().Unix() >= claims.ExpiresAt {
+		return sessionClaims{}, ErrInvalidSessionToken
+	}
+	return claims, nil
+}
+
+// issueSessionTokens gives a session a new refresh token and returns it with a
+// new access token. The caller holds sessionMutex.
+func issueSessionTokens(session *Session) SessionTokens {
+	now := time.Now()
+	session.RefreshId = RandomString(32)
+	session.ExpiresAt = now.Add(time.Duration(config.SessionRefreshTokenHours) * time.Hour)
+	accessExpiresAt := now.Add(time.Duration(config.SessionAccessTokenMinutes) * time.Minute)
+	if accessExpiresAt.After(session.ExpiresAt) {
+		accessExpiresAt = session.ExpiresAt
+	}
+
+	return SessionTokens{
+		UserId:    session.UserId,
+		TokenType: "Bearer",
+		AccessToken: signSessionToken(sessionClaims{
+			SessionId: session.Id,
+			Type:      sessionTokenAccess,
+			ExpiresAt: accessExpiresAt.Unix(),
+		}),
+		ExpiresAt: accessExpiresAt.Format(config.TimestampFormat),
+		RefreshToken: signSessionToken(sessionClaims{
+			SessionId: session.Id,
+			Type:      sessionTokenRefresh,
+			RefreshId: session.RefreshId,
+			ExpiresAt: session.ExpiresAt.Unix(),
+		}),
+		RefreshExpiresAt: session.ExpiresAt.Format(config.TimestampFormat),
+	}
+}
+
+// CreateSession starts a session for a user authenticated with apiKey
+func CreateSession(user AuthUser, apiKey string) SessionTokens {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	// Logins are rare enough to clean up ended sessions on the way
+	now := time.Now()
+	for id, session := range sessions {
+		if now.After(session.ExpiresAt) {
+			delete(sessions, id)
+		}
+	}
+
+	session := &Session{
+		Id:        RandomString(32),
+		UserId:    user.UserId,
+		IsAdmin:   user.IsAdmin,
+		APIKey:    apiKey,
+		CreatedAt: now,
+	}
+	sessions[session.Id] = session
+	return issueSessionTokens(session)
+}
+
+// SessionFromAccessToken returns the session of a valid access token
+func SessionFromAccessToken(token string) (Session, error) {
+	claims, err := parseSessionToken(token, sessionTokenAccess)
+	if err != nil {
+		return Session{}, err
+	}
+
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	session, exists := sessions[claims.SessionId]
+	if !exists {
+		return Session{}, ErrInvalidSessionToken
+	}
+	return *session, nil
+}
+
+// RefreshSession exchanges a refresh token for new tokens. The Ludus API key of
+// the session is checked again, so sessions end once the key is replaced or its
+// user deleted. A refresh token used again after it was exchanged ends the
+// session, as it may have been stolen; one still being exchanged is answered
+// with ErrSessionRefreshInProgress.
+func RefreshSession(ctx context.Context, refreshToken string) (SessionTokens, error) {
+	claims, err := parseSessionToken(refreshToken, sessionTokenRefresh)
+	if err != nil {
+		return SessionTokens{}, err
+	}
+
+	sessionMutex.Lock()
+	session, exists := sessions[claims.SessionId]
+	if !exists || time.Now().After(session.ExpiresAt) {
+		sessionMutex.Unlock()
+		return SessionTokens{}, ErrInvalidSessionToken
+	}
+	if claims.RefreshId != session.RefreshId {
+		delete(sessions, session.Id)
+		sessionMutex.Unlock()
+		return SessionTokens{}, ErrInvalidSessionToken
+	}
+	if session.refreshing {
+		sessionMutex.Unlock()
+		return SessionTokens{}, ErrSessionRefreshInProgress
+	}
+	session.refreshing = true
+	apiKey := session.APIKey
+	sessionMutex.Unlock()
+
+	user, err := AuthenticateAPIKey(ctx, apiKey)
+	if errors.Is(err, ErrUnknownUser) || errors.Is(err, ErrAPIKeyMismatch) {
+		RevokeSession(claims.SessionId)
+		return SessionTokens{}, ErrInvalidSessionToken
+	}
+
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	session, exists = sessions[claims.SessionId]
+	if !exists {
+		return SessionTokens{}, ErrInvalidSessionToken
+	}
+	session.refreshing = false
+	if err != nil {
+		// The key could not be checked, the refresh token stays usable
+		return SessionTokens{}, err
+	}
+	session.IsAdmin = user.IsAdmin
+	return issueSessionTokens(session), nil
+}
+
+// RevokeSession ends a session, its tokens are rejected from now on
+func RevokeSession(sessionId string) bool {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	_, exists := sessions[sessionId]
+	delete(sessions, sessionId)
+	return exists
+}
+
+// RevokeUserSessions ends all sessions of a user and returns how many there were
+func RevokeUserSessions(userId string) int {
+	sessionMutex.Lock()
+	defer sessionMutex.Unlock()
+
+	revoked := 0
+	for id, session := range sessions {
+		if session.UserId == userId {
+			delete(sessions, id)
+			revoked++
+		}
+	}
+	return revoked
+}
@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"context"
+	"dulus/server/config"
+	"errors"
+	"sync"
+	"testing"
+)
+
+// stubAuthenticator looks up the fixture users. While block is set, lookups
+// signal entered and wait until block is closed.
+type stubAuthenticator struct {
+	mutex   sync.Mutex
+	hashes  map[string]string
+	block   chan struct{}
+	entered chan struct{}
+}
+
+func (s *stubAuthenticator) LookupUser(ctx context.Context, userId string) (AuthUser, error) {
+	s.mutex.Lock()
+	block, entered := s.block, s.entered
+	hash, exists := s.hashes[userId]
+	s.mutex.Unlock()
+
+	if block != nil {
+		entered <- struct{}{}
+		<-block
+	}
+	if !exists {
+		return AuthUser{}, ErrUnknownUser
+	}
+	return AuthUser{UserId: userId, IsAdmin: userId == "ADMIN", HashedAPIKey: hash}, nil
+}
+
+func (s *stubAuthenticator) Close() error {
+	return nil
+}
+
+// startTestSession logs JD in with a stub authenticator and returns its tokens
+func startTestSession(t *testing.T) (*stubAuthenticator, SessionTokens) {
+	t.Helper()
+	config.SessionAccessTokenMinutes = 15
+	config.SessionRefreshTokenHours = 1
+
+	a := &stubAuthenticator{hashes: make(map[string]string)}
+	for _, user := range fixtureUsers {
+		a.hashes[user.userId] = hashFixtureKey(t, user.apiKey)
+	}
+	SetAuthenticator(a)
+	t.Cleanup(func() { SetAuthenticator(nil) })
+
+	user, err := AuthenticateAPIKey(context.Background(), "JD.usersecret")
+	if err != nil {
+		t.Fatalf("authenticate: %v", err)
+	}
+	tokens := CreateSession(user, "JD.usersecret")
+	t.Cleanup(func() { RevokeUserSessions("JD") })
+	return a, tokens
+}
+
+func TestSessionLogin(t *testing.T) {
+	_, tokens := startTestSession(t)
+
+	session, err := SessionFromAccessToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("access token rejected: %v", err)
+	}
+	if session.UserId != "JD" || session.APIKey != "JD.usersecret" {
+		t.Fatalf("unexpected session %+v", session)
+	}
+	if _, err := SessionFromAccessToken(tokens.RefreshToken); !errors.Is(err, ErrInvalidSessionToken) {
+		t.Fatalf("a refresh token was accepted as access token: %v", err)
+	}
+	if _, err := SessionFromAccessToken(tokens.AccessToken + "x"); !errors.Is(err, ErrInvalidSessionToken) {
+		t.Fatalf("a token with a wrong signature was accepted: %v", err)
+	}
+}
+
+func TestSessionRefresh(t *testing.T) {
+	_, tokens := startTestSession(t)
+
+	refreshed, err := RefreshSession(context.Background(), tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if refreshed.RefreshToken == tokens.RefreshToken {
+		t.Fatal("expected a new refresh token")
+	}
+	if _, err := SessionFromAccessToken(refreshed.AccessToken); err != nil {
+		t.Fatalf("refreshed access token rejected: %v", err)
+	}
+	if _, err := RefreshSession(context.Background(), refreshed.RefreshToken); err != nil {
+		t.Fatalf("second refresh: %v", err)
+	}
+}
+
+func TestSessionRefreshTokenReuseEndsSession(t *testing.T) {
+	_, tokens := startTestSession(t)
+
+	refreshed, err := RefreshSession(context.Background(), tokens.RefreshToken)
+	if err != nil {
+		t.Fatalf("refresh: %v", err)
+	}
+	if _, err := RefreshSession(context.Background(), tokens.RefreshToken); !errors.Is(err, ErrInvalidSessionToken) {
+		t.Fatalf("expected the used refresh token to be rejected, got %v", err)
+	}
+	if _, err := SessionFromAccessToken(refreshed.AccessToken); !errors.Is(err, ErrInvalidSessionToken) {
+		t.Fatalf("expected the session to end after reuse, got %v", err)
+	}
+}
+
+func TestSessionRefreshInProgress(t *testing.T) {
+	a, tokens := startTestSession(t)
+
+	a.mutex.Lock()
+	a.block, a.entered = make(chan struct{}), make(chan struct{})
+	a.mutex.Unlock()
+
+	type result struct {
+		tokens SessionTokens
+		err    error
+	}
+	first := make(chan result)
+	go func() {
+		refreshed, err := RefreshSession(context.Background(), tokens.RefreshToken)
+		first <- result{refreshed, err}
+	}()
+	<-a.entered
+
+	// A double submit while the first refresh waits for the key check
+	if _, err := RefreshSession(context.Background(), tokens.RefreshToken); !errors.Is(err, ErrSessionRefreshInProgress) {
+		t.Fatalf("expected a refresh in progress, got %v", err)
+	}
+
+	close(a.block)
+	refreshed := <-first
+	if refreshed.err != nil {
+		t.Fatalf("first refresh: %v", refreshed.err)
+	}
+	if _, err := SessionFromAccessToken(refreshed.tokens.AccessToken); err != nil {
+		t.Fatalf("expected the session to survive the double submit, got %v", err)
+	}
+}
+
+func TestSessionRefreshEndsWithReplacedKey(t *testing.T) {
+	a, tokens := startTestSession(t)
+
+	a.mutex.Lock()
+	a.hashes["JD"] = hashFixtureKey(t, "JD.newsecret")
+	a.mutex.Unlock()
+
+	if _, err := RefreshSession(context.Background(), tokens.RefreshToken); !errors.Is(err, ErrInvalidSessionToken) {
+		t.Fatalf("expected the refresh to fail with a replaced key, got %v", err)
+	}
+	if _, err := SessionFromAccessToken(tokens.AccessToken); !errors.Is(err, ErrInvalidSessionToken) {
+		t.Fatalf("expected the session to end, got %v", err)
+	}
+}
+
+func TestSessionRevoke(t *testing.T) {
+	_, tokens := startTestSession(t)
+
+	session, err := SessionFromAccessToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("access token rejected: %v", err)
+	}
+	if !RevokeSession(session.Id) {
+		t.Fatal("expected the session to exist")
+	}
+	if _, err := SessionFromAccessToken(tokens.AccessToken); !errors.Is(err, ErrInvalidSessionToken) {
+		t.Fatalf("expected the access token to be rejected, got %v", err)
+	}
+	if _, err := RefreshSession(context.Background(), tokens.RefreshToken); !errors.Is(err, ErrInvalidSessionToken) {
+		t.Fatalf("expected the refresh token to be rejected, got %v", err)
+	}
+
+	_, tokens = startTestSession(t)
+	if revoked := RevokeUserSessions("JD"); revoked != 1 {
+		t.Fatalf("expected 1 revoked session, got %d", revoked)
+	}
+	if _, err := SessionFromAccessToken(tokens.AccessToken); !errors.Is(err, ErrInvalidSessionToken) {
+		t.Fatalf("expected the access token to be rejected, got %v", err)
+	}
+}
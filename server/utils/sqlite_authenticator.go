@@ -0,0 +1,19 @@
+package utils
+
+// LudusSQLiteAuthenticator looks up users in the user_objects table of the
+// Ludus 1.x SQLite database
+type LudusSQLiteAuthenticator struct {
+	sqliteUserLookup
+}
+
+// OpenLudusSQLiteAuthenticator opens the Ludus 1.x database at path read-only
+func OpenLudusSQLiteAuthenticator(path string) (*LudusSQLiteAuthenticator, error) {
+	db, err := openReadOnlySQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	return &LudusSQLiteAuthenticator{sqliteUserLookup{
+		db:    db,
+		query: `SELECT is_admin, hashed_api_key FROM user_objects WHERE user_id = ?`,
+	}}, nil
+}
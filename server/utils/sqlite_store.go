@@ -0,0 +1,954 @@
+package utils
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// storeSchema creates the tables of the scenario manager database. A main user
+// owns the shared range of exactly one pool, and a userId that is a main user
+// cannot be a member of any pool; the triggers enforce the latter across pools.
+const storeSchema = `
+CREATE TABLE IF NOT EXISTS pools (
+	id TEXT PRIMARY KEY,
+	created_by TEXT NOT NULL,
+	note TEXT NOT NULL DEFAULT '',
+	topology_id TEXT NOT NULL,
+	type TEXT NOT NULL CHECK (type IN ('SHARED', 'INDIVIDUAL')),
+	created_at TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS pools_topology_id ON pools (topology_id);
+
+CREATE TABLE IF NOT EXISTS pool_members (
+	pool_id TEXT NOT NULL REFERENCES pools (id) ON DELETE CASCADE,
+	position INTEGER NOT NULL,
+	user_name TEXT NOT NULL,
+	user_id TEXT NOT NULL,
+	team TEXT NOT NULL DEFAULT '',
+	main_user_id TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (pool_id, position),
+	UNIQUE (pool_id, user_id)
+);
+CREATE INDEX IF NOT EXISTS pool_members_user_id ON pool_members (user_id);
+
+CREATE TABLE IF NOT EXISTS pool_main_users (
+	main_user_id TEXT PRIMARY KEY,
+	pool_id TEXT NOT NULL REFERENCES pools (id) ON DELETE CASCADE
+);
+
+CREATE TRIGGER IF NOT EXISTS pool_members_not_main_user
+BEFORE INSERT ON pool_members
+WHEN EXISTS (SELECT 1 FROM pool_main_users WHERE main_user_id = NEW.user_id)
+BEGIN
+	SELECT RAISE(ABORT, 'userId is already a main user of a pool');
+END;
+
+CREATE TRIGGER IF NOT EXISTS pool_main_users_not_member
+BEFORE INSERT ON pool_main_users
+WHEN EXISTS (SELECT 1 FROM pool_members WHERE user_id = NEW.main_user_id)
+BEGIN
+	SELECT RAISE(ABORT, 'main user is already a member of a pool');
+END;
+
+CREATE TABLE IF NOT EXISTS topologies (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	created_at TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS scenarios (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	mode TEXT NOT NULL DEFAULT '',
+	created_at TEXT NOT NULL
+);
+`
+
+// storeMigrations upgrade databases created with an older storeSchema. The
+// database records in user_version how many of them have run.
+var storeMigrations = []string{
+	`ALTER TABLE pools ADD COLUMN revision INTEGER NOT NULL DEFAULT 1`,
+	// No foreign key: the history outlives the pool
+	`CREATE TABLE pool_history (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		pool_id TEXT NOT NULL,
+		revision INTEGER NOT NULL,
+		action TEXT NOT NULL,
+		user_id TEXT NOT NULL,
+		created_at TEXT NOT NULL,
+		changes TEXT NOT NULL,
+		pool TEXT
+	);
+	CREATE INDEX pool_history_pool_id ON pool_history (pool_id)`,
+	`CREATE TABLE pool_templates (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL,
+		type TEXT NOT NULL CHECK (type IN ('SHARED', 'INDIVIDUAL')),
+		topology_id TEXT NOT NULL,
+		note TEXT NOT NULL DEFAULT '',
+		teams TEXT NOT NULL DEFAULT '[]',
+		created_by TEXT NOT NULL,
+		created_at TEXT NOT NULL
+	);
+	CREATE INDEX pool_templates_topology_id ON pool_templates (topology_id)`,
+	`CREATE TABLE user_roles (
+		user_id TEXT PRIMARY KEY,
+		role TEXT NOT NULL CHECK (role IN ('admin', 'instructor', 'observer', 'student')),
+		assigned_by TEXT NOT NULL,
+		assigned_at TEXT NOT NULL
+	)`,
+	`CREATE TABLE pool_access (
+		pool_id TEXT NOT NULL REFERENCES pools (id) ON DELETE CASCADE,
+		user_id TEXT NOT NULL,
+		access TEXT NOT NULL CHECK (access IN ('instructor', 'observer')),
+		PRIMARY KEY (pool_id, user_id)
+	)`,
+}
+
+// SQLiteStore keeps pools, their members, topologies and scenarios in the
+// scenario manager's own SQLite database. Every write runs in an immediate
+// transaction, so concurrent changes to a pool are applied one after another.
+// Topology and scenario files and CTFd data stay in the data folders.
+type SQLiteStore struct {
+	ctfdDataFolder
+	db         *sql.DB
+	poolFolder string
+	topologies itemFolder
+	scenarios  itemFolder
+}
+
+// OpenSQLiteStore opens (and creates) the store database at path, keeping
+// files in the given data folders
+func OpenSQLiteStore(path, poolFolder, topologyFolder, scenarioFolder string) (*SQLiteStore, error) {
+	dsn := "file:" + path + "?" + url.Values{
+		"_pragma": {"foreign_keys(1)", "busy_timeout(5000)", "journal_mode(WAL)"},
+		"_txlock": {"immediate"},
+	}.Encode()
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(storeSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create store schema: %w", err)
+	}
+	if err := migrateStoreSchema(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate store schema: %w", err)
+	}
+	return &SQLiteStore{
+		ctfdDataFolder: ctfdDataFolder{poolFolder: poolFolder},
+		db:             db,
+		poolFolder:     poolFolder,
+		topologies:     itemFolder{base: topologyFolder},
+		scenarios:      itemFolder{base: scenarioFolder},
+	}, nil
+}
+
+// migrateStoreSchema runs the storeMigrations the database has not seen yet
+func migrateStoreSchema(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(storeMigrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(storeMigrations[version]); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the store database
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// isConstraintError reports whether err is a violated uniqueness, check or trigger rule
+func isConstraintError(err error) bool {
+	var sqliteErr *sqlite.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.Code()&0xff == sqlite3.SQLITE_CONSTRAINT
+}
+
+// withTx runs fn in a transaction and commits it when fn succeeds
+func (s *SQLiteStore) withTx(fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		if isConstraintError(err) {
+			return fmt.Errorf("%w: %v", ErrInvalidPool, err)
+		}
+		return err
+	}
+	return tx.Commit()
+}
+
+func formatStoreTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func parseStoreTime(value string) time.Time {
+	t, _ := time.Parse(time.RFC3339Nano, value)
+	return t
+}
+
+// CreatePool stores a new pool under a generated id and returns the id
+func (s *SQLiteStore) CreatePool(pool Pool) (string, error) {
+	if pool.CreatedAt.IsZero() {
+		pool.CreatedAt = time.Now()
+	}
+	pool.Revision = 1
+
+	err := s.withTx(func(tx *sql.Tx) error {
+		for {
+			pool.Id = RandomString(6)
+			var exists bool
+			err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM pools WHERE id = ?) OR EXISTS (SELECT 1 FROM pool_history WHERE pool_id = ?)`,
+				pool.Id, pool.Id).Scan(&exists)
+			if err != nil {
+				return err
+			}
+			// Folders of deleted pools may still hold CTFd data
+			if !exists && !FileExists(filepath.Join(s.poolFolder, pool.Id)) {
+				break
+			}
+		}
+		return insertPool(tx, pool)
+	})
+	if err != nil {
+		return "", err
+	}
+	return pool.Id, nil
+}
+
+// insertPool writes the pool row followed by its main users and members
+func insertPool(tx *sql.Tx, pool Pool) error {
+	_, err := tx.Exec(`INSERT INTO pools (id, created_by, note, topology_id, type, created_at, revision) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		pool.Id, pool.CreatedBy, pool.Note, pool.TopologyId, pool.Type, formatStoreTime(pool.CreatedAt), max(pool.Revision, 1))
+	if err != nil {
+		return err
+	}
+	if err := insertPoolAccess(tx, pool); err != nil {
+		return err
+	}
+	return insertPoolUsers(tx, pool)
+}
+
+// insertPoolAccess writes the co-instructors and observers of a pool
+func insertPoolAccess(tx *sql.Tx, pool Pool) error {
+	lists := map[string][]string{"instructor": pool.CoInstructors, "observer": pool.Observers}
+	for _, access := range []string{"instructor", "observer"} {
+		for _, userId := range lists[access] {
+			if _, err := tx.Exec(`INSERT INTO pool_access (pool_id, user_id, access) VALUES (?, ?, ?)`, pool.Id, userId, access); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readPoolAccess reads the co-instructors and observers of the pool poolId, or of
+// all pools when poolId is empty, into the pools by id
+func readPoolAccess(q queryer, poolId string, pools map[string]*Pool) error {
+	query := `SELECT pool_id, user_id, access FROM pool_access ORDER BY rowid`
+	var args []any
+	if poolId != "" {
+		query = `SELECT pool_id, user_id, access FROM pool_access WHERE pool_id = ? ORDER BY rowid`
+		args = append(args, poolId)
+	}
+
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, userId, access string
+		if err := rows.Scan(&id, &userId, &access); err != nil {
+			return err
+		}
+		pool, exists := pools[id]
+		if !exists {
+			continue
+		}
+		if access == "instructor" {
+			pool.CoInstructors = append(pool.CoInstructors, userId)
+		} else {
+			pool.Observers = append(pool.Observers, userId)
+		}
+	}
+	return rows.Err()
+}
+
+func insertPoolUsers(tx *sql.Tx, pool Pool) error {
+	_, mainUserIds := ExtractUserIdsAndMainUserIdsFromPool(pool)
+	for _, mainUserId := range mainUserIds {
+		if _, err := tx.Exec(`INSERT INTO pool_main_users (main_user_id, pool_id) VALUES (?, ?)`, mainUserId, pool.Id); err != nil {
+			return err
+		}
+	}
+
+	for position, user := range pool.UsersAndTeams {
+		_, err := tx.Exec(`INSERT INTO pool_members (pool_id, position, user_name, user_id, team, main_user_id) VALUES (?, ?, ?, ?, ?, ?)`,
+			pool.Id, position, user.User, user.UserId, user.Team, user.MainUserId)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryer is implemented by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+func readPool(q queryer, poolId string) (Pool, error) {
+	pool := Pool{Id: poolId}
+	var createdAt string
+	err := q.QueryRow(`SELECT created_by, note, topology_id, type, created_at, revision FROM pools WHERE id = ?`, poolId).
+		Scan(&pool.CreatedBy, &pool.Note, &pool.TopologyId, &pool.Type, &createdAt, &pool.Revision)
+	if err == sql.ErrNoRows {
+		return Pool{}, ErrPoolNotFound
+	}
+	if err != nil {
+		return Pool{}, err
+	}
+	pool.CreatedAt = parseStoreTime(createdAt)
+
+	if err := readPoolAccess(q, poolId, map[string]*Pool{poolId: &pool}); err != nil {
+		return Pool{}, err
+	}
+
+	rows, err := q.Query(`SELECT user_name, user_id, team, main_user_id FROM pool_members WHERE pool_id = ? ORDER BY position`, poolId)
+	if err != nil {
+		return Pool{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user PoolUser
+		if err := rows.Scan(&user.User, &user.UserId, &user.Team, &user.MainUserId); err != nil {
+			return Pool{}, err
+		}
+		pool.UsersAndTeams = append(pool.UsersAndTeams, user)
+	}
+	return pool, rows.Err()
+}
+
+// GetPool returns the pool with its members, or ErrPoolNotFound
+func (s *SQLiteStore) GetPool(poolId string) (Pool, error) {
+	return readPool(s.db, poolId)
+}
+
+// UpdatePool reads a pool, lets update change it and writes it back, all in
+// one transaction. An error returned by update cancels the change.
+func (s *SQLiteStore) UpdatePool(poolId string, update func(pool *Pool) error) (Pool, error) {
+	var updated Pool
+	err := s.withTx(func(tx *sql.Tx) error {
+		pool, err := readPool(tx, poolId)
+		if err != nil {
+			return err
+		}
+		revision := pool.Revision
+		if err := update(&pool); err != nil {
+			return err
+		}
+		pool.Id = poolId
+		pool.Revision = revision + 1
+
+		_, err = tx.Exec(`UPDATE pools SET note = ?, topology_id = ?, type = ?, revision = ? WHERE id = ?`,
+			pool.Note, pool.TopologyId, pool.Type, pool.Revision, poolId)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM pool_members WHERE pool_id = ?`, poolId); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM pool_main_users WHERE pool_id = ?`, poolId); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(`DELETE FROM pool_access WHERE pool_id = ?`, poolId); err != nil {
+			return err
+		}
+		if err := insertPoolAccess(tx, pool); err != nil {
+			return err
+		}
+		if err := insertPoolUsers(tx, pool); err != nil {
+			return err
+		}
+		updated = pool
+		return nil
+	})
+	return updated, err
+}
+
+// DeletePool removes a pool, its members and CTFd data, or returns ErrPoolNotFound
+func (s *SQLiteStore) DeletePool(poolId string) error {
+	err := s.withTx(func(tx *sql.Tx) error {
+		result, err := tx.Exec(`DELETE FROM pools WHERE id = ?`, poolId)
+		if err != nil {
+			return err
+		}
+		if deleted, _ := result.RowsAffected(); deleted == 0 {
+			return ErrPoolNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// The pool folder only holds CTFd data, it goes once the pool is gone for good
+	return os.RemoveAll(filepath.Join(s.poolFolder, poolId))
+}
+
+// ListPools returns all pools with their access lists but without their members,
+// oldest first
+func (s *SQLiteStore) ListPools() ([]Pool, error) {
+	rows, err := s.db.Query(`SELECT id, created_by, note, topology_id, type, created_at, revision FROM pools ORDER BY created_at, id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pools []Pool
+	for rows.Next() {
+		var pool Pool
+		var createdAt string
+		if err := rows.Scan(&pool.Id, &pool.CreatedBy, &pool.Note, &pool.TopologyId, &pool.Type, &createdAt, &pool.Revision); err != nil {
+			return nil, err
+		}
+		pool.CreatedAt = parseStoreTime(createdAt)
+		pools = append(pools, pool)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	byId := make(map[string]*Pool, len(pools))
+	for i := range pools {
+		byId[pools[i].Id] = &pools[i]
+	}
+	if err := readPoolAccess(s.db, "", byId); err != nil {
+		return nil, err
+	}
+	return pools, nil
+}
+
+// collectIds runs a query selecting one id column into a set
+func (s *SQLiteStore) collectIds(query string, args ...any) (map[string]bool, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[string]bool)
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// MainUserIds returns the main users of all pools
+func (s *SQLiteStore) MainUserIds() (map[string]bool, error) {
+	return s.collectIds(`SELECT main_user_id FROM pool_main_users`)
+}
+
+// PoolUserIds returns the userIds and main users of all pools
+func (s *SQLiteStore) PoolUserIds() (map[string]bool, error) {
+	return s.collectIds(`SELECT user_id FROM pool_members UNION SELECT main_user_id FROM pool_main_users`)
+}
+
+// AnyUserInPools reports whether one of userIds is a member or main user of any pool
+func (s *SQLiteStore) AnyUserInPools(userIds []string) (bool, error) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(userIds)), ",")
+	args := make([]any, 0, 2*len(userIds))
+	for _, id := range userIds {
+		args = append(args, id)
+	}
+	args = append(args, args...)
+
+	var used bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pool_members WHERE user_id IN (`+placeholders+`))
+		OR EXISTS (SELECT 1 FROM pool_main_users WHERE main_user_id IN (`+placeholders+`))`, args...).Scan(&used)
+	return used, err
+}
+
+// AddPoolHistory appends an entry to the history of its pool
+func (s *SQLiteStore) AddPoolHistory(entry PoolHistoryEntry) error {
+	changes, err := json.Marshal(entry.Changes)
+	if err != nil {
+		return err
+	}
+	var pool []byte
+	if entry.Pool != nil {
+		if pool, err = json.Marshal(entry.Pool); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.db.Exec(`INSERT INTO pool_history (pool_id, revision, action, user_id, created_at, changes, pool) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		entry.PoolId, entry.Revision, entry.Action, entry.UserId, formatStoreTime(entry.Timestamp), string(changes), nullableString(pool))
+	return err
+}
+
+// GetPoolHistory returns the history of a pool, oldest first
+func (s *SQLiteStore) GetPoolHistory(poolId string) ([]PoolHistoryEntry, error) {
+	rows, err := s.db.Query(`SELECT revision, action, user_id, created_at, changes, pool FROM pool_history WHERE pool_id = ? ORDER BY id`, poolId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var history []PoolHistoryEntry
+	for rows.Next() {
+		entry := PoolHistoryEntry{PoolId: poolId}
+		var createdAt, changes string
+		var pool sql.NullString
+		if err := rows.Scan(&entry.Revision, &entry.Action, &entry.UserId, &createdAt, &changes, &pool); err != nil {
+			return nil, err
+		}
+		entry.Timestamp = parseStoreTime(createdAt)
+		if err := json.Unmarshal([]byte(changes), &entry.Changes); err != nil {
+			return nil, err
+		}
+		if pool.Valid {
+			entry.Pool = &Pool{}
+			if err := json.Unmarshal([]byte(pool.String), entry.Pool); err != nil {
+				return nil, err
+			}
+			entry.Pool.Id = poolId
+		}
+		history = append(history, entry)
+	}
+	return history, rows.Err()
+}
+
+// nullableString stores an empty value as NULL
+func nullableString(value []byte) any {
+	if value == nil {
+		return nil
+	}
+	return string(value)
+}
+
+// SaveTopology writes the topology file and records it
+func (s *SQLiteStore) SaveTopology(topologyId, fileName string, content []byte) (string, error) {
+	topologyId, err := s.topologies.save(topologyId, fileName, content)
+	if err != nil {
+		return "", err
+	}
+	return topologyId, s.saveTopologyRecord(StoredItem{Id: topologyId, Name: filepath.Base(fileName), CreatedAt: time.Now()})
+}
+
+func (s *SQLiteStore) saveTopologyRecord(item StoredItem) error {
+	_, err := s.db.Exec(`INSERT INTO topologies (id, name, created_at) VALUES (?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, created_at = excluded.created_at`,
+		item.Id, item.Name, formatStoreTime(item.CreatedAt))
+	return err
+}
+
+// GetTopology reads the topology file
+func (s *SQLiteStore) GetTopology(topologyId string) (StoredFile, error) {
+	return s.topologies.read(topologyId)
+}
+
+// DeleteTopology forgets a topology unless a pool or template still uses it (ErrTopologyInUse)
+// and removes its file
+func (s *SQLiteStore) DeleteTopology(topologyId string) error {
+	if _, err := s.topologies.read(topologyId); err != nil {
+		return err
+	}
+
+	err := s.withTx(func(tx *sql.Tx) error {
+		var inUse bool
+		err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM pools WHERE topology_id = ?) OR EXISTS (SELECT 1 FROM pool_templates WHERE topology_id = ?)`,
+			topologyId, topologyId).Scan(&inUse)
+		if err != nil {
+			return err
+		}
+		if inUse {
+			return ErrTopologyInUse
+		}
+		_, err = tx.Exec(`DELETE FROM topologies WHERE id = ?`, topologyId)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return s.topologies.remove(topologyId)
+}
+
+// ListTopologies returns all topologies, oldest first
+func (s *SQLiteStore) ListTopologies() ([]StoredItem, error) {
+	return s.listItems(`SELECT id, name, '', created_at FROM topologies ORDER BY created_at, id`)
+}
+
+// SaveScenario writes the scenario archive and records it with its user mode
+func (s *SQLiteStore) SaveScenario(scenarioId, fileName, scenarioMode string, content []byte) (string, error) {
+	scenarioId, err := s.scenarios.save(scenarioId, fileName, content)
+	if err != nil {
+		return "", err
+	}
+	return scenarioId, s.saveScenarioRecord(StoredItem{Id: scenarioId, Name: filepath.Base(fileName), Mode: scenarioMode, CreatedAt: time.Now()})
+}
+
+func (s *SQLiteStore) saveScenarioRecord(item StoredItem) error {
+	_, err := s.db.Exec(`INSERT INTO scenarios (id, name, mode, created_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (id) DO UPDATE SET name = excluded.name, mode = excluded.mode, created_at = excluded.created_at`,
+		item.Id, item.Name, item.Mode, formatStoreTime(item.CreatedAt))
+	return err
+}
+
+// GetScenario reads the scenario archive and its recorded user mode
+func (s *SQLiteStore) GetScenario(scenarioId string) (StoredFile, error) {
+	scenario, err := s.scenarios.read(scenarioId)
+	if err != nil {
+		return StoredFile{}, err
+	}
+	err = s.db.QueryRow(`SELECT mode FROM scenarios WHERE id = ?`, scenarioId).Scan(&scenario.Mode)
+	if err == sql.ErrNoRows {
+		scenario.Mode, _ = GetScenarioModeFromZip(scenario.Content)
+		err = nil
+	}
+	return scenario, err
+}
+
+// DeleteScenario forgets a scenario and removes its archive
+func (s *SQLiteStore) DeleteScenario(scenarioId string) error {
+	if err := s.scenarios.remove(scenarioId); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`DELETE FROM scenarios WHERE id = ?`, scenarioId)
+	return err
+}
+
+// ListScenarios returns all scenarios, oldest first
+func (s *SQLiteStore) ListScenarios() ([]StoredItem, error) {
+	return s.listItems(`SELECT id, name, mode, created_at FROM scenarios ORDER BY created_at, id`)
+}
+
+// CreateTemplate stores a new pool template under a generated id and returns the id
+func (s *SQLiteStore) CreateTemplate(template PoolTemplate) (string, error) {
+	if template.CreatedAt.IsZero() {
+		template.CreatedAt = time.Now()
+	}
+	teams, err := json.Marshal(append([]string{}, template.Teams...))
+	if err != nil {
+		return "", err
+	}
+
+	err = s.withTx(func(tx *sql.Tx) error {
+		for {
+			template.Id = RandomString(6)
+			var exists bool
+			if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM pool_templates WHERE id = ?)`, template.Id).Scan(&exists); err != nil {
+				return err
+			}
+			if !exists {
+				break
+			}
+		}
+		_, err := tx.Exec(`INSERT INTO pool_templates (id, name, type, topology_id, note, teams, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			template.Id, template.Name, template.Type, template.TopologyId, template.Note, string(teams), template.CreatedBy, formatStoreTime(template.CreatedAt))
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return template.Id, nil
+}
+
+// GetTemplate returns a pool template, or ErrNotFound
+func (s *SQLiteStore) GetTemplate(templateId string) (PoolTemplate, error) {
+	templates, err := s.queryTemplates(`WHERE id = ?`, templateId)
+	if err != nil {
+		return PoolTemplate{}, err
+	}
+	if len(templates) == 0 {
+		return PoolTemplate{}, ErrNotFound
+	}
+	return templates[0], nil
+}
+
+// ListTemplates returns all pool templates, oldest first
+func (s *SQLiteStore) ListTemplates() ([]PoolTemplate, error) {
+	return s.queryTemplates(`ORDER BY created_at, id`)
+}
+
+// DeleteTemplate forgets a pool template
+func (s *SQLiteStore) DeleteTemplate(templateId string) error {
+	result, err := s.db.Exec(`DELETE FROM pool_templates WHERE id = ?`, templateId)
+	if err != nil {
+		return err
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *SQLiteStore) queryTemplates(clause string, args ...any) ([]PoolTemplate, error) {
+	rows, err := s.db.Query(`SELECT id, name, type, topology_id, note, teams, created_by, created_at FROM pool_templates `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []PoolTemplate
+	for rows.Next() {
+		var template PoolTemplate
+		var teams, createdAt string
+		if err := rows.Scan(&template.Id, &template.Name, &template.Type, &template.TopologyId, &template.Note, &teams, &template.CreatedBy, &createdAt); err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(teams), &template.Teams); err != nil {
+			return nil, err
+		}
+		template.CreatedAt = parseStoreTime(createdAt)
+		templates = append(templates, template)
+	}
+	return templates, rows.Err()
+}
+
+func (s *SQLiteStore) listItems(query string) ([]StoredItem, error) {
+	rows, err := s.db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []StoredItem
+	for rows.Next() {
+		var item StoredItem
+		var createdAt string
+		if err := rows.Scan(&item.Id, &item.Name, &item.Mode, &createdAt); err != nil {
+			return nil, err
+		}
+		item.CreatedAt = parseStoreTime(createdAt)
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// FolderMigrationReport lists the pools imported from pool folders on startup
+// and the ones that were skipped
+type FolderMigrationReport struct {
+	MigratedPoolIds []string               `json:"migratedPoolIds"`
+	SkippedPools    []SkippedPoolMigration `json:"skippedPools"`
+}
+
+// SkippedPoolMigration is a pool folder that could not be imported
+type SkippedPoolMigration struct {
+	PoolId string `json:"poolId"`
+	Error  string `json:"error"`
+}
+
+// poolMigrationErrorFile is written next to a pool.json that could not be imported
+const poolMigrationErrorFile = "pool.json.error"
+
+// migrateFolders imports pools still stored as pools/<id>/pool.json and
+// topology and scenario folders the database does not know yet. An imported
+// pool.json is renamed to pool.json.migrated, CTFd data stays in the folder.
+// Pools that cannot be imported, e.g. because they break the store rules, keep
+// their pool.json so they are tried again on the next start; the error is
+// written to pool.json.error and the pool is listed in the report.
+func (s *SQLiteStore) migrateFolders() (FolderMigrationReport, error) {
+	report := FolderMigrationReport{MigratedPoolIds: []string{}, SkippedPools: []SkippedPoolMigration{}}
+
+	if err := s.migrateItems(s.topologies.base, s.ListTopologies, s.saveTopologyRecord); err != nil {
+		return report, fmt.Errorf("failed to migrate topologies: %w", err)
+	}
+
+	if err := s.migrateItems(s.scenarios.base, s.ListScenarios, func(item StoredItem) error {
+		if scenario, err := s.scenarios.read(item.Id); err == nil {
+			item.Mode, _ = GetScenarioModeFromZip(scenario.Content)
+		}
+		return s.saveScenarioRecord(item)
+	}); err != nil {
+		return report, fmt.Errorf("failed to migrate scenarios: %w", err)
+	}
+
+	poolDirs, err := os.ReadDir(s.poolFolder)
+	if err != nil {
+		return report, fmt.Errorf("failed to read pool folder: %w", err)
+	}
+
+	for _, dir := range poolDirs {
+		poolJsonPath := filepath.Join(s.poolFolder, dir.Name(), "pool.json")
+		if !dir.IsDir() || !validFolderIDRegex.MatchString(dir.Name()) || !FileExists(poolJsonPath) {
+			continue
+		}
+
+		errorPath := filepath.Join(s.poolFolder, dir.Name(), poolMigrationErrorFile)
+		if err := s.migratePool(dir.Name(), poolJsonPath); err != nil {
+			log.Printf("Pool %s was not migrated: %v", dir.Name(), err)
+			report.SkippedPools = append(report.SkippedPools, SkippedPoolMigration{PoolId: dir.Name(), Error: err.Error()})
+			if writeErr := os.WriteFile(errorPath, []byte(err.Error()+"\n"), os.ModePerm); writeErr != nil {
+				log.Printf("Failed to record the migration error of pool %s: %v", dir.Name(), writeErr)
+			}
+			continue
+		}
+		if err := os.Rename(poolJsonPath, poolJsonPath+".migrated"); err != nil {
+			return report, err
+		}
+		os.Remove(errorPath)
+		report.MigratedPoolIds = append(report.MigratedPoolIds, dir.Name())
+		log.Printf("Pool %s migrated to the store database", dir.Name())
+	}
+
+	return report, nil
+}
+
+func (s *SQLiteStore) migratePool(poolId, poolJsonPath string) error {
+	data, err := os.ReadFile(poolJsonPath)
+	if err != nil {
+		return err
+	}
+
+	var pool Pool
+	if err := json.Unmarshal(data, &pool); err != nil {
+		return err
+	}
+	pool.Id = poolId
+	pool.CreatedAt = time.Now()
+	if fileInfo, err := os.Stat(poolJsonPath); err == nil {
+		pool.CreatedAt = fileInfo.ModTime()
+	}
+
+	return s.withTx(func(tx *sql.Tx) error {
+		var exists bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM pools WHERE id = ?)`, poolId).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return nil
+		}
+		return insertPool(tx, pool)
+	})
+}
+
+// migrateItems records item folders of baseFolder that are not listed yet
+func (s *SQLiteStore) migrateItems(baseFolder string, list func() ([]StoredItem, error), save func(StoredItem) error) error {
+	known, err := list()
+	if err != nil {
+		return err
+	}
+	knownIds := make(map[string]bool, len(known))
+	for _, item := range known {
+		knownIds[item.Id] = true
+	}
+
+	dirs, err := os.ReadDir(baseFolder)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range dirs {
+		if !dir.IsDir() || knownIds[dir.Name()] || !validFolderIDRegex.MatchString(dir.Name()) {
+			continue
+		}
+		item, err := statItemFolder(filepath.Join(baseFolder, dir.Name()))
+		if err != nil {
+			continue // Skip empty folders
+		}
+		item.Id = dir.Name()
+		if err := save(item); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetRole assigns a role to a user, replacing an earlier assignment
+func (s *SQLiteStore) SetRole(assignment RoleAssignment) error {
+	_, err := s.db.Exec(`INSERT INTO user_roles (user_id, role, assigned_by, assigned_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id) DO UPDATE SET role = excluded.role, assigned_by = excluded.assigned_by, assigned_at = excluded.assigned_at`,
+		assignment.UserId, assignment.Role, assignment.AssignedBy, formatStoreTime(assignment.AssignedAt))
+	return err
+}
+
+// GetRole returns the role assigned to a user, or ErrNotFound
+func (s *SQLiteStore) GetRole(userId string) (RoleAssignment, error) {
+	assignments, err := s.queryRoles(`WHERE user_id = ?`, userId)
+	if err != nil {
+		return RoleAssignment{}, err
+	}
+	if len(assignments) == 0 {
+		return RoleAssignment{}, ErrNotFound
+	}
+	return assignments[0], nil
+}
+
+// ListRoles returns all role assignments ordered by userId
+func (s *SQLiteStore) ListRoles() ([]RoleAssignment, error) {
+	return s.queryRoles(`ORDER BY user_id`)
+}
+
+// DeleteRole removes the role assigned to a user
+func (s *SQLiteStore) DeleteRole(userId string) error {
+	result, err := s.db.Exec(`DELETE FROM user_roles WHERE user_id = ?`, userId)
+	if err != nil {
+		return err
+	}
+	if deleted, err := result.RowsAffected(); err == nil && deleted == 0 {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *SQLiteStore) queryRoles(clause string, args ...any) ([]RoleAssignment, error) {
+	rows, err := s.db.Query(`SELECT user_id, role, assigned_by, assigned_at FROM user_roles `+clause, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var assignments []RoleAssignment
+	for rows.Next() {
+		var assignment RoleAssignment
+		var assignedAt string
+		if err := rows.Scan(&assignment.UserId, &assignment.Role, &assignment.AssignedBy, &assignedAt); err != nil {
+			return nil, err
+		}
+		assignment.AssignedAt = parseStoreTime(assignedAt)
+		assignments = append(assignments, assignment)
+	}
+	return assignments, rows.Err()
+}
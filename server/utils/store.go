@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"dulus/server/config"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound         = errors.New("not found")
+	ErrPoolNotFound     = fmt.Errorf("pool %w", ErrNotFound)
+	ErrInvalidPool      = errors.New("invalid pool")
+	ErrTopologyInUse    = errors.New("topology is used by a pool or template")
+	ErrRevisionMismatch = errors.New("pool revision does not match")
+)
+
+// StoredItem is a topology or scenario: an id with a single file
+type StoredItem struct {
+	Id        string
+	Name      string
+	Mode      string
+	CreatedAt time.Time
+}
+
+// StoredFile is a stored item together with its file content
+type StoredFile struct {
+	StoredItem
+	Content []byte
+}
+
+// PoolStore keeps pools and their members. UpdatePool applies update to the
+// current pool and saves the result atomically, so concurrent updates of one
+// pool do not overwrite each other. A new pool has revision 1 and every update
+// increments it. A main user belongs to at most one pool; changes breaking
+// that rule fail with ErrInvalidPool.
+type PoolStore interface {
+	CreatePool(pool Pool) (string, error)
+	GetPool(poolId string) (Pool, error)
+	UpdatePool(poolId string, update func(pool *Pool) error) (Pool, error)
+	DeletePool(poolId string) error
+	ListPools() ([]Pool, error)
+	MainUserIds() (map[string]bool, error)
+	PoolUserIds() (map[string]bool, error)
+	AnyUserInPools(userIds []string) (bool, error)
+}
+
+// TopologyStore keeps Ludus range topologies. SaveTopology with an empty id
+// creates a new topology, otherwise it replaces the file of an existing one.
+// DeleteTopology fails with ErrTopologyInUse while a pool or template uses the topology.
+type TopologyStore interface {
+	SaveTopology(topologyId, fileName string, content []byte) (string, error)
+	GetTopology(topologyId string) (StoredFile, error)
+	ListTopologies() ([]StoredItem, error)
+	DeleteTopology(topologyId string) error
+}
+
+// ScenarioStore keeps CTFd scenario archives with their user mode
+type ScenarioStore interface {
+	SaveScenario(scenarioId, fileName, scenarioMode string, content []byte) (string, error)
+	GetScenario(scenarioId string) (StoredFile, error)
+	ListScenarios() ([]StoredItem, error)
+	DeleteScenario(scenarioId string) error
+}
+
+// CtfdDataStore keeps the generated CTFd logins and flags of a pool
+type CtfdDataStore interface {
+	GetCtfdData(poolId string) (CtfdData, error)
+	SaveCtfdData(poolId string, data CtfdData) error
+	DeleteCtfdData(poolId string) error
+	HasCtfdData(poolId string) bool
+}
+
+// PoolHistoryStore keeps the recorded changes of pools, also after a pool is
+// deleted. GetPoolHistory returns them oldest first; ids of pools with history
+// are never given to new pools.
+type PoolHistoryStore interface {
+	AddPoolHistory(entry PoolHistoryEntry) error
+	GetPoolHistory(poolId string) ([]PoolHistoryEntry, error)
+}
+
+// TemplateStore keeps pool templates. A template is not changed once created.
+type TemplateStore interface {
+	CreateTemplate(template PoolTemplate) (string, error)
+	GetTemplate(templateId string) (PoolTemplate, error)
+	ListTemplates() ([]PoolTemplate, error)
+	DeleteTemplate(templateId string) error
+}
+
+// RoleStore keeps the roles assigned to users. SetRole replaces an earlier
+// assignment, GetRole returns ErrNotFound for a user without one.
+type RoleStore interface {
+	SetRole(assignment RoleAssignment) error
+	GetRole(userId string) (RoleAssignment, error)
+	ListRoles() ([]RoleAssignment, error)
+	DeleteRole(userId string) error
+}
+
+// Store is the persistence of everything except jobs and schedules
+type Store interface {
+	PoolStore
+	PoolHistoryStore
+	TopologyStore
+	ScenarioStore
+	CtfdDataStore
+	TemplateStore
+	RoleStore
+	Close() error
+}
+
+var store Store
+
+// folderMigrationReport is the outcome of importing pool folders on startup
+var folderMigrationReport = FolderMigrationReport{MigratedPoolIds: []string{}, SkippedPools: []SkippedPoolMigration{}}
+
+// InitStore opens the store selected by STORE_BACKEND: "sqlite" (default)
+// imports pool folders into the database on first start, "filesystem" keeps
+// everything in the data folders
+func InitStore() error {
+	switch config.StoreBackend {
+	case "filesystem":
+		store = NewFileStore(config.PoolFolder, config.TopologyConfigFolder, config.CtfdScenarioFolder, config.PoolTemplateFolder, config.RoleFile)
+		return nil
+	default:
+		sqliteStore, err := OpenSQLiteStore(config.StoreDatabaseLocation, config.PoolFolder, config.TopologyConfigFolder, config.CtfdScenarioFolder)
+		if err != nil {
+			return err
+		}
+		store = sqliteStore
+
+		report, err := sqliteStore.migrateFolders()
+		folderMigrationReport = report
+		if len(report.SkippedPools) > 0 {
+			skipped := make([]string, len(report.SkippedPools))
+			for i, pool := range report.SkippedPools {
+				skipped[i] = pool.PoolId
+			}
+			log.Printf("%d pools were not migrated and are retried on the next start: %s",
+				len(skipped), strings.Join(skipped, ", "))
+		}
+		return err
+	}
+}
+
+// FolderMigration returns the report of the pool folder import on startup
+func FolderMigration() FolderMigrationReport {
+	return folderMigrationReport
+}
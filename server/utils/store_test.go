@@ -0,0 +1,424 @@
+package utils
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// storeBackends creates an empty store of every backend in a temporary directory
+var storeBackends = map[string]func(t *testing.T) Store{
+	"memory": func(t *testing.T) Store {
+		return NewMemoryStore()
+	},
+	"filesystem": func(t *testing.T) Store {
+		dir := t.TempDir()
+		// The data folders exist before the server starts
+		folders := []string{"pools", "topologies", "scenarios", "templates"}
+		for i, folder := range folders {
+			folders[i] = filepath.Join(dir, folder)
+			if err := os.MkdirAll(folders[i], os.ModePerm); err != nil {
+				t.Fatalf("create data folder: %v", err)
+			}
+		}
+		return NewFileStore(folders[0], folders[1], folders[2], folders[3], filepath.Join(dir, "roles.json"))
+	},
+	"sqlite": func(t *testing.T) Store {
+		dir := t.TempDir()
+		s, err := OpenSQLiteStore(filepath.Join(dir, "store.db"), filepath.Join(dir, "pools"),
+			filepath.Join(dir, "topologies"), filepath.Join(dir, "scenarios"))
+		if err != nil {
+			t.Fatalf("open store: %v", err)
+		}
+		return s
+	},
+}
+
+// testStores runs a store contract test against every backend
+func testStores(t *testing.T, test func(t *testing.T, s Store)) {
+	for name, open := range storeBackends {
+		t.Run(name, func(t *testing.T) {
+			s := open(t)
+			t.Cleanup(func() { s.Close() })
+			test(t, s)
+		})
+	}
+}
+
+func storeTestPool(topologyId string, users ...PoolUser) Pool {
+	return Pool{CreatedBy: "ADMIN", Note: "note", TopologyId: topologyId, Type: "INDIVIDUAL", UsersAndTeams: users}
+}
+
+// scenarioArchive builds a CTFd export whose config sets the user mode
+func scenarioArchive(t *testing.T, userMode string) []byte {
+	t.Helper()
+	var buffer bytes.Buffer
+	archive := zip.NewWriter(&buffer)
+	file, err := archive.Create("db/config.json")
+	if err != nil {
+		t.Fatalf("create archive: %v", err)
+	}
+	file.Write([]byte(`{"results": [{"key": "user_mode", "value": "` + userMode + `"}]}`))
+	if err := archive.Close(); err != nil {
+		t.Fatalf("create archive: %v", err)
+	}
+	return buffer.Bytes()
+}
+
+func TestStorePools(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		poolId, err := s.CreatePool(storeTestPool("topo", PoolUser{User: "Jane Doe", UserId: "JD"}))
+		if err != nil {
+			t.Fatalf("create pool: %v", err)
+		}
+
+		pool, err := s.GetPool(poolId)
+		if err != nil {
+			t.Fatalf("get pool: %v", err)
+		}
+		if pool.Id != poolId || pool.Revision != 1 || pool.Note != "note" || pool.TopologyId != "topo" {
+			t.Fatalf("unexpected pool %+v", pool)
+		}
+		if want := []PoolUser{{User: "Jane Doe", UserId: "JD"}}; !reflect.DeepEqual(pool.UsersAndTeams, want) {
+			t.Fatalf("expected members %+v, got %+v", want, pool.UsersAndTeams)
+		}
+
+		updated, err := s.UpdatePool(poolId, func(pool *Pool) error {
+			pool.Note = "changed"
+			pool.UsersAndTeams = append(pool.UsersAndTeams, PoolUser{User: "John Roe", UserId: "JR"})
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("update pool: %v", err)
+		}
+		if updated.Revision != 2 || updated.Note != "changed" || len(updated.UsersAndTeams) != 2 {
+			t.Fatalf("unexpected updated pool %+v", updated)
+		}
+
+		errRejected := errors.New("rejected")
+		if _, err := s.UpdatePool(poolId, func(pool *Pool) error {
+			pool.Note = "not saved"
+			return errRejected
+		}); !errors.Is(err, errRejected) {
+			t.Fatalf("expected the update error, got %v", err)
+		}
+		if pool, _ := s.GetPool(poolId); pool.Revision != 2 || pool.Note != "changed" {
+			t.Fatalf("failed update was saved: %+v", pool)
+		}
+
+		pools, err := s.ListPools()
+		if err != nil || len(pools) != 1 {
+			t.Fatalf("expected one pool, got %d (%v)", len(pools), err)
+		}
+		if inPools, err := s.AnyUserInPools([]string{"XX", "JR"}); err != nil || !inPools {
+			t.Fatalf("expected JR in a pool, got %v (%v)", inPools, err)
+		}
+		if userIds, err := s.PoolUserIds(); err != nil || !userIds["JD"] || !userIds["JR"] {
+			t.Fatalf("unexpected pool userIds %v (%v)", userIds, err)
+		}
+
+		if err := s.DeletePool(poolId); err != nil {
+			t.Fatalf("delete pool: %v", err)
+		}
+		if _, err := s.GetPool(poolId); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound after delete, got %v", err)
+		}
+		if err := s.DeletePool(poolId); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound deleting twice, got %v", err)
+		}
+		if _, err := s.UpdatePool(poolId, func(pool *Pool) error { return nil }); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound updating a deleted pool, got %v", err)
+		}
+	})
+}
+
+func TestStorePoolRules(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		shared := storeTestPool("topo", PoolUser{User: "Jane Doe", UserId: "JD", MainUserId: "MAIN"})
+		shared.Type = "SHARED"
+		poolId, err := s.CreatePool(shared)
+		if err != nil {
+			t.Fatalf("create pool: %v", err)
+		}
+		if mainUserIds, err := s.MainUserIds(); err != nil || !mainUserIds["MAIN"] {
+			t.Fatalf("expected MAIN as main user, got %v (%v)", mainUserIds, err)
+		}
+
+		tests := []struct {
+			name string
+			pool Pool
+		}{
+			{name: "main user of another pool", pool: storeTestPool("topo", PoolUser{User: "John Roe", UserId: "JR", MainUserId: "MAIN"})},
+			{name: "member is a main user", pool: storeTestPool("topo", PoolUser{User: "Main", UserId: "MAIN"})},
+			{name: "duplicate userId", pool: storeTestPool("topo", PoolUser{User: "A", UserId: "AA"}, PoolUser{User: "B", UserId: "AA"})},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				if _, err := s.CreatePool(tt.pool); !errors.Is(err, ErrInvalidPool) {
+					t.Fatalf("expected ErrInvalidPool, got %v", err)
+				}
+			})
+		}
+
+		if _, err := s.UpdatePool(poolId, func(pool *Pool) error {
+			pool.UsersAndTeams = append(pool.UsersAndTeams, PoolUser{User: "Jane Doe", UserId: "JD"})
+			return nil
+		}); !errors.Is(err, ErrInvalidPool) {
+			t.Fatalf("expected ErrInvalidPool for a duplicate member, got %v", err)
+		}
+		if pool, _ := s.GetPool(poolId); pool.Revision != 1 {
+			t.Fatalf("invalid update was saved: %+v", pool)
+		}
+	})
+}
+
+func TestStorePoolHistory(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		poolId, err := s.CreatePool(storeTestPool("topo"))
+		if err != nil {
+			t.Fatalf("create pool: %v", err)
+		}
+		pool, _ := s.GetPool(poolId)
+		timestamp := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		for revision, action := range []string{"create", "update"} {
+			entry := PoolHistoryEntry{PoolId: poolId, Revision: revision + 1, Action: action, UserId: "ADMIN", Timestamp: timestamp, Pool: &pool}
+			if err := s.AddPoolHistory(entry); err != nil {
+				t.Fatalf("add history: %v", err)
+			}
+		}
+		if err := s.DeletePool(poolId); err != nil {
+			t.Fatalf("delete pool: %v", err)
+		}
+
+		history, err := s.GetPoolHistory(poolId)
+		if err != nil {
+			t.Fatalf("get history: %v", err)
+		}
+		if len(history) != 2 || history[0].Action != "create" || history[1].Action != "update" {
+			t.Fatalf("expected create and update oldest first, got %+v", history)
+		}
+		if history[1].Pool == nil || history[1].Pool.Id != poolId || !history[1].Timestamp.Equal(timestamp) {
+			t.Fatalf("unexpected history entry %+v", history[1])
+		}
+	})
+}
+
+func TestStoreTopologies(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		topologyId, err := s.SaveTopology("", "range.yml", []byte("ludus: []"))
+		if err != nil {
+			t.Fatalf("save topology: %v", err)
+		}
+		if _, err := s.SaveTopology(topologyId, "range.yml", []byte("ludus: [vm]")); err != nil {
+			t.Fatalf("replace topology: %v", err)
+		}
+
+		topology, err := s.GetTopology(topologyId)
+		if err != nil {
+			t.Fatalf("get topology: %v", err)
+		}
+		if topology.Id != topologyId || topology.Name != "range.yml" || string(topology.Content) != "ludus: [vm]" {
+			t.Fatalf("unexpected topology %+v", topology)
+		}
+		if list, err := s.ListTopologies(); err != nil || len(list) != 1 {
+			t.Fatalf("expected one topology, got %d (%v)", len(list), err)
+		}
+
+		poolId, err := s.CreatePool(storeTestPool(topologyId))
+		if err != nil {
+			t.Fatalf("create pool: %v", err)
+		}
+		if err := s.DeleteTopology(topologyId); !errors.Is(err, ErrTopologyInUse) {
+			t.Fatalf("expected ErrTopologyInUse, got %v", err)
+		}
+		s.DeletePool(poolId)
+
+		if err := s.DeleteTopology(topologyId); err != nil {
+			t.Fatalf("delete topology: %v", err)
+		}
+		if _, err := s.GetTopology(topologyId); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound after delete, got %v", err)
+		}
+	})
+}
+
+func TestStoreScenarios(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		scenarioId, err := s.SaveScenario("", "scenario.zip", "TEAMS", scenarioArchive(t, "teams"))
+		if err != nil {
+			t.Fatalf("save scenario: %v", err)
+		}
+
+		scenario, err := s.GetScenario(scenarioId)
+		if err != nil {
+			t.Fatalf("get scenario: %v", err)
+		}
+		if scenario.Name != "scenario.zip" || scenario.Mode != "TEAMS" || len(scenario.Content) == 0 {
+			t.Fatalf("unexpected scenario %+v", scenario)
+		}
+
+		if err := s.DeleteScenario(scenarioId); err != nil {
+			t.Fatalf("delete scenario: %v", err)
+		}
+		if err := s.DeleteScenario(scenarioId); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound deleting twice, got %v", err)
+		}
+	})
+}
+
+func TestStoreCtfdData(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		poolId, err := s.CreatePool(storeTestPool("topo", PoolUser{User: "Jane Doe", UserId: "JD"}))
+		if err != nil {
+			t.Fatalf("create pool: %v", err)
+		}
+		if s.HasCtfdData(poolId) {
+			t.Fatal("new pool has CTFd data")
+		}
+
+		data := CtfdData{CtfdData: []CtfdUser{{}}}
+		if err := s.SaveCtfdData(poolId, data); err != nil {
+			t.Fatalf("save CTFd data: %v", err)
+		}
+		if !s.HasCtfdData(poolId) {
+			t.Fatal("saved CTFd data not found")
+		}
+		if saved, err := s.GetCtfdData(poolId); err != nil || len(saved.CtfdData) != 1 {
+			t.Fatalf("unexpected CTFd data %+v (%v)", saved, err)
+		}
+
+		if err := s.DeleteCtfdData(poolId); err != nil {
+			t.Fatalf("delete CTFd data: %v", err)
+		}
+		if _, err := s.GetCtfdData(poolId); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound after delete, got %v", err)
+		}
+	})
+}
+
+func TestStoreTemplates(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		templateId, err := s.CreateTemplate(PoolTemplate{Name: "course", Type: "INDIVIDUAL", TopologyId: "topo", Teams: []string{"red", "blue"}, CreatedBy: "ADMIN"})
+		if err != nil {
+			t.Fatalf("create template: %v", err)
+		}
+
+		template, err := s.GetTemplate(templateId)
+		if err != nil {
+			t.Fatalf("get template: %v", err)
+		}
+		if template.Id != templateId || template.Name != "course" || !reflect.DeepEqual(template.Teams, []string{"red", "blue"}) {
+			t.Fatalf("unexpected template %+v", template)
+		}
+		if list, err := s.ListTemplates(); err != nil || len(list) != 1 {
+			t.Fatalf("expected one template, got %d (%v)", len(list), err)
+		}
+
+		if err := s.DeleteTemplate(templateId); err != nil {
+			t.Fatalf("delete template: %v", err)
+		}
+		if _, err := s.GetTemplate(templateId); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound after delete, got %v", err)
+		}
+	})
+}
+
+func TestStoreRoles(t *testing.T) {
+	testStores(t, func(t *testing.T, s Store) {
+		if _, err := s.GetRole("JD"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound without a role, got %v", err)
+		}
+
+		assignedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		for _, role := range []string{RoleStudent, RoleInstructor} {
+			if err := s.SetRole(RoleAssignment{UserId: "JD", Role: role, AssignedBy: "ADMIN", AssignedAt: assignedAt}); err != nil {
+				t.Fatalf("set role: %v", err)
+			}
+		}
+		if err := s.SetRole(RoleAssignment{UserId: "AB", Role: RoleStudent, AssignedBy: "ADMIN", AssignedAt: assignedAt}); err != nil {
+			t.Fatalf("set role: %v", err)
+		}
+
+		assignment, err := s.GetRole("JD")
+		if err != nil || assignment.Role != RoleInstructor || !assignment.AssignedAt.Equal(assignedAt) {
+			t.Fatalf("expected the replaced role, got %+v (%v)", assignment, err)
+		}
+		roles, err := s.ListRoles()
+		if err != nil || len(roles) != 2 || roles[0].UserId != "AB" || roles[1].UserId != "JD" {
+			t.Fatalf("expected AB and JD sorted by userId, got %+v (%v)", roles, err)
+		}
+
+		if err := s.DeleteRole("JD"); err != nil {
+			t.Fatalf("delete role: %v", err)
+		}
+		if err := s.DeleteRole("JD"); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound deleting twice, got %v", err)
+		}
+	})
+}
+
+func TestSQLiteStoreMigrateFolders(t *testing.T) {
+	dir := t.TempDir()
+	folders := map[string]string{}
+	for _, folder := range []string{"pools", "topologies", "scenarios"} {
+		folders[folder] = filepath.Join(dir, folder)
+		if err := os.MkdirAll(folders[folder], os.ModePerm); err != nil {
+			t.Fatalf("create data folder: %v", err)
+		}
+	}
+	writePool := func(poolId, content string) {
+		if err := os.MkdirAll(filepath.Join(folders["pools"], poolId), os.ModePerm); err != nil {
+			t.Fatalf("create pool folder: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(folders["pools"], poolId, "pool.json"), []byte(content), os.ModePerm); err != nil {
+			t.Fatalf("write pool.json: %v", err)
+		}
+	}
+	writePool("good01", `{"type": "INDIVIDUAL", "topologyId": "topo", "usersAndTeams": [{"user": "Jane Doe", "userId": "JD"}]}`)
+	writePool("bad001", `{"type": "INDIVIDUAL", "usersAndTeams": [{"user": "A", "userId": "AA"}, {"user": "B", "userId": "AA"}]}`)
+
+	s, err := OpenSQLiteStore(filepath.Join(dir, "store.db"), folders["pools"], folders["topologies"], folders["scenarios"])
+	if err != nil {
+		t.Fatalf("open store: %v", err)
+	}
+	defer s.Close()
+
+	report, err := s.migrateFolders()
+	if err != nil {
+		t.Fatalf("migrate folders: %v", err)
+	}
+	if !reflect.DeepEqual(report.MigratedPoolIds, []string{"good01"}) {
+		t.Fatalf("expected good01 migrated, got %v", report.MigratedPoolIds)
+	}
+	if len(report.SkippedPools) != 1 || report.SkippedPools[0].PoolId != "bad001" || report.SkippedPools[0].Error == "" {
+		t.Fatalf("expected bad001 skipped with its error, got %+v", report.SkippedPools)
+	}
+
+	if _, err := s.GetPool("good01"); err != nil {
+		t.Fatalf("get migrated pool: %v", err)
+	}
+	if !FileExists(filepath.Join(folders["pools"], "good01", "pool.json.migrated")) {
+		t.Fatal("migrated pool.json was not renamed")
+	}
+	if !FileExists(filepath.Join(folders["pools"], "bad001", "pool.json")) || !FileExists(filepath.Join(folders["pools"], "bad001", poolMigrationErrorFile)) {
+		t.Fatal("skipped pool did not keep its pool.json with the error next to it")
+	}
+
+	// The skipped pool is tried again once it was fixed
+	writePool("bad001", `{"type": "INDIVIDUAL", "usersAndTeams": [{"user": "A", "userId": "AA"}]}`)
+	report, err = s.migrateFolders()
+	if err != nil {
+		t.Fatalf("migrate folders again: %v", err)
+	}
+	if !reflect.DeepEqual(report.MigratedPoolIds, []string{"bad001"}) || len(report.SkippedPools) != 0 {
+		t.Fatalf("expected bad001 migrated on retry, got %+v", report)
+	}
+	if FileExists(filepath.Join(folders["pools"], "bad001", poolMigrationErrorFile)) {
+		t.Fatal("error file of a migrated pool was kept")
+	}
+}
@@ -1,13 +1,14 @@
 package utils
 
 import (
+	"context"
 	"dulus/server/config"
 	"encoding/json"
 	"fmt"
-	"net/http"
-	"os"
-	"path/filepath"
+	"log"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 )
@@ -58,18 +59,108 @@ func replaceSpecialChars(s string) string {
 	return result.String()
 }
 
-// generateUserId generates userId from user name
-func generateUserId(user string) string {
-	// Remove spaces, convert to lowercase, replace special characters
+// maxUserIdLength is the length generated userIds are kept within, userIds must
+// be shorter than 20 characters
+const maxUserIdLength = 19
+
+// userIdBase returns the userId of a user name before shortening: "BATCH" and
+// the name in lowercase, without spaces and special characters
+func userIdBase(user string) string {
 	userId := strings.ToLower(strings.ReplaceAll(user, " ", ""))
 	userId = replaceSpecialChars(userId)
 	return "BATCH" + userId
 }
 
-// ProcessUsersAndTeams processes usersAndTeams and adds userId
-func ProcessUsersAndTeams(usersAndTeams []interface{}) []interface{} {
+// shortenUserId cuts a userId to length characters
+func shortenUserId(userId string, length int) string {
+	if len(userId) > length {
+		return userId[:length]
+	}
+	return userId
+}
+
+// generateUserId generates a userId from a user name that is not in taken and
+// adds it there. Long names are shortened; when the userId is taken, its end is
+// replaced with a number, starting at 2. The same names and taken userIds always
+// give the same userId.
+func generateUserId(user string, taken map[string]bool) string {
+	base := userIdBase(user)
+	userId := shortenUserId(base, maxUserIdLength)
+	for n := 2; taken[strings.ToLower(userId)]; n++ {
+		suffix := strconv.Itoa(n)
+		userId = shortenUserId(base, maxUserIdLength-len(suffix)) + suffix
+	}
+	taken[strings.ToLower(userId)] = true
+	return userId
+}
+
+// LudusUserIds returns the userIds of the users in Ludus in lowercase. It is
+// best-effort: if Ludus cannot be asked, the error is logged and nil returned.
+func LudusUserIds(client LudusClient) map[string]bool {
+	users, err := client.ListUsers()
+	if err != nil {
+		log.Printf("Failed to list Ludus users, generated userIds are only checked against pools: %v", err)
+		return nil
+	}
+
+	userIds := make(map[string]bool, len(users))
+	for _, user := range users {
+		userIds[strings.ToLower(user.UserID)] = true
+	}
+	return userIds
+}
+
+// UserIdsInUse returns the userIds generated userIds must not collide with, in
+// lowercase: members and main users of all pools and ludusUserIds
+func UserIdsInUse(ludusUserIds map[string]bool) (map[string]bool, error) {
+	poolUserIds, err := store.PoolUserIds()
+	if err != nil {
+		return nil, err
+	}
+
+	taken := make(map[string]bool)
+	for userId := range poolUserIds {
+		taken[strings.ToLower(userId)] = true
+	}
+	for userId := range ludusUserIds {
+		taken[userId] = true
+	}
+	return taken, nil
+}
+
+// MemberLoginName returns the name a member logs in with outside Ludus, e.g. in
+// CTFd: a generated userId without "BATCH", otherwise the user name without spaces
+func MemberLoginName(user PoolUser) string {
+	if loginName, generated := strings.CutPrefix(user.UserId, "BATCH"); generated && loginName != "" {
+		return loginName
+	}
+	return strings.ReplaceAll(user.User, " ", "")
+}
+
+// PoolMembersResponse returns members for a response, an empty list instead of nil
+func PoolMembersResponse(members []PoolUser) []PoolUser {
+	if members == nil {
+		return []PoolUser{}
+	}
+	return members
+}
+
+// ProcessUsersAndTeams trims the user names of usersAndTeams, which are kept as
+// display names, and gives members without a userId one generated from their name.
+// Generated userIds differ from each other, from the userIds in the list and from
+// taken, to which they are added.
+func ProcessUsersAndTeams(usersAndTeams []interface{}, taken map[string]bool) []interface{} {
 	var processed []interface{}
 
+	// Members already in a pool keep their userId, also after a rename
+	for _, item := range usersAndTeams {
+		if itemMap, ok := item.(map[string]interface{}); ok {
+			if userId, _ := itemMap["userId"].(string); userId != "" {
+				taken[strings.ToLower(userId)] = true
+			}
+		}
+	}
+
 	for _, item := range usersAndTeams {
 		if itemMap, ok := item.(map[string]interface{}); ok {
 			// Create a copy of the item
@@ -80,12 +171,10 @@ func ProcessUsersAndTeams(usersAndTeams []interface{}) []interface{} {
 
 			// Add userId based on user field
 			if user, exists := itemMap["user"].(string); exists {
-				// Normalize user field: lowercase and replace special characters, keep spaces
-				normalizedUser := strings.ToLower(user)
-				cleanUser := replaceSpecialChars(normalizedUser)
-				// Update the user field with cleaned version
-				newItem["user"] = cleanUser
-				newItem["userId"] = generateUserId(cleanUser)
+				newItem["user"] = strings.TrimSpace(user)
+				if userId, _ := itemMap["userId"].(string); userId == "" {
+					newItem["userId"] = generateUserId(user, taken)
+				}
 			}
 
 			processed = append(processed, newItem)
@@ -95,11 +184,32 @@ func ProcessUsersAndTeams(usersAndTeams []interface{}) []interface{} {
 	return processed
 }
 
-// ValidateAndProcessUsersAndTeams validates and processes usersAndTeams in one go
+// needsUserIds reports whether a member of usersAndTeams has no userId yet
+func needsUserIds(usersAndTeams []interface{}) bool {
+	for _, item := range usersAndTeams {
+		if itemMap, ok := item.(map[string]interface{}); ok {
+			if userId, _ := itemMap["userId"].(string); userId == "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ValidateAndProcessUsersAndTeams validates and processes usersAndTeams in one go.
+// Generated userIds avoid the userIds of pools and ludusUserIds (see LudusUserIds).
 // Schema already validates: mainUserId required for SHARED, not allowed for INDIVIDUAL
-func ValidateAndProcessUsersAndTeams(usersAndTeams []interface{}, poolType string, operation UserRetrievalOption) ([]interface{}, error) {
+func ValidateAndProcessUsersAndTeams(usersAndTeams []interface{}, poolType string, operation UserRetrievalOption, ludusUserIds map[string]bool) ([]interface{}, error) {
 	// Step 1: Process and add userIds
-	processed := ProcessUsersAndTeams(usersAndTeams)
+	taken := make(map[string]bool)
+	if needsUserIds(usersAndTeams) {
+		var err error
+		taken, err = UserIdsInUse(ludusUserIds)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read userIds in use: %w", err)
+		}
+	}
+	processed := ProcessUsersAndTeams(usersAndTeams, taken)
 
 	// Step 2: Validate duplicates and collect IDs
 	teamSet := false
@@ -114,12 +224,12 @@ func ValidateAndProcessUsersAndTeams(usersAndTeams []interface{}, poolType strin
 			return nil, fmt.Errorf("invalid data format")
 		}
 
-		// Check for duplicate usernames
+		// Check for duplicate usernames, regardless of case
 		if user, exists := data["user"].(string); exists {
-			if userSet[user] {
+			if userSet[strings.ToLower(user)] {
 				return nil, fmt.Errorf("duplicate user found: %s", user)
 			}
-			userSet[user] = true
+			userSet[strings.ToLower(user)] = true
 		}
 
 		// Check for duplicate userIds and collect them
@@ -198,26 +308,16 @@ func ValidateAndProcessUsersAndTeams(usersAndTeams []interface{}, poolType strin
 // For shared pools, it retrieves flags only from mainUser and applies them to all users
 // For individual pools, it retrieves flags separately for each user
 func GetUserIdsFromPool(c *gin.Context, poolId string, option UserRetrievalOption) ([]string, bool) {
-	poolPath, ok := ValidateFolderId(c, config.PoolFolder, poolId)
+	pool, ok := ReadPoolWithResponse(c, poolId)
 	if !ok {
 		return nil, false
 	}
 
-	poolJsonPath := filepath.Join(poolPath, "pool.json")
-
-	data, err := os.ReadFile(poolJsonPath)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-		return nil, false
-	}
-
-	var pool Pool
-
-	if err := json.Unmarshal(data, &pool); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal Server Error"})
-		return nil, false
-	}
+	return PoolUserIds(pool, option), true
+}
 
+// PoolUserIds returns the userIds of a pool selected by option
+func PoolUserIds(pool Pool, option UserRetrievalOption) []string {
 	var userIds []string
 
 	if pool.Type == "SHARED" {
@@ -258,7 +358,7 @@ func GetUserIdsFromPool(c *gin.Context, poolId string, option UserRetrievalOptio
 		}
 	}
 
-	return userIds, true
+	return userIds
 }
 
 // ExtractUserIdsAndMainUserIdsFromPool extracts distinct userIds and mainUserIds from pool's usersAndTeams
@@ -289,158 +389,232 @@ func ExtractUserIdsAndMainUserIdsFromPool(pool Pool) ([]string, []string) {
 
 // GetAllMainUsersFromPools gets all main users (mainUserId values) from all pools
 func GetAllMainUsersFromPools() (map[string]bool, error) {
-	mainUsers := make(map[string]bool)
-
-	// Read all directories in the pool folder
-	poolDirs, err := os.ReadDir(config.PoolFolder)
+	mainUsers, err := store.MainUserIds()
 	if err != nil {
-		return nil, fmt.Errorf("failed to read pool folder: %w", err)
+		return nil, fmt.Errorf("failed to read main users: %w", err)
 	}
+	return mainUsers, nil
+}
 
-	for _, dir := range poolDirs {
-		if !dir.IsDir() {
-			continue
-		}
-
-		poolJsonPath := filepath.Join(config.PoolFolder, dir.Name(), "pool.json")
+// GetAllUserIdsFromPools collects all userIds and mainUserIds across all pools
+func GetAllUserIdsFromPools() (map[string]bool, error) {
+	return store.PoolUserIds()
+}
 
-		// Check if pool.json exists
-		if _, err := os.Stat(poolJsonPath); os.IsNotExist(err) {
-			continue
+// IsMainUserAlreadyUsed checks if any of the provided main user IDs are already assigned in any pool
+// It checks if the mainUserIds exist in usersAndTeams either as userId or mainUserId
+func IsMainUserAlreadyUsed(mainUserIds []string) (bool, error) {
+	var ids []string
+	for _, id := range mainUserIds {
+		if id != "" {
+			ids = append(ids, id)
 		}
+	}
 
-		// Read and parse pool.json
-		data, err := os.ReadFile(poolJsonPath)
-		if err != nil {
-			// Skip this pool if we can't read it, don't fail the entire operation
-			continue
-		}
+	if len(ids) == 0 {
+		return false, fmt.Errorf("no valid main user IDs provided")
+	}
 
-		var pool Pool
-		if err := json.Unmarshal(data, &pool); err != nil {
-			// Skip this pool if we can't parse it, don't fail the entire operation
-			continue
-		}
+	return store.AnyUserInPools(ids)
+}
 
-		// Extract all mainUserId values - these are the actual main users
-		for _, userTeam := range pool.UsersAndTeams {
-			if userTeam.MainUserId != "" {
-				mainUsers[userTeam.MainUserId] = true
-			}
-		}
-	}
+// PoolMemberUpdate holds the fields of a pool member to change, nil fields stay as they are
+type PoolMemberUpdate struct {
+	User       *string `json:"user"`
+	Team       *string `json:"team"`
+	MainUserId *string `json:"mainUserId"`
+}
 
-	return mainUsers, nil
+// MemberCleanup selects what is done in Ludus for members removed from a pool
+type MemberCleanup struct {
+	UnshareRange bool `json:"unshareRange"`
+	DestroyRange bool `json:"destroyRange"`
+	DeleteUser   bool `json:"deleteUser"`
 }
 
-// GetAllUserIdsFromPools collects all userIds from usersAndTeams across all pools
-func GetAllUserIdsFromPools(poolFolder string) (map[string]bool, error) {
-	userIds := make(map[string]bool)
+// RemovePoolMembers removes the members with the given userIds from pool and
+// returns them. Unknown userIds fail with ErrNotFound.
+func RemovePoolMembers(pool *Pool, userIds []string) ([]PoolUser, error) {
+	remove := make(map[string]bool, len(userIds))
+	for _, userId := range userIds {
+		remove[userId] = true
+	}
 
-	pools, err := GetAllPools(poolFolder)
-	if err != nil {
-		return nil, err
+	var kept, removed []PoolUser
+	for _, user := range pool.UsersAndTeams {
+		if remove[user.UserId] {
+			removed = append(removed, user)
+			delete(remove, user.UserId)
+		} else {
+			kept = append(kept, user)
+		}
+	}
+	for _, userId := range userIds {
+		if remove[userId] {
+			return nil, fmt.Errorf("member %s %w", userId, ErrNotFound)
+		}
 	}
 
-	for _, pool := range pools {
-		poolMap := pool // pool is already map[string]interface{}
+	pool.UsersAndTeams = kept
+	if err := revalidatePoolMembers(pool); err != nil {
+		return nil, err
+	}
+	return removed, nil
+}
 
-		poolId, ok := poolMap["poolId"].(string)
-		if !ok {
+// UpdatePoolMember renames a member, moves it to another team or gives it another
+// main user. The userId stays the same, so its Ludus user and range are kept.
+// It returns the member before the change.
+func UpdatePoolMember(pool *Pool, userId string, update PoolMemberUpdate) (PoolUser, error) {
+	for i, user := range pool.UsersAndTeams {
+		if user.UserId != userId {
 			continue
 		}
 
-		// Read full pool data
-		poolPath := filepath.Join(poolFolder, poolId)
-		file, err := os.Open(filepath.Join(poolPath, "pool.json"))
-		if err != nil {
-			continue
+		before := user
+		if update.User != nil {
+			user.User = *update.User
 		}
-
-		var poolData map[string]interface{}
-		if err := json.NewDecoder(file).Decode(&poolData); err != nil {
-			file.Close()
-			continue
+		if update.Team != nil {
+			user.Team = *update.Team
 		}
-		file.Close()
-
-		// Extract userIds and mainUserIds from usersAndTeams
-		if usersAndTeams, exists := poolData["usersAndTeams"].([]interface{}); exists {
-			for _, item := range usersAndTeams {
-				if userMap, ok := item.(map[string]interface{}); ok {
-					// Add userId if it exists
-					if userId, exists := userMap["userId"].(string); exists && userId != "" {
-						userIds[userId] = true
-					}
-					// Add mainUserId if it exists (distinct values)
-					if mainUserId, exists := userMap["mainUserId"].(string); exists && mainUserId != "" {
-						userIds[mainUserId] = true
-					}
-				}
+		if update.MainUserId != nil {
+			if pool.Type != "SHARED" {
+				return PoolUser{}, fmt.Errorf("%w: mainUserId is only allowed in SHARED pools", ErrInvalidPool)
 			}
+			user.MainUserId = *update.MainUserId
 		}
-	}
+		pool.UsersAndTeams[i] = user
 
-	return userIds, nil
+		if err := revalidatePoolMembers(pool); err != nil {
+			return PoolUser{}, err
+		}
+		return before, nil
+	}
+	return PoolUser{}, fmt.Errorf("member %s %w", userId, ErrNotFound)
 }
 
-// IsMainUserAlreadyUsed checks if any of the provided main user IDs are already assigned in any pool
-// It checks if the mainUserIds exist in usersAndTeams either as userId or mainUserId
-func IsMainUserAlreadyUsed(mainUserIds []string) (bool, error) {
-	if len(mainUserIds) == 0 {
-		return false, fmt.Errorf("main user IDs array cannot be empty")
+// revalidatePoolMembers runs the changed member list through ValidateAndProcessUsersAndTeams
+func revalidatePoolMembers(pool *Pool) error {
+	members, err := processPoolMembers(pool.UsersAndTeams, pool.Type, OperationAdd, nil)
+	if err != nil {
+		return err
 	}
+	pool.UsersAndTeams = members
+	return nil
+}
 
-	// Create a map for quick lookup
-	mainUserIdMap := make(map[string]bool)
-	for _, id := range mainUserIds {
-		if id != "" {
-			mainUserIdMap[id] = true
+// processPoolMembers checks the mainUserIds of members against the pool type and
+// runs them through ValidateAndProcessUsersAndTeams. Errors wrap ErrInvalidPool.
+func processPoolMembers(users []PoolUser, poolType string, operation UserRetrievalOption, ludusUserIds map[string]bool) ([]PoolUser, error) {
+	for _, user := range users {
+		if (poolType == "SHARED") != (user.MainUserId != "") {
+			return nil, fmt.Errorf("%w: mainUserId is required in SHARED pools and not allowed in INDIVIDUAL pools", ErrInvalidPool)
 		}
 	}
 
-	if len(mainUserIdMap) == 0 {
-		return false, fmt.Errorf("no valid main user IDs provided")
+	members, err := json.Marshal(users)
+	if err != nil {
+		return nil, err
+	}
+	var usersAndTeams []interface{}
+	if err := json.Unmarshal(members, &usersAndTeams); err != nil {
+		return nil, err
 	}
 
-	// Read all directories in the pool folder
-	poolDirs, err := os.ReadDir(config.PoolFolder)
+	processed, err := ValidateAndProcessUsersAndTeams(usersAndTeams, poolType, operation, ludusUserIds)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPool, err)
+	}
+	processedUsers, err := PoolUsersFromMaps(processed)
 	if err != nil {
-		return false, fmt.Errorf("failed to read pool folder: %w", err)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidPool, err)
 	}
+	return processedUsers, nil
+}
 
-	for _, dir := range poolDirs {
-		if !dir.IsDir() {
-			continue
-		}
+// CleanupRemovedMembers undoes in Ludus what belonged to members removed from a
+// pool: their access to the main user's range is revoked (SHARED pools), their
+// own range is destroyed (INDIVIDUAL pools) and their Ludus user is deleted,
+// each as selected. A user is only deleted once its range is destroyed.
+// The results are returned per step.
+func CleanupRemovedMembers(ctx context.Context, client LudusClient, pool Pool, removed []PoolUser, cleanup MemberCleanup) map[string][]LudusResponse {
+	results := make(map[string][]LudusResponse)
 
-		poolJsonPath := filepath.Join(config.PoolFolder, dir.Name(), "pool.json")
+	if cleanup.UnshareRange && pool.Type == "SHARED" {
+		results["unshare"] = RunConcurrentTasks(unshareTasks(client, removed), config.MaxConcurrentRequests)
+	}
 
-		// Check if pool.json exists
-		if _, err := os.Stat(poolJsonPath); os.IsNotExist(err) {
-			continue
-		}
+	userIds := make([]string, 0, len(removed))
+	for _, user := range removed {
+		userIds = append(userIds, user.UserId)
+	}
 
-		// Read and parse pool.json
-		data, err := os.ReadFile(poolJsonPath)
-		if err != nil {
-			// Skip this pool if we can't read it, don't fail the entire operation
-			continue
-		}
+	deletable := userIds
+	if cleanup.DestroyRange && pool.Type != "SHARED" {
+		responses := destroyRanges(client, userIds)
+		results["destroy"] = responses
 
-		var pool Pool
-		if err := json.Unmarshal(data, &pool); err != nil {
-			// Skip this pool if we can't parse it, don't fail the entire operation
-			continue
+		deletable = nil
+		for _, resp := range responses {
+			if resp.Error == nil {
+				deletable = append(deletable, resp.UserID)
+			}
+		}
+		if cleanup.DeleteUser && len(deletable) > 0 {
+			policy := DefaultDeployPolicy()
+			timedOut := WaitForBatchDestroyed(ctx, client.WithContext(ctx), deletable, policy.WaitOptions(nil, policy.BatchDeadline(time.Now())))
+			deletable = removeIds(deletable, timedOut)
 		}
+	}
 
-		// Check if any of the mainUserIds exist as userId or mainUserId in usersAndTeams
-		for _, userTeam := range pool.UsersAndTeams {
-			if mainUserIdMap[userTeam.UserId] || mainUserIdMap[userTeam.MainUserId] {
-				return true, nil
-			}
+	if cleanup.DeleteUser && ctx.Err() == nil {
+		results["delete"] = RunConcurrentTasks(UserTasks(deletable, func(userId string) (interface{}, error) {
+			return client.DeleteUser(userId)
+		}), config.MaxConcurrentRequests)
+	}
+
+	return results
+}
+
+// unshareTasks builds one task per member that revokes its access to its main user's range
+func unshareTasks(client LudusClient, members []PoolUser) []LudusTask {
+	var tasks []LudusTask
+	for _, user := range members {
+		if user.MainUserId != "" {
+			mainUserId, userId := user.MainUserId, user.UserId
+			tasks = append(tasks, LudusTask{
+				UserID: userId,
+				Call:   func() (interface{}, error) { return client.RevokeAccess(mainUserId, userId) },
+			})
 		}
 	}
+	return tasks
+}
+
+// MoveMemberSharing moves the access of a member from the old to the new main user's
+// range and returns the results per step
+func MoveMemberSharing(client LudusClient, userId, oldMainUserId, newMainUserId string) map[string][]LudusResponse {
+	revoked, err := client.RevokeAccess(oldMainUserId, userId)
+	results := map[string][]LudusResponse{
+		"unshare": {{UserID: userId, Response: revoked, Error: err}},
+	}
+	granted, err := client.GrantAccess(newMainUserId, userId)
+	results["share"] = []LudusResponse{{UserID: userId, Response: granted, Error: err}}
+	return results
+}
 
-	return false, nil
+// removeIds returns ids without the ones in drop
+func removeIds(ids, drop []string) []string {
+	dropSet := make(map[string]bool, len(drop))
+	for _, id := range drop {
+		dropSet[id] = true
+	}
+	var kept []string
+	for _, id := range ids {
+		if !dropSet[id] {
+			kept = append(kept, id)
+		}
+	}
+	return kept
 }
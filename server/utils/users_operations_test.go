@@ -0,0 +1,106 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGenerateUserId(t *testing.T) {
+	tests := []struct {
+		name   string
+		user   string
+		taken  []string
+		userId string
+	}{
+		{name: "plain name", user: "Jan Novak", userId: "BATCHjannovak"},
+		{name: "diacritics are stripped", user: "Ján Novák", userId: "BATCHjannovak"},
+		{name: "special characters are removed", user: "O'Brien-Smith", userId: "BATCHobriensmith"},
+		{name: "taken userId is numbered", user: "Ján Novák", taken: []string{"batchjannovak"}, userId: "BATCHjannovak2"},
+		{name: "numbers count up", user: "Jan Novak", taken: []string{"batchjannovak", "batchjannovak2"}, userId: "BATCHjannovak3"},
+		{name: "19 characters are kept", user: "Maximilian Alex", userId: "BATCHmaximilianalex"},
+		{name: "long name is shortened", user: "Maximilian Alexander Schmidt", userId: "BATCHmaximilianalex"},
+		{name: "suffix replaces the end", user: "Maximilian Alexander Schmidt", taken: []string{"batchmaximilianalex"}, userId: "BATCHmaximilianale2"},
+		{name: "two digit suffix", user: "Maximilian Alexander Schmidt", taken: []string{
+			"batchmaximilianalex", "batchmaximilianale2", "batchmaximilianale3", "batchmaximilianale4", "batchmaximilianale5",
+			"batchmaximilianale6", "batchmaximilianale7", "batchmaximilianale8", "batchmaximilianale9",
+		}, userId: "BATCHmaximilianal10"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			taken := make(map[string]bool)
+			for _, userId := range tt.taken {
+				taken[userId] = true
+			}
+			userId := generateUserId(tt.user, taken)
+			if userId != tt.userId {
+				t.Fatalf("expected %s, got %s", tt.userId, userId)
+			}
+			if len(userId) > maxUserIdLength || !taken[strings.ToLower(userId)] {
+				t.Fatalf("userId %s is too long or was not marked as taken", userId)
+			}
+		})
+	}
+}
+
+// testMembers builds the usersAndTeams of a request from user names
+func testMembers(users ...string) []interface{} {
+	var members []interface{}
+	for _, user := range users {
+		members = append(members, map[string]interface{}{"user": user})
+	}
+	return members
+}
+
+// memberUserIds returns the userIds of processed usersAndTeams
+func memberUserIds(members []interface{}) []string {
+	var userIds []string
+	for _, member := range members {
+		userId, _ := member.(map[string]interface{})["userId"].(string)
+		userIds = append(userIds, userId)
+	}
+	return userIds
+}
+
+func TestProcessUsersAndTeams(t *testing.T) {
+	members := append(testMembers(" Ján Novák ", "Jan Novak"),
+		map[string]interface{}{"user": "Renamed", "userId": "BATCHjannovak3"})
+
+	processed := ProcessUsersAndTeams(members, make(map[string]bool))
+	expected := []string{"BATCHjannovak", "BATCHjannovak2", "BATCHjannovak3"}
+	if userIds := memberUserIds(processed); !reflect.DeepEqual(userIds, expected) {
+		t.Fatalf("expected %v, got %v", expected, userIds)
+	}
+	if user := processed[0].(map[string]interface{})["user"]; user != "Ján Novák" {
+		t.Fatalf("expected the trimmed display name, got %q", user)
+	}
+	if _, changed := members[0].(map[string]interface{})["userId"]; changed {
+		t.Fatal("the request members were changed")
+	}
+
+	// The same names and taken userIds give the same userIds
+	again := memberUserIds(ProcessUsersAndTeams(members, make(map[string]bool)))
+	if !reflect.DeepEqual(again, expected) {
+		t.Fatalf("expected the same userIds again, got %v", again)
+	}
+}
+
+func TestValidateAndProcessUsersAndTeamsAvoidsUserIdsInUse(t *testing.T) {
+	s := useTestStore(t)
+	_, err := s.CreatePool(Pool{CreatedBy: "ADMIN", TopologyId: "topo", Type: "INDIVIDUAL",
+		UsersAndTeams: []PoolUser{{User: "Jan Novak", UserId: "BATCHjannovak"}}})
+	if err != nil {
+		t.Fatalf("create pool: %v", err)
+	}
+	ludusUserIds := map[string]bool{"batchjannovak2": true, "batchmaximilianalex": true}
+
+	processed, err := ValidateAndProcessUsersAndTeams(testMembers("Ján Novák", "Maximilian Alexander Schmidt"), "INDIVIDUAL", OperationCreate, ludusUserIds)
+	if err != nil {
+		t.Fatalf("process members: %v", err)
+	}
+	expected := []string{"BATCHjannovak3", "BATCHmaximilianale2"}
+	if userIds := memberUserIds(processed); !reflect.DeepEqual(userIds, expected) {
+		t.Fatalf("expected %v, got %v", expected, userIds)
+	}
+}